@@ -12,7 +12,6 @@ export CGO_ENABLED=${CGO_ENABLED:-0}
 
 TARGETS=$(for d in "$@"; do echo ./$d/...; done)
 
-go test -i -installsuffix "static" ${TARGETS}
 go test -v -installsuffix "static" -coverprofile=.coverage/coverage.out -timeout 30s -p 1 ${TARGETS}
 go tool cover -html=.coverage/coverage.out -o .coverage/coverage.html
 go tool cover -func=.coverage/coverage.out
@@ -0,0 +1,307 @@
+package archive
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/pkg/errors"
+)
+
+const (
+	// NDJSON is the format name for newline delimited JSON archives, where each
+	// line contains a single JSON encoded event.
+	NDJSON = "ndjson"
+
+	// ProtobufDelimited is the format name for archives containing a sequence of
+	// varint length prefixed protocol buffer messages, as described in
+	// archive.proto.
+	ProtobufDelimited = "protobuf-delimited"
+
+	// CSV is the format name for archives written as comma separated values with
+	// a header row. Event data is base64 encoded.
+	CSV = "csv"
+)
+
+// Formats is the list of all supported archive formats.
+var Formats = []string{NDJSON, ProtobufDelimited, CSV}
+
+// csvHeader is the header row written to CSV archives.
+var csvHeader = []string{"id", "community_id", "device_token", "recorded_at", "data"}
+
+// Record is a single encrypted event as stored within an archive.
+type Record struct {
+	ID          int64     `json:"id"`
+	CommunityID string    `json:"communityId"`
+	DeviceToken string    `json:"deviceToken"`
+	RecordedAt  time.Time `json:"recordedAt"`
+	Data        []byte    `json:"data"`
+}
+
+// Manifest is written alongside an archive, and describes its contents so that
+// the archive can be verified before being imported elsewhere.
+type Manifest struct {
+	CommunityID string    `json:"communityId"`
+	Format      string    `json:"format"`
+	StartTime   time.Time `json:"startTime"`
+	EndTime     time.Time `json:"endTime"`
+	Count       int64     `json:"count"`
+	SHA256      string    `json:"sha256"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Writer is the interface implemented by our archive format writers.
+type Writer interface {
+	// Write appends a single record to the archive.
+	Write(r *Record) error
+
+	// Close flushes any buffered data. It does not close the underlying writer.
+	Close() error
+}
+
+// NewWriter returns a Writer for the given format that writes to w, or an error
+// if the format is not supported.
+func NewWriter(format string, w io.Writer) (Writer, error) {
+	switch format {
+	case NDJSON:
+		return &ndjsonWriter{enc: json.NewEncoder(w)}, nil
+	case ProtobufDelimited:
+		return &protobufWriter{w: w}, nil
+	case CSV:
+		cw := csv.NewWriter(w)
+		err := cw.Write(csvHeader)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to write csv header")
+		}
+		return &csvWriter{w: cw}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+// Reader is the interface implemented by our archive format readers.
+type Reader interface {
+	// Read returns the next record from the archive, or io.EOF once all records
+	// have been read.
+	Read() (*Record, error)
+}
+
+// NewReader returns a Reader for the given format that reads from r, or an
+// error if the format is not supported.
+func NewReader(format string, r io.Reader) (Reader, error) {
+	switch format {
+	case NDJSON:
+		return &ndjsonReader{dec: json.NewDecoder(r)}, nil
+	case ProtobufDelimited:
+		return &protobufReader{r: bufio.NewReader(r)}, nil
+	case CSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = len(csvHeader)
+
+		header, err := cr.Read()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read csv header")
+		}
+
+		for i, name := range csvHeader {
+			if header[i] != name {
+				return nil, fmt.Errorf("unexpected csv header: %s", strings.Join(header, ","))
+			}
+		}
+
+		return &csvReader{r: cr}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+// ndjsonWriter writes records as newline delimited JSON.
+type ndjsonWriter struct {
+	enc *json.Encoder
+}
+
+func (n *ndjsonWriter) Write(r *Record) error {
+	return n.enc.Encode(r)
+}
+
+func (n *ndjsonWriter) Close() error {
+	return nil
+}
+
+// ndjsonReader reads records from newline delimited JSON.
+type ndjsonReader struct {
+	dec *json.Decoder
+}
+
+func (n *ndjsonReader) Read() (*Record, error) {
+	var r Record
+
+	err := n.dec.Decode(&r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "failed to decode record")
+	}
+
+	return &r, nil
+}
+
+// protobufWriter writes records as length delimited protocol buffer messages.
+type protobufWriter struct {
+	w io.Writer
+}
+
+func (p *protobufWriter) Write(r *Record) error {
+	ts, err := ptypes.TimestampProto(r.RecordedAt)
+	if err != nil {
+		return errors.Wrap(err, "failed to convert timestamp")
+	}
+
+	b, err := proto.Marshal(&pbRecord{
+		Id:          r.ID,
+		CommunityId: r.CommunityID,
+		DeviceToken: r.DeviceToken,
+		RecordedAt:  ts,
+		Data:        r.Data,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal record")
+	}
+
+	_, err = p.w.Write(proto.EncodeVarint(uint64(len(b))))
+	if err != nil {
+		return err
+	}
+
+	_, err = p.w.Write(b)
+	return err
+}
+
+func (p *protobufWriter) Close() error {
+	return nil
+}
+
+// protobufReader reads records from length delimited protocol buffer messages.
+type protobufReader struct {
+	r *bufio.Reader
+}
+
+func (p *protobufReader) Read() (*Record, error) {
+	size, err := binary.ReadUvarint(p.r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "failed to read message length")
+	}
+
+	b := make([]byte, size)
+
+	_, err = io.ReadFull(p.r, b)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read message")
+	}
+
+	var m pbRecord
+
+	err = proto.Unmarshal(b, &m)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal record")
+	}
+
+	recordedAt, err := ptypes.Timestamp(m.RecordedAt)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to convert timestamp")
+	}
+
+	return &Record{
+		ID:          m.Id,
+		CommunityID: m.CommunityId,
+		DeviceToken: m.DeviceToken,
+		RecordedAt:  recordedAt,
+		Data:        m.Data,
+	}, nil
+}
+
+// csvWriter writes records as CSV rows.
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) Write(r *Record) error {
+	return c.w.Write([]string{
+		strconv.FormatInt(r.ID, 10),
+		r.CommunityID,
+		r.DeviceToken,
+		r.RecordedAt.UTC().Format(time.RFC3339Nano),
+		base64.StdEncoding.EncodeToString(r.Data),
+	})
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// csvReader reads records from CSV rows.
+type csvReader struct {
+	r *csv.Reader
+}
+
+func (c *csvReader) Read() (*Record, error) {
+	row, err := c.r.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, errors.Wrap(err, "failed to read csv row")
+	}
+
+	id, err := strconv.ParseInt(row[0], 10, 64)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse id")
+	}
+
+	recordedAt, err := time.Parse(time.RFC3339Nano, row[3])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse recorded_at")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(row[4])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode data")
+	}
+
+	return &Record{
+		ID:          id,
+		CommunityID: row[1],
+		DeviceToken: row[2],
+		RecordedAt:  recordedAt,
+		Data:        data,
+	}, nil
+}
+
+// pbRecord is the protocol buffer message used for protobuf-delimited
+// archives. It is hand written to match the message defined in archive.proto.
+type pbRecord struct {
+	Id          int64                `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	CommunityId string               `protobuf:"bytes,2,opt,name=community_id,json=communityId,proto3" json:"community_id,omitempty"`
+	DeviceToken string               `protobuf:"bytes,3,opt,name=device_token,json=deviceToken,proto3" json:"device_token,omitempty"`
+	RecordedAt  *timestamp.Timestamp `protobuf:"bytes,4,opt,name=recorded_at,json=recordedAt,proto3" json:"recorded_at,omitempty"`
+	Data        []byte               `protobuf:"bytes,5,opt,name=data,proto3" json:"data,omitempty"`
+}
+
+func (m *pbRecord) Reset()         { *m = pbRecord{} }
+func (m *pbRecord) String() string { return proto.CompactTextString(m) }
+func (*pbRecord) ProtoMessage()    {}
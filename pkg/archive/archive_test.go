@@ -0,0 +1,135 @@
+package archive_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotstore/pkg/archive"
+)
+
+var records = []*archive.Record{
+	{
+		ID:          1,
+		CommunityID: "abc123",
+		DeviceToken: "device-a",
+		RecordedAt:  time.Date(2019, 1, 2, 3, 4, 5, 6000, time.UTC),
+		Data:        []byte("encrypted bytes"),
+	},
+	{
+		ID:          2,
+		CommunityID: "abc123",
+		DeviceToken: "device-b",
+		RecordedAt:  time.Date(2019, 1, 2, 3, 4, 6, 0, time.UTC),
+		Data:        []byte{0x00, 0xff, 0x10},
+	},
+}
+
+func writeArchive(t *testing.T, format string) *bytes.Buffer {
+	var buf bytes.Buffer
+
+	w, err := archive.NewWriter(format, &buf)
+	assert.Nil(t, err)
+
+	for _, r := range records {
+		assert.Nil(t, w.Write(r))
+	}
+	assert.Nil(t, w.Close())
+
+	return &buf
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	buf := writeArchive(t, archive.NDJSON)
+
+	dec := json.NewDecoder(buf)
+	for _, expected := range records {
+		var got archive.Record
+		assert.Nil(t, dec.Decode(&got))
+		assert.Equal(t, expected.ID, got.ID)
+		assert.Equal(t, expected.DeviceToken, got.DeviceToken)
+		assert.True(t, expected.RecordedAt.Equal(got.RecordedAt))
+		assert.Equal(t, expected.Data, got.Data)
+	}
+	assert.False(t, dec.More())
+}
+
+func TestCSVWriter(t *testing.T) {
+	buf := writeArchive(t, archive.CSV)
+
+	rows, err := csv.NewReader(buf).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, [][]string{
+		{"id", "community_id", "device_token", "recorded_at", "data"},
+		{"1", "abc123", "device-a", "2019-01-02T03:04:05.000006Z", "ZW5jcnlwdGVkIGJ5dGVz"},
+		{"2", "abc123", "device-b", "2019-01-02T03:04:06Z", "AP8Q"},
+	}, rows)
+}
+
+func TestProtobufDelimitedWriter(t *testing.T) {
+	buf := writeArchive(t, archive.ProtobufDelimited)
+	b := buf.Bytes()
+
+	messages := 0
+	for len(b) > 0 {
+		size, n := proto.DecodeVarint(b)
+		assert.NotZero(t, n)
+		assert.True(t, int(size) <= len(b)-n)
+		b = b[n+int(size):]
+		messages++
+	}
+	assert.Equal(t, len(records), messages)
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range archive.Formats {
+		t.Run(format, func(t *testing.T) {
+			buf := writeArchive(t, format)
+
+			r, err := archive.NewReader(format, buf)
+			assert.Nil(t, err)
+
+			for _, expected := range records {
+				got, err := r.Read()
+				assert.Nil(t, err)
+				assert.Equal(t, expected.ID, got.ID)
+				assert.Equal(t, expected.CommunityID, got.CommunityID)
+				assert.Equal(t, expected.DeviceToken, got.DeviceToken)
+				assert.True(t, expected.RecordedAt.Equal(got.RecordedAt))
+				assert.Equal(t, expected.Data, got.Data)
+			}
+
+			_, err = r.Read()
+			assert.Equal(t, io.EOF, err)
+		})
+	}
+}
+
+func TestTruncatedArchive(t *testing.T) {
+	buf := writeArchive(t, archive.ProtobufDelimited)
+	buf.Truncate(buf.Len() - 1)
+
+	r, err := archive.NewReader(archive.ProtobufDelimited, buf)
+	assert.Nil(t, err)
+
+	_, err = r.Read()
+	assert.Nil(t, err)
+
+	_, err = r.Read()
+	assert.NotNil(t, err)
+	assert.NotEqual(t, io.EOF, err)
+}
+
+func TestUnsupportedFormat(t *testing.T) {
+	_, err := archive.NewWriter("xml", &bytes.Buffer{})
+	assert.NotNil(t, err)
+
+	_, err = archive.NewReader("xml", &bytes.Buffer{})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,158 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	minio "github.com/minio/minio-go"
+	"github.com/minio/minio-go/pkg/credentials"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultS3Region is the region used to sign requests if none is given.
+	defaultS3Region = "us-east-1"
+
+	// defaultS3Endpoint is the endpoint used if none is given.
+	defaultS3Endpoint = "https://s3.amazonaws.com"
+
+	// s3Timeout is the maximum time we wait for any single request to S3.
+	s3Timeout = time.Minute
+
+	// noSuchKey is the S3 error code returned for a missing object.
+	noSuchKey = "NoSuchKey"
+)
+
+// s3Store is a Store that keeps objects in a bucket on an S3 compatible
+// endpoint such as AWS S3 or MinIO.
+type s3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// newS3Store returns a store for the bucket and optional key prefix in the
+// given s3:// URL. The endpoint and region query parameters configure the
+// server to talk to; path style requests are used so that any S3 compatible
+// endpoint may be used.
+func newS3Store(u *url.URL) (*s3Store, error) {
+	if u.Host == "" {
+		return nil, errors.New("cold storage url must include a bucket")
+	}
+
+	region := u.Query().Get("region")
+	if region == "" {
+		region = defaultS3Region
+	}
+
+	endpoint := u.Query().Get("endpoint")
+	if endpoint == "" {
+		endpoint = defaultS3Endpoint
+	}
+
+	e, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse s3 endpoint")
+	}
+
+	if e.Scheme != "http" && e.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported s3 endpoint scheme: %s", e.Scheme)
+	}
+
+	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set to use s3 cold storage")
+	}
+
+	client, err := minio.NewWithOptions(e.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure:       e.Scheme == "https",
+		Region:       region,
+		BucketLookup: minio.BucketLookupPath,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create s3 client")
+	}
+
+	return &s3Store{
+		client: client,
+		bucket: u.Host,
+		prefix: strings.Trim(u.Path, "/"),
+	}, nil
+}
+
+// Put uploads the object, first checking that it does not already exist as
+// segments are immutable.
+func (s *s3Store) Put(ctx context.Context, key string, data []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, s3Timeout)
+	defer cancel()
+
+	key = s.key(key)
+
+	_, err := s.client.StatObject(s.bucket, key, minio.StatObjectOptions{})
+	if err == nil {
+		return fmt.Errorf("s3 object already exists: %s", key)
+	}
+
+	if minio.ToErrorResponse(err).Code != noSuchKey {
+		return errors.Wrap(err, "failed to stat s3 object")
+	}
+
+	_, err = s.client.PutObjectWithContext(ctx, s.bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to put s3 object")
+	}
+
+	return nil
+}
+
+// Get downloads the object.
+func (s *s3Store) Get(ctx context.Context, key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, s3Timeout)
+	defer cancel()
+
+	obj, err := s.client.GetObjectWithContext(ctx, s.bucket, s.key(key), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get s3 object")
+	}
+	defer obj.Close()
+
+	b, err := ioutil.ReadAll(obj)
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == noSuchKey {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "failed to read s3 object")
+	}
+
+	return b, nil
+}
+
+// Delete removes the object. S3 reports success when deleting a missing
+// object.
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	err := s.client.RemoveObject(s.bucket, s.key(key))
+	if err != nil {
+		return errors.Wrap(err, "failed to delete s3 object")
+	}
+
+	return nil
+}
+
+// key returns the full object name for key within the configured prefix.
+func (s *s3Store) key(key string) string {
+	if s.prefix != "" {
+		return s.prefix + "/" + key
+	}
+	return key
+}
@@ -0,0 +1,110 @@
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// SegmentKey returns the key under which the cold storage segment containing a
+// community's events for the given day is stored. The IDs of the first and
+// last events are included so that events arriving late for a day that has
+// already been archived are written to a new segment rather than replacing the
+// existing one.
+func SegmentKey(communityID string, day time.Time, firstID, lastID int64) string {
+	day = day.UTC()
+
+	return fmt.Sprintf(
+		"%s/%04d/%02d/%02d/%d-%d.pb.gz",
+		escapeKey(communityID),
+		day.Year(), day.Month(), day.Day(),
+		firstID, lastID,
+	)
+}
+
+// escapeKey percent encodes every byte of s other than the unreserved
+// characters defined by RFC 3986, so that community IDs cannot introduce
+// extra path segments into a key. Dots are also encoded, so a community ID can
+// never produce a "." or ".." segment.
+func escapeKey(s string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '~':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// EncodeSegment returns the given records as a gzip compressed archive in the
+// protobuf-delimited format.
+func EncodeSegment(records []*Record) ([]byte, error) {
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+
+	w, err := NewWriter(ProtobufDelimited, gz)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, r := range records {
+		err = w.Write(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to write segment record")
+		}
+	}
+
+	err = w.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to close segment")
+	}
+
+	err = gz.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compress segment")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DecodeSegment returns the records contained in a segment created by
+// EncodeSegment.
+func DecodeSegment(b []byte) ([]*Record, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decompress segment")
+	}
+	defer gz.Close()
+
+	r, err := NewReader(ProtobufDelimited, gz)
+	if err != nil {
+		return nil, err
+	}
+
+	records := []*Record{}
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read segment record")
+		}
+
+		records = append(records, record)
+	}
+}
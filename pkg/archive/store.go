@@ -0,0 +1,171 @@
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotFound is returned by a Store when the requested object does not exist.
+var ErrNotFound = errors.New("archive object not found")
+
+// Store is the interface implemented by the backends in which we keep cold
+// storage segments. Objects are immutable once written: Put fails if an object
+// already exists under the given key.
+type Store interface {
+	// Put writes the object under the given key.
+	Put(ctx context.Context, key string, data []byte) error
+
+	// Get returns the object stored under the given key, or ErrNotFound.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Delete removes the object stored under the given key. Deleting an object
+	// that does not exist is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// NewStore returns a Store for the given URL. A URL with the s3 scheme, e.g.
+// s3://bucket/prefix?endpoint=http://localhost:9000&region=us-east-1, returns a
+// store that writes to an S3 compatible endpoint, with credentials read from
+// the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables. A URL
+// with the file scheme, or a plain path, returns a store that writes to the
+// local filesystem.
+func NewStore(rawurl string) (Store, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse cold storage url")
+	}
+
+	switch u.Scheme {
+	case "s3":
+		return newS3Store(u)
+	case "file":
+		return NewFileStore(u.Path)
+	case "":
+		return NewFileStore(rawurl)
+	default:
+		return nil, fmt.Errorf("unsupported cold storage scheme: %s", u.Scheme)
+	}
+}
+
+// FileStore is a Store that keeps objects as files beneath a directory on the
+// local filesystem.
+type FileStore struct {
+	dir string
+}
+
+// NewFileStore returns a FileStore rooted at the given directory, creating the
+// directory if required.
+func NewFileStore(dir string) (*FileStore, error) {
+	if dir == "" {
+		return nil, errors.New("cold storage path must not be empty")
+	}
+
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create cold storage directory")
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+// Put writes the object to a temporary file which is then linked into place,
+// so that readers never see a partially written object and existing objects
+// are never replaced. The file is made read only.
+func (f *FileStore) Put(ctx context.Context, key string, data []byte) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return errors.Wrap(err, "failed to create segment directory")
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary file")
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to write segment")
+	}
+
+	err = os.Chmod(tmp.Name(), 0444)
+	if err != nil {
+		return errors.Wrap(err, "failed to make segment read only")
+	}
+
+	// unlike rename, link fails if the target already exists
+	err = os.Link(tmp.Name(), path)
+	if err != nil {
+		return errors.Wrap(err, "failed to store segment")
+	}
+
+	return nil
+}
+
+// Get reads the object from the filesystem.
+func (f *FileStore) Get(ctx context.Context, key string) ([]byte, error) {
+	path, err := f.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, errors.Wrap(err, "failed to read segment")
+	}
+
+	return b, nil
+}
+
+// Delete removes the object from the filesystem.
+func (f *FileStore) Delete(ctx context.Context, key string) error {
+	path, err := f.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "failed to delete segment")
+	}
+
+	return nil
+}
+
+// path returns the filesystem path for the given key, ensuring the key cannot
+// escape the store's directory.
+func (f *FileStore) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" {
+		return "", fmt.Errorf("invalid archive key: %s", key)
+	}
+
+	for _, segment := range strings.Split(key, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("invalid archive key: %s", key)
+		}
+	}
+
+	return filepath.Join(f.dir, filepath.FromSlash(clean)), nil
+}
@@ -0,0 +1,197 @@
+package archive_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotstore/pkg/archive"
+)
+
+func testStore(t *testing.T, store archive.Store) {
+	ctx := context.Background()
+	key := archive.SegmentKey("abc 123/x", time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC), 1, 2)
+
+	_, err := store.Get(ctx, key)
+	assert.Equal(t, archive.ErrNotFound, err)
+
+	err = store.Put(ctx, key, []byte("segment"))
+	assert.Nil(t, err)
+
+	// segments are immutable
+	err = store.Put(ctx, key, []byte("replaced"))
+	assert.NotNil(t, err)
+
+	b, err := store.Get(ctx, key)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("segment"), b)
+
+	err = store.Delete(ctx, key)
+	assert.Nil(t, err)
+
+	_, err = store.Get(ctx, key)
+	assert.Equal(t, archive.ErrNotFound, err)
+
+	err = store.Delete(ctx, key)
+	assert.Nil(t, err)
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coldstore")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := archive.NewStore("file://" + dir)
+	assert.Nil(t, err)
+
+	testStore(t, store)
+
+	_, err = store.Get(context.Background(), "../outside")
+	assert.NotNil(t, err)
+
+	_, err = store.Get(context.Background(), "abc/../../outside")
+	assert.NotNil(t, err)
+
+	// dots in community IDs are escaped, so they neither escape the directory
+	// nor make the key invalid
+	for _, communityID := range []string{"a..b", "..", "."} {
+		key := archive.SegmentKey(communityID, time.Date(2019, 1, 2, 0, 0, 0, 0, time.UTC), 1, 2)
+
+		err = store.Put(context.Background(), key, []byte("segment"))
+		assert.Nil(t, err)
+
+		b, err := store.Get(context.Background(), key)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("segment"), b)
+	}
+
+	// keys written before dots were escaped can still be read
+	_, err = store.Get(context.Background(), "a..b/2019/01/02/1-2.pb.gz")
+	assert.Equal(t, archive.ErrNotFound, err)
+}
+
+// fakeS3 is a minimal in memory implementation of the parts of the S3 API we
+// use, which checks that requests are signed.
+type fakeS3 struct {
+	sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=access/") || r.Header.Get("X-Amz-Date") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	key := r.URL.EscapedPath()
+
+	switch r.Method {
+	case http.MethodPut:
+		b, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("X-Amz-Content-Sha256") == "STREAMING-AWS4-HMAC-SHA256-PAYLOAD" {
+			b = decodeChunked(b)
+		}
+		f.objects[key] = b
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		b, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("ETag", `"etag"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		if r.Method == http.MethodGet {
+			w.Write(b)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// decodeChunked strips the chunk headers from a body uploaded with streaming
+// signatures, i.e. "<size>;chunk-signature=<sig>\r\n<data>\r\n" repeated.
+func decodeChunked(b []byte) []byte {
+	var out []byte
+
+	for len(b) > 0 {
+		i := bytes.Index(b, []byte("\r\n"))
+		if i < 0 {
+			break
+		}
+
+		header := strings.SplitN(string(b[:i]), ";", 2)
+		size, err := strconv.ParseInt(header[0], 16, 64)
+		if err != nil || size == 0 {
+			break
+		}
+
+		b = b[i+2:]
+		out = append(out, b[:size]...)
+		b = b[size+2:]
+	}
+
+	return out
+}
+
+func TestS3Store(t *testing.T) {
+	fake := &fakeS3{objects: map[string][]byte{}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "access")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	store, err := archive.NewStore("s3://bucket/prefix?endpoint=" + srv.URL)
+	assert.Nil(t, err)
+
+	testStore(t, store)
+
+	err = store.Put(context.Background(), "abc/1.pb.gz", []byte("segment"))
+	assert.Nil(t, err)
+	assert.Contains(t, fake.objects, "/bucket/prefix/abc/1.pb.gz")
+}
+
+func TestS3StoreRequiresCredentials(t *testing.T) {
+	os.Unsetenv("AWS_ACCESS_KEY_ID")
+	os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	_, err := archive.NewStore("s3://bucket")
+	assert.NotNil(t, err)
+}
+
+func TestSegmentRoundTrip(t *testing.T) {
+	b, err := archive.EncodeSegment(records)
+	assert.Nil(t, err)
+
+	got, err := archive.DecodeSegment(b)
+	assert.Nil(t, err)
+	assert.Len(t, got, len(records))
+
+	for i, r := range records {
+		assert.Equal(t, r.ID, got[i].ID)
+		assert.True(t, r.RecordedAt.Equal(got[i].RecordedAt))
+		assert.Equal(t, r.Data, got[i].Data)
+	}
+
+	_, err = archive.DecodeSegment([]byte("not gzip"))
+	assert.NotNil(t, err)
+}
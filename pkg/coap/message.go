@@ -0,0 +1,222 @@
+package coap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Message types from RFC 7252.
+const (
+	typeConfirmable     = 0
+	typeNonConfirmable  = 1
+	typeAcknowledgement = 2
+	typeReset           = 3
+)
+
+// Message codes from RFC 7252, written as class<<5 | detail.
+const (
+	codeEmpty               = 0
+	codeGet                 = 1
+	codePost                = 2
+	codePut                 = 3
+	codeDelete              = 4
+	codeCreated             = 2<<5 | 1
+	codeBadRequest          = 4<<5 | 0
+	codeBadOption           = 4<<5 | 2
+	codeNotFound            = 4<<5 | 4
+	codeMethodNotAllowed    = 4<<5 | 5
+	codeInternalServerError = 5<<5 | 0
+	codeServiceUnavailable  = 5<<5 | 3
+)
+
+// Option numbers from RFC 7252 that we understand.
+const (
+	optionURIHost       = 3
+	optionURIPort       = 7
+	optionURIPath       = 11
+	optionContentFormat = 12
+	optionURIQuery      = 15
+)
+
+const (
+	// payloadMarker separates the options from the payload.
+	payloadMarker = 0xff
+
+	// maxTokenLength is the longest token a message may carry.
+	maxTokenLength = 8
+)
+
+// option is a single CoAP option.
+type option struct {
+	number uint16
+	value  []byte
+}
+
+// message is a CoAP message as defined in RFC 7252.
+type message struct {
+	typ       byte
+	code      byte
+	messageID uint16
+	token     []byte
+	options   []option
+	payload   []byte
+}
+
+// codeString returns a message code in the dotted c.dd form used by RFC 7252.
+func codeString(code byte) string {
+	return fmt.Sprintf("%d.%02d", code>>5, code&0x1f)
+}
+
+// parseMessage decodes a CoAP message from a single datagram.
+func parseMessage(b []byte) (*message, error) {
+	if len(b) < 4 {
+		return nil, errors.New("message too short")
+	}
+
+	if b[0]>>6 != 1 {
+		return nil, errors.New("unsupported version")
+	}
+
+	m := &message{
+		typ:       (b[0] >> 4) & 0x03,
+		code:      b[1],
+		messageID: binary.BigEndian.Uint16(b[2:4]),
+	}
+
+	tokenLength := int(b[0] & 0x0f)
+	if tokenLength > maxTokenLength || len(b) < 4+tokenLength {
+		return nil, errors.New("invalid token length")
+	}
+
+	m.token = b[4 : 4+tokenLength]
+	b = b[4+tokenLength:]
+
+	number := 0
+
+	for len(b) > 0 {
+		if b[0] == payloadMarker {
+			if len(b) == 1 {
+				return nil, errors.New("payload marker followed by empty payload")
+			}
+			m.payload = b[1:]
+			break
+		}
+
+		delta := int(b[0] >> 4)
+		length := int(b[0] & 0x0f)
+		b = b[1:]
+
+		var err error
+
+		delta, b, err = extendOption(delta, b)
+		if err != nil {
+			return nil, err
+		}
+
+		length, b, err = extendOption(length, b)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(b) < length {
+			return nil, errors.New("option value truncated")
+		}
+
+		number += delta
+		if number > 0xffff {
+			return nil, errors.New("option number out of range")
+		}
+
+		m.options = append(m.options, option{number: uint16(number), value: b[:length]})
+		b = b[length:]
+	}
+
+	return m, nil
+}
+
+// extendOption decodes the extended form of an option delta or length.
+// A nibble of 13 is followed by one byte holding the value minus 13, 14 by two
+// bytes holding the value minus 269, and 15 is reserved.
+func extendOption(nibble int, b []byte) (int, []byte, error) {
+	switch nibble {
+	case 13:
+		if len(b) < 1 {
+			return 0, nil, errors.New("option truncated")
+		}
+		return int(b[0]) + 13, b[1:], nil
+	case 14:
+		if len(b) < 2 {
+			return 0, nil, errors.New("option truncated")
+		}
+		return int(binary.BigEndian.Uint16(b)) + 269, b[2:], nil
+	case 15:
+		return 0, nil, errors.New("invalid option")
+	default:
+		return nibble, b, nil
+	}
+}
+
+// marshal encodes the message into a datagram.
+func (m *message) marshal() []byte {
+	b := []byte{
+		1<<6 | m.typ<<4 | byte(len(m.token)),
+		m.code,
+	}
+	b = append(b, byte(m.messageID>>8), byte(m.messageID))
+	b = append(b, m.token...)
+
+	sort.SliceStable(m.options, func(i, j int) bool {
+		return m.options[i].number < m.options[j].number
+	})
+
+	number := 0
+
+	for _, o := range m.options {
+		delta, deltaExt := encodeOption(int(o.number) - number)
+		length, lengthExt := encodeOption(len(o.value))
+
+		b = append(b, byte(delta<<4|length))
+		b = append(b, deltaExt...)
+		b = append(b, lengthExt...)
+		b = append(b, o.value...)
+
+		number = int(o.number)
+	}
+
+	if len(m.payload) > 0 {
+		b = append(b, payloadMarker)
+		b = append(b, m.payload...)
+	}
+
+	return b
+}
+
+// encodeOption returns the nibble and any extended bytes encoding an option
+// delta or length.
+func encodeOption(v int) (int, []byte) {
+	switch {
+	case v < 13:
+		return v, nil
+	case v < 269:
+		return 13, []byte{byte(v - 13)}
+	default:
+		v -= 269
+		return 14, []byte{byte(v >> 8), byte(v)}
+	}
+}
+
+// option returns the values of all options with the given number.
+func (m *message) option(number uint16) [][]byte {
+	values := [][]byte{}
+
+	for _, o := range m.options {
+		if o.number == number {
+			values = append(values, o.value)
+		}
+	}
+
+	return values
+}
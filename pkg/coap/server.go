@@ -0,0 +1,599 @@
+package coap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pion/dtls"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	registry "github.com/thingful/retryable-registry-prometheus"
+	datastore "github.com/thingful/twirp-datastore-go"
+	"github.com/twitchtv/twirp"
+)
+
+const (
+	// DeviceTokenOption is the option number via which devices send their
+	// device token. It is taken from the range reserved for experimental use
+	// by RFC 7252, and is critical so that a server which does not understand
+	// it rejects the request rather than ignoring the token.
+	DeviceTokenOption = 65001
+
+	// eventsPath is the resource to which events are posted, followed by the
+	// community id. It is kept short to save bytes on the wire.
+	eventsPath = "e"
+
+	// maxMessageSize is the largest datagram we read.
+	maxMessageSize = 64 * 1024
+
+	// exchangeLifetime is how long we remember a message id so that we can
+	// recognise retransmissions, per EXCHANGE_LIFETIME in RFC 7252.
+	exchangeLifetime = 247 * time.Second
+
+	// handshakeTimeout is the maximum time we allow for a DTLS handshake.
+	handshakeTimeout = 30 * time.Second
+
+	// idleTimeout is how long we keep a DTLS session open without receiving
+	// any messages.
+	idleTimeout = 5 * time.Minute
+
+	// DefaultWorkers is the number of goroutines handling messages received
+	// without DTLS if none is configured.
+	DefaultWorkers = 32
+
+	// DefaultMaxSessions is the maximum number of DTLS sessions, including
+	// those still handshaking, if none is configured.
+	DefaultMaxSessions = 1024
+
+	// maxExchanges is the maximum number of exchanges we remember. Messages
+	// arriving while we are at the limit are dropped, as with any datagram we
+	// cannot keep up with, leaving the client to retransmit them.
+	maxExchanges = 64 * 1024
+)
+
+var (
+	// requestDuration is a histogram recording the time taken to handle CoAP
+	// requests, labelled in the same way as the histogram recording HTTP
+	// requests, with the response code in CoAP's dotted form.
+	requestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "coap_request_duration_sec",
+			Help:      "Time (in seconds) spent serving CoAP requests",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"status_code", "method", "path"},
+	)
+
+	// dropped is a counter recording datagrams we discarded because we were
+	// at one of our limits, by the limit reached.
+	dropped = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "coap_dropped_total",
+			Help:      "Number of CoAP datagrams dropped because the server was at a limit",
+		}, []string{"reason"},
+	)
+)
+
+func init() {
+	registry.MustRegister(requestDuration)
+	registry.MustRegister(dropped)
+}
+
+// Writer is the part of the datastore interface used to store the events we
+// receive, normally satisfied by rpc.Datastore so that events go through the
+// same validation and storage as those written via the API.
+type Writer interface {
+	WriteData(ctx context.Context, req *datastore.WriteRequest) (*datastore.WriteResponse, error)
+}
+
+// Config is a struct used to pass configuration into our Server instance.
+type Config struct {
+	// Addr is the UDP address to which the server binds.
+	Addr string
+
+	// CertFile and KeyFile are the paths of a PEM encoded certificate and ECDSA
+	// private key. If set, the server only accepts DTLS connections.
+	CertFile string
+	KeyFile  string
+
+	// Workers is the number of goroutines handling messages received without
+	// DTLS. Datagrams arriving while all workers are busy and the queue is full
+	// are dropped. Defaults to DefaultWorkers.
+	Workers int
+
+	// MaxSessions is the maximum number of concurrent DTLS sessions, datagrams
+	// from new remote addresses are dropped while we are at the limit.
+	// Defaults to DefaultMaxSessions.
+	MaxSessions int
+}
+
+// Server is a CoAP server to which constrained devices may post encrypted
+// events, as an alternative to the HTTP API. Devices POST their payload to
+// /e/{community_id}, with the device token sent via DeviceTokenOption, and
+// receive 2.01 Created once it is stored.
+type Server struct {
+	writer      Writer
+	addr        string
+	dtls        bool
+	workers     int
+	maxSessions int
+	logger      kitlog.Logger
+
+	// dtlsConfig is guarded by mu as the certificate may be reloaded
+	dtlsConfig *dtls.Config
+
+	conn      net.PacketConn
+	queue     chan datagram
+	sessions  map[string]*session
+	exchanges map[string]*exchange
+	messageID uint32
+
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// datagram is a message received without DTLS, queued to be handled by one of
+// our workers.
+type datagram struct {
+	b    []byte
+	addr net.Addr
+}
+
+// exchange records the response to a message so that retransmissions of the
+// message are answered without storing the event again. The response is nil
+// while the original message is still being handled.
+type exchange struct {
+	response []byte
+	expires  time.Time
+}
+
+// NewServer returns a new Server instance, returning an error if the DTLS
+// certificate could not be loaded.
+func NewServer(config *Config, writer Writer, logger kitlog.Logger) (*Server, error) {
+	s := &Server{
+		writer:      writer,
+		addr:        config.Addr,
+		workers:     config.Workers,
+		maxSessions: config.MaxSessions,
+		logger:      kitlog.With(logger, "module", "coap"),
+		sessions:    map[string]*session{},
+		exchanges:   map[string]*exchange{},
+		messageID:   rand.Uint32(),
+		done:        make(chan struct{}),
+	}
+
+	if s.workers <= 0 {
+		s.workers = DefaultWorkers
+	}
+
+	if s.maxSessions <= 0 {
+		s.maxSessions = DefaultMaxSessions
+	}
+
+	s.queue = make(chan datagram, s.workers)
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		dtlsConfig, err := loadDTLSConfig(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		s.dtls = true
+		s.dtlsConfig = dtlsConfig
+	}
+
+	return s, nil
+}
+
+// ReloadCertificate loads the DTLS certificate and key again, e.g. after they
+// have been renewed. The new certificate is used for sessions started after
+// it is loaded. An error is returned if DTLS is not enabled, or the
+// certificate could not be loaded in which case we keep using the previous
+// one.
+func (s *Server) ReloadCertificate(certFile, keyFile string) error {
+	if !s.dtls {
+		return errors.New("coap dtls is not enabled")
+	}
+
+	dtlsConfig, err := loadDTLSConfig(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.dtlsConfig = dtlsConfig
+	s.mu.Unlock()
+
+	s.logger.Log("msg", "reloaded coap dtls certificate")
+
+	return nil
+}
+
+// loadDTLSConfig returns a DTLS configuration using the given certificate and
+// key.
+func loadDTLSConfig(certFile, keyFile string) (*dtls.Config, error) {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load coap dtls certificate")
+	}
+
+	key, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("coap dtls private key must be an ECDSA key")
+	}
+
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse coap dtls certificate")
+	}
+
+	return &dtls.Config{
+		Certificate: cert,
+		PrivateKey:  key,
+	}, nil
+}
+
+// Start binds to the configured address and starts serving requests in the
+// background.
+func (s *Server) Start() error {
+	conn, err := net.ListenPacket("udp", s.addr)
+	if err != nil {
+		return errors.Wrap(err, "failed to listen for coap requests")
+	}
+
+	s.conn = conn
+
+	s.logger.Log("msg", "starting coap server", "listenAddr", conn.LocalAddr(), "dtlsEnabled", s.dtls)
+
+	s.wg.Add(2)
+	go s.serve()
+	go s.expireExchanges()
+
+	if !s.dtls {
+		s.wg.Add(s.workers)
+		for i := 0; i < s.workers; i++ {
+			go s.work()
+		}
+	}
+
+	return nil
+}
+
+// Addr returns the address on which the server is listening.
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Stop closes the listener and any DTLS sessions, waiting for requests being
+// handled to complete. It is safe to call Stop more than once, or without
+// having called Start.
+func (s *Server) Stop() {
+	s.stopOnce.Do(func() {
+		s.logger.Log("msg", "stopping coap server")
+
+		close(s.done)
+
+		if s.conn != nil {
+			s.conn.Close()
+		}
+
+		s.mu.Lock()
+		for _, sess := range s.sessions {
+			sess.Close()
+		}
+		s.mu.Unlock()
+
+		s.wg.Wait()
+	})
+}
+
+// serve reads datagrams until the listener is closed. Without DTLS each
+// datagram is a message queued for our workers, with DTLS datagrams are passed
+// to the session for their remote address.
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		buf := make([]byte, maxMessageSize)
+
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+
+			s.logger.Log("msg", "failed to read coap datagram", "err", err)
+			continue
+		}
+
+		if s.dtls {
+			if sess := s.sessionFor(addr); sess != nil {
+				sess.deliver(buf[:n])
+			}
+			continue
+		}
+
+		select {
+		case s.queue <- datagram{b: buf[:n], addr: addr}:
+		default:
+			dropped.WithLabelValues("queue").Inc()
+		}
+	}
+}
+
+// work handles queued messages until the server is stopped.
+func (s *Server) work() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case d := <-s.queue:
+			resp := s.handle(d.b, d.addr.String())
+			if resp != nil {
+				s.conn.WriteTo(resp, d.addr)
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// sessionFor returns the DTLS session for the given remote address, starting a
+// new one if necessary. Nil is returned if we are at the maximum number of
+// sessions, in which case the datagram is dropped.
+func (s *Server) sessionFor(addr net.Addr) *session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[addr.String()]
+	if !ok {
+		if len(s.sessions) >= s.maxSessions {
+			dropped.WithLabelValues("sessions").Inc()
+			return nil
+		}
+
+		sess = newSession(s.conn, addr)
+		s.sessions[addr.String()] = sess
+
+		s.wg.Add(1)
+		go s.serveSession(sess, s.dtlsConfig)
+	}
+
+	return sess
+}
+
+// serveSession performs the DTLS handshake for a session using the given
+// configuration, then handles the messages it receives until it is closed or
+// idle for too long.
+func (s *Server) serveSession(sess *session, dtlsConfig *dtls.Config) {
+	defer s.wg.Done()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.sessions, sess.addr.String())
+		s.mu.Unlock()
+	}()
+
+	timer := time.AfterFunc(handshakeTimeout, func() { sess.Close() })
+
+	conn, err := dtls.Server(sess, dtlsConfig)
+	timer.Stop()
+
+	if err != nil {
+		s.logger.Log("msg", "coap dtls handshake failed", "remoteAddr", sess.addr, "err", err)
+		sess.Close()
+		return
+	}
+	defer conn.Close()
+
+	idle := time.AfterFunc(idleTimeout, func() { conn.Close() })
+	defer idle.Stop()
+
+	buf := make([]byte, maxMessageSize)
+
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+
+		idle.Reset(idleTimeout)
+
+		resp := s.handle(buf[:n], sess.addr.String())
+		if resp != nil {
+			conn.Write(resp)
+		}
+	}
+}
+
+// handle processes a single message received from the given remote address,
+// returning the response to send, if any.
+func (s *Server) handle(b []byte, from string) []byte {
+	req, err := parseMessage(b)
+	if err != nil {
+		// confirmable messages we cannot parse are rejected with a reset
+		if len(b) >= 4 && b[0]>>6 == 1 && (b[0]>>4)&0x03 == typeConfirmable {
+			return (&message{typ: typeReset, messageID: uint16(b[2])<<8 | uint16(b[3])}).marshal()
+		}
+		return nil
+	}
+
+	switch {
+	case req.typ == typeAcknowledgement || req.typ == typeReset:
+		return nil
+	case req.code == codeEmpty:
+		// an empty confirmable message is a ping, which we answer with a reset
+		if req.typ == typeConfirmable {
+			return (&message{typ: typeReset, messageID: req.messageID}).marshal()
+		}
+		return nil
+	case req.code>>5 != 0:
+		// responses are not expected from clients
+		return nil
+	}
+
+	key := fmt.Sprintf("%s/%d", from, req.messageID)
+
+	s.mu.Lock()
+	if ex, ok := s.exchanges[key]; ok {
+		s.mu.Unlock()
+		return ex.response
+	}
+	if len(s.exchanges) >= maxExchanges {
+		s.mu.Unlock()
+		dropped.WithLabelValues("exchanges").Inc()
+		return nil
+	}
+	s.exchanges[key] = &exchange{expires: time.Now().Add(exchangeLifetime)}
+	s.mu.Unlock()
+
+	resp := s.respond(req)
+
+	s.mu.Lock()
+	s.exchanges[key].response = resp
+	s.mu.Unlock()
+
+	return resp
+}
+
+// respond handles a request, returning the encoded response. Confirmable
+// requests receive a piggybacked acknowledgement, non-confirmable requests a
+// non-confirmable response.
+func (s *Server) respond(req *message) []byte {
+	start := time.Now()
+	path := "other"
+
+	resp := &message{
+		typ:       typeAcknowledgement,
+		messageID: req.messageID,
+		token:     req.token,
+	}
+
+	if req.typ == typeNonConfirmable {
+		resp.typ = typeNonConfirmable
+		resp.messageID = uint16(atomic.AddUint32(&s.messageID, 1))
+	}
+
+	segments := req.option(optionURIPath)
+	if len(segments) == 2 && string(segments[0]) == eventsPath {
+		path = "/" + eventsPath + "/{community_id}"
+	}
+
+	switch {
+	case path == "other":
+		resp.code = codeNotFound
+	case req.code != codePost:
+		resp.code = codeMethodNotAllowed
+	case unknownCriticalOption(req):
+		resp.code = codeBadOption
+	default:
+		resp.code, resp.payload = s.write(string(segments[1]), req)
+	}
+
+	requestDuration.WithLabelValues(codeString(resp.code), methodName(req.code), path).Observe(time.Since(start).Seconds())
+
+	return resp.marshal()
+}
+
+// write stores the event in the request, returning the response code along
+// with a diagnostic payload describing any error.
+func (s *Server) write(communityID string, req *message) (byte, []byte) {
+	deviceToken := ""
+	if tokens := req.option(DeviceTokenOption); len(tokens) > 0 {
+		deviceToken = string(tokens[0])
+	}
+
+	_, err := s.writer.WriteData(context.Background(), &datastore.WriteRequest{
+		CommunityId: communityID,
+		DeviceToken: deviceToken,
+		Data:        req.payload,
+	})
+	if err == nil {
+		return codeCreated, nil
+	}
+
+	twerr, ok := err.(twirp.Error)
+	if !ok {
+		return codeInternalServerError, nil
+	}
+
+	switch twerr.Code() {
+	case twirp.InvalidArgument:
+		return codeBadRequest, []byte(twerr.Msg())
+	case twirp.Canceled, twirp.DeadlineExceeded:
+		return codeServiceUnavailable, []byte(twerr.Msg())
+	default:
+		return codeInternalServerError, nil
+	}
+}
+
+// unknownCriticalOption returns true if the request contains a critical option,
+// i.e. one with an odd number, that we do not understand.
+func unknownCriticalOption(req *message) bool {
+	for _, o := range req.options {
+		switch o.number {
+		case optionURIHost, optionURIPort, optionURIPath, optionURIQuery, DeviceTokenOption:
+		default:
+			if o.number&1 == 1 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// methodName returns the name of a request method code.
+func methodName(code byte) string {
+	switch code {
+	case codeGet:
+		return "GET"
+	case codePost:
+		return "POST"
+	case codePut:
+		return "PUT"
+	case codeDelete:
+		return "DELETE"
+	default:
+		return codeString(code)
+	}
+}
+
+// expireExchanges periodically forgets exchanges older than the exchange
+// lifetime until the server is stopped.
+func (s *Server) expireExchanges() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for key, ex := range s.exchanges {
+				if ex.response != nil && now.After(ex.expires) {
+					delete(s.exchanges, key)
+				}
+			}
+			s.mu.Unlock()
+		case <-s.done:
+			return
+		}
+	}
+}
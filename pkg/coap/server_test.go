@@ -0,0 +1,342 @@
+package coap_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pion/dtls"
+	"github.com/stretchr/testify/assert"
+	datastore "github.com/thingful/twirp-datastore-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotstore/pkg/coap"
+)
+
+// fakeWriter records the requests it is asked to write, validating them in the
+// same way as rpc.Datastore.
+type fakeWriter struct {
+	sync.Mutex
+	fail     bool
+	requests []*datastore.WriteRequest
+}
+
+func (f *fakeWriter) WriteData(ctx context.Context, req *datastore.WriteRequest) (*datastore.WriteResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	if req.DeviceToken == "" {
+		return nil, twirp.RequiredArgumentError("device_token")
+	}
+
+	if f.fail {
+		return nil, errors.New("database unavailable")
+	}
+
+	f.requests = append(f.requests, req)
+	return &datastore.WriteResponse{}, nil
+}
+
+func (f *fakeWriter) written() []*datastore.WriteRequest {
+	f.Lock()
+	defer f.Unlock()
+	return f.requests
+}
+
+type option struct {
+	number int
+	value  string
+}
+
+// encode returns a CoAP message with the given options, which must be sorted
+// and have values shorter than 13 bytes.
+func encode(typ, code byte, messageID uint16, options []option, payload string) []byte {
+	b := []byte{1<<6 | typ<<4 | 2, code, byte(messageID >> 8), byte(messageID), 0xca, 0xfe}
+
+	number := 0
+	for _, o := range options {
+		delta := o.number - number
+		switch {
+		case delta < 13:
+			b = append(b, byte(delta<<4|len(o.value)))
+		case delta < 269:
+			b = append(b, byte(13<<4|len(o.value)), byte(delta-13))
+		default:
+			b = append(b, byte(14<<4|len(o.value)), byte((delta-269)>>8), byte(delta-269))
+		}
+		b = append(b, o.value...)
+		number = o.number
+	}
+
+	if payload != "" {
+		b = append(b, 0xff)
+		b = append(b, payload...)
+	}
+
+	return b
+}
+
+func post(communityID, deviceToken string) []option {
+	options := []option{{11, "e"}, {11, communityID}}
+	if deviceToken != "" {
+		options = append(options, option{coap.DeviceTokenOption, deviceToken})
+	}
+	return options
+}
+
+// exchange sends a message and returns the type, code, message id and
+// payload of the response.
+func exchange(t *testing.T, conn net.Conn, msg []byte) (byte, byte, uint16, string) {
+	_, err := conn.Write(msg)
+	assert.Nil(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+
+	resp := buf[:n]
+	tokenLength := int(resp[0] & 0x0f)
+
+	payload := ""
+	for i := 4 + tokenLength; i < len(resp); i++ {
+		if resp[i] == 0xff {
+			payload = string(resp[i+1:])
+			break
+		}
+	}
+
+	return (resp[0] >> 4) & 0x03, resp[1], uint16(resp[2])<<8 | uint16(resp[3]), payload
+}
+
+const (
+	typeCON = 0
+	typeNON = 1
+	typeACK = 2
+	typeRST = 3
+
+	codePost    = 2
+	codeGet     = 1
+	created     = 2<<5 | 1
+	badRequest  = 4<<5 | 0
+	badOption   = 4<<5 | 2
+	notFound    = 4<<5 | 4
+	notAllowed  = 4<<5 | 5
+	serverError = 5<<5 | 0
+)
+
+func TestServer(t *testing.T) {
+	writer := &fakeWriter{}
+
+	srv, err := coap.NewServer(&coap.Config{Addr: "127.0.0.1:0"}, writer, kitlog.NewNopLogger())
+	assert.Nil(t, err)
+
+	err = srv.Start()
+	assert.Nil(t, err)
+	defer srv.Stop()
+
+	conn, err := net.Dial("udp", srv.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	typ, code, id, _ := exchange(t, conn, encode(typeCON, codePost, 1, post("abc123", "device-1"), "hello"))
+	assert.Equal(t, byte(typeACK), typ)
+	assert.Equal(t, byte(created), code)
+	assert.Equal(t, uint16(1), id)
+
+	// a retransmission is acknowledged without storing the event again
+	_, code, _, _ = exchange(t, conn, encode(typeCON, codePost, 1, post("abc123", "device-1"), "hello"))
+	assert.Equal(t, byte(created), code)
+	assert.Len(t, writer.written(), 1)
+
+	typ, code, _, _ = exchange(t, conn, encode(typeNON, codePost, 2, post("abc123", "device-2"), "world"))
+	assert.Equal(t, byte(typeNON), typ)
+	assert.Equal(t, byte(created), code)
+
+	written := writer.written()
+	assert.Len(t, written, 2)
+	assert.Equal(t, "abc123", written[0].CommunityId)
+	assert.Equal(t, "device-1", written[0].DeviceToken)
+	assert.Equal(t, "hello", string(written[0].Data))
+	assert.Equal(t, "device-2", written[1].DeviceToken)
+
+	_, code, _, payload := exchange(t, conn, encode(typeCON, codePost, 3, post("abc123", ""), "hello"))
+	assert.Equal(t, byte(badRequest), code)
+	assert.Equal(t, "device_token is required", payload)
+
+	_, code, _, _ = exchange(t, conn, encode(typeCON, codePost, 4, []option{{11, "x"}}, "hello"))
+	assert.Equal(t, byte(notFound), code)
+
+	_, code, _, _ = exchange(t, conn, encode(typeCON, codeGet, 5, post("abc123", "device-1"), ""))
+	assert.Equal(t, byte(notAllowed), code)
+
+	_, code, _, _ = exchange(t, conn, encode(typeCON, codePost, 6, append(post("abc123", "device-1"), option{65003, "x"}), "hello"))
+	assert.Equal(t, byte(badOption), code)
+
+	// ping
+	typ, _, id, _ = exchange(t, conn, encode(typeCON, 0, 7, nil, ""))
+	assert.Equal(t, byte(typeRST), typ)
+	assert.Equal(t, uint16(7), id)
+
+	writer.Lock()
+	writer.fail = true
+	writer.Unlock()
+
+	_, code, _, _ = exchange(t, conn, encode(typeCON, codePost, 8, post("abc123", "device-1"), "hello"))
+	assert.Equal(t, byte(serverError), code)
+}
+
+// writeCertificate writes a self signed ECDSA certificate and key to the given
+// directory.
+func writeCertificate(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.Nil(t, err)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	assert.Nil(t, err)
+
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	assert.Nil(t, err)
+
+	return certFile, keyFile
+}
+
+func TestServerDTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coap")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCertificate(t, dir)
+
+	writer := &fakeWriter{}
+
+	srv, err := coap.NewServer(&coap.Config{Addr: "127.0.0.1:0", CertFile: certFile, KeyFile: keyFile}, writer, kitlog.NewNopLogger())
+	assert.Nil(t, err)
+
+	err = srv.Start()
+	assert.Nil(t, err)
+	defer srv.Stop()
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &clientKey.PublicKey, clientKey)
+	assert.Nil(t, err)
+	clientCert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	conn, err := dtls.Dial("udp", srv.Addr().(*net.UDPAddr), &dtls.Config{
+		Certificate: clientCert,
+		PrivateKey:  clientKey,
+	})
+	if err != nil {
+		t.Fatalf("Failed to establish DTLS session: %v", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write(encode(typeCON, codePost, 1, post("abc123", "device-1"), "secret"))
+	assert.Nil(t, err)
+
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	assert.Nil(t, err)
+	assert.True(t, n >= 4)
+	assert.Equal(t, byte(created), buf[1])
+
+	written := writer.written()
+	assert.Len(t, written, 1)
+	assert.Equal(t, "secret", string(written[0].Data))
+}
+
+func TestNewServerInvalidCertificate(t *testing.T) {
+	_, err := coap.NewServer(&coap.Config{Addr: ":0", CertFile: "missing.pem", KeyFile: "missing.pem"}, &fakeWriter{}, kitlog.NewNopLogger())
+	assert.NotNil(t, err)
+}
+
+func TestReloadCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "coap")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile := writeCertificate(t, dir)
+
+	srv, err := coap.NewServer(&coap.Config{Addr: "127.0.0.1:0", CertFile: certFile, KeyFile: keyFile}, &fakeWriter{}, kitlog.NewNopLogger())
+	assert.Nil(t, err)
+
+	// a renewed certificate written over the original
+	certFile, keyFile = writeCertificate(t, dir)
+
+	err = srv.ReloadCertificate(certFile, keyFile)
+	assert.Nil(t, err)
+
+	err = srv.ReloadCertificate(filepath.Join(dir, "missing.pem"), keyFile)
+	assert.NotNil(t, err)
+
+	plain, err := coap.NewServer(&coap.Config{Addr: "127.0.0.1:0"}, &fakeWriter{}, kitlog.NewNopLogger())
+	assert.Nil(t, err)
+
+	err = plain.ReloadCertificate(certFile, keyFile)
+	assert.NotNil(t, err)
+}
+
+func TestServerStop(t *testing.T) {
+	srv, err := coap.NewServer(&coap.Config{Addr: "127.0.0.1:0"}, &fakeWriter{}, kitlog.NewNopLogger())
+	assert.Nil(t, err)
+
+	// stopping a server that was never started is a no-op
+	srv.Stop()
+
+	srv, err = coap.NewServer(&coap.Config{Addr: "127.0.0.1:0", Workers: 1}, &fakeWriter{}, kitlog.NewNopLogger())
+	assert.Nil(t, err)
+
+	err = srv.Start()
+	assert.Nil(t, err)
+
+	conn, err := net.Dial("udp", srv.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	_, code, _, _ := exchange(t, conn, encode(typeCON, codePost, 1, post("abc123", "device-1"), "hello"))
+	assert.Equal(t, byte(created), code)
+
+	srv.Stop()
+	srv.Stop()
+}
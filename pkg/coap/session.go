@@ -0,0 +1,75 @@
+package coap
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// session is a net.Conn carrying the datagrams exchanged with a single remote
+// address over the server's shared socket, over which a DTLS connection is
+// established. Datagrams read by the server are delivered to the session,
+// while writes go straight to the socket.
+type session struct {
+	conn   net.PacketConn
+	addr   net.Addr
+	in     chan []byte
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newSession(conn net.PacketConn, addr net.Addr) *session {
+	return &session{
+		conn:   conn,
+		addr:   addr,
+		in:     make(chan []byte, 16),
+		closed: make(chan struct{}),
+	}
+}
+
+// deliver queues a datagram to be read from the session. As with any UDP
+// socket datagrams are dropped if the reader cannot keep up.
+func (s *session) deliver(b []byte) {
+	select {
+	case s.in <- b:
+	case <-s.closed:
+	default:
+	}
+}
+
+// Read reads the next datagram delivered to the session.
+func (s *session) Read(b []byte) (int, error) {
+	select {
+	case datagram := <-s.in:
+		return copy(b, datagram), nil
+	case <-s.closed:
+		return 0, io.EOF
+	}
+}
+
+// Write sends a datagram to the remote address.
+func (s *session) Write(b []byte) (int, error) {
+	select {
+	case <-s.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	return s.conn.WriteTo(b, s.addr)
+}
+
+// Close closes the session, but not the underlying socket.
+func (s *session) Close() error {
+	s.once.Do(func() { close(s.closed) })
+	return nil
+}
+
+func (s *session) LocalAddr() net.Addr  { return s.conn.LocalAddr() }
+func (s *session) RemoteAddr() net.Addr { return s.addr }
+
+// Deadlines are not supported, sessions are instead closed by the server
+// when the handshake takes too long or the session is idle.
+func (s *session) SetDeadline(t time.Time) error      { return nil }
+func (s *session) SetReadDeadline(t time.Time) error  { return nil }
+func (s *session) SetWriteDeadline(t time.Time) error { return nil }
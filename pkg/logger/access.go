@@ -0,0 +1,54 @@
+package logger
+
+import (
+	"net/http"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+
+	"github.com/DECODEproject/iotstore/pkg/redact"
+)
+
+// accessWriter wraps http.ResponseWriter to capture the status code and the
+// number of bytes written.
+type accessWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *accessWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// AccessLog returns a net.http middleware writing a line to the logger for
+// each request, recording the method, path, status, duration and size of the
+// response along with the request ID. It must be installed after
+// middleware.RequestIDMiddleware.
+func AccessLog(logger kitlog.Logger) func(http.Handler) http.Handler {
+	logger = kitlog.With(logger, "module", "access")
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			aw := &accessWriter{ResponseWriter: w, status: http.StatusOK}
+
+			next.ServeHTTP(aw, r)
+
+			FromContext(r.Context(), logger).Log(
+				"method", r.Method,
+				"path", redact.Path(r.URL.Path),
+				"status", aw.status,
+				"duration", time.Since(start),
+				"bytes", aw.bytes,
+			)
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package logger
+
+import (
+	"context"
+
+	"github.com/DECODEproject/iotcommon/middleware"
+	kitlog "github.com/go-kit/kit/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// FromContext returns a logger which adds the request ID set by
+// middleware.RequestIDMiddleware, and the ID of any trace, to every line it
+// writes, so that all the lines logged while handling a request can be found.
+func FromContext(ctx context.Context, logger kitlog.Logger) kitlog.Logger {
+	if rid, ok := ctx.Value(middleware.RequestCtxKey).(string); ok && rid != "" {
+		logger = kitlog.With(logger, "requestID", rid)
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		logger = kitlog.With(logger, "traceID", sc.TraceID().String())
+	}
+
+	return logger
+}
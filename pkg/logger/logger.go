@@ -1,15 +1,153 @@
 package logger
 
 import (
+	"io"
 	"os"
+	"strings"
+	"sync/atomic"
 
-	"github.com/DECODEproject/iotstore/pkg/version"
 	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotstore/pkg/version"
+)
+
+const (
+	// LogfmtFormat writes each log line as logfmt key=value pairs.
+	LogfmtFormat = "logfmt"
+
+	// JSONFormat writes each log line as a JSON object.
+	JSONFormat = "json"
 )
 
+var (
+	// Formats is the list of log formats we support.
+	Formats = []string{LogfmtFormat, JSONFormat}
+
+	// Levels is the list of log levels we support, in increasing severity.
+	Levels = []string{"debug", "info", "warn", "error"}
+)
+
+// Config is a struct used to pass configuration into New.
+type Config struct {
+	// Format is either LogfmtFormat or JSONFormat, defaulting to logfmt.
+	Format string
+
+	// Level is the minimum level of the lines we write, defaulting to debug.
+	// Lines logged without a level are written at info.
+	Level string
+
+	// LevelVar, if set, is used instead of Level so that the minimum level may
+	// be changed while the logger is in use.
+	LevelVar *LevelVar
+}
+
+// LevelVar holds a minimum log level which may be changed at any time, with
+// the change applying to every logger created with it.
+type LevelVar struct {
+	// level is the index into Levels of the minimum level
+	level int32
+}
+
+// NewLevelVar returns a new LevelVar set to the given level, returning an
+// error if the level is not known.
+func NewLevelVar(name string) (*LevelVar, error) {
+	v := &LevelVar{}
+
+	err := v.Set(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Set changes the minimum level, returning an error if the level is not
+// known. An empty level means debug.
+func (v *LevelVar) Set(name string) error {
+	if name == "" {
+		atomic.StoreInt32(&v.level, 0)
+		return nil
+	}
+
+	for i, l := range Levels {
+		if strings.EqualFold(name, l) {
+			atomic.StoreInt32(&v.level, int32(i))
+			return nil
+		}
+	}
+
+	return errors.Errorf("unknown log level: %s", name)
+}
+
+// String returns the current minimum level.
+func (v *LevelVar) String() string {
+	return Levels[atomic.LoadInt32(&v.level)]
+}
+
+// levelFilter passes lines on to the filter for the current minimum level.
+type levelFilter struct {
+	filters []kitlog.Logger
+	level   *LevelVar
+}
+
+// Log implements kitlog.Logger.
+func (f *levelFilter) Log(keyvals ...interface{}) error {
+	return f.filters[atomic.LoadInt32(&f.level.level)].Log(keyvals...)
+}
+
 // NewLogger is a simple helper function that returns a kitlog.Logger instance
 // ready for use.
 func NewLogger() kitlog.Logger {
-	logger := kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(os.Stdout))
-	return kitlog.With(logger, "service", version.BinaryName, "ts", kitlog.DefaultTimestampUTC)
+	return NewLoggerWithWriter(os.Stdout)
+}
+
+// NewLoggerWithWriter returns a kitlog.Logger instance that writes to the given
+// writer. This is used by commands that write their output to stdout.
+func NewLoggerWithWriter(w io.Writer) kitlog.Logger {
+	logger, _ := New(&Config{}, w)
+	return logger
+}
+
+// New returns a kitlog.Logger instance writing to the given writer in the
+// configured format, discarding lines below the configured level. An error is
+// returned if the format or level is not known.
+func New(config *Config, w io.Writer) (kitlog.Logger, error) {
+	var logger kitlog.Logger
+
+	switch strings.ToLower(config.Format) {
+	case "", LogfmtFormat:
+		logger = kitlog.NewLogfmtLogger(kitlog.NewSyncWriter(w))
+	case JSONFormat:
+		logger = kitlog.NewJSONLogger(kitlog.NewSyncWriter(w))
+	default:
+		return nil, errors.Errorf("unknown log format: %s", config.Format)
+	}
+
+	levelVar := config.LevelVar
+	if levelVar == nil {
+		var err error
+
+		levelVar, err = NewLevelVar(config.Level)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// one filter for each of Levels, in the same order
+	filter := &levelFilter{
+		filters: []kitlog.Logger{
+			level.NewFilter(logger, level.AllowDebug()),
+			level.NewFilter(logger, level.AllowInfo()),
+			level.NewFilter(logger, level.AllowWarn()),
+			level.NewFilter(logger, level.AllowError()),
+		},
+		level: levelVar,
+	}
+
+	// lines are given a level of info if they have none before being filtered
+	logger = level.NewInjector(filter, level.InfoValue())
+
+	return kitlog.With(logger, "service", version.BinaryName, "ts", kitlog.DefaultTimestampUTC), nil
 }
@@ -0,0 +1,120 @@
+package logger_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DECODEproject/iotcommon/middleware"
+	"github.com/go-kit/kit/log/level"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotstore/pkg/logger"
+	"github.com/DECODEproject/iotstore/pkg/version"
+)
+
+func TestNewJSON(t *testing.T) {
+	var buf bytes.Buffer
+
+	l, err := logger.New(&logger.Config{Format: logger.JSONFormat, Level: "info"}, &buf)
+	assert.Nil(t, err)
+
+	l.Log("msg", "hello")
+	level.Debug(l).Log("msg", "dropped")
+	level.Warn(l).Log("msg", "warning")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+
+	var line map[string]interface{}
+
+	err = json.Unmarshal([]byte(lines[0]), &line)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", line["msg"])
+	assert.Equal(t, "info", line["level"])
+	assert.Equal(t, version.BinaryName, line["service"])
+	assert.NotEmpty(t, line["ts"])
+
+	err = json.Unmarshal([]byte(lines[1]), &line)
+	assert.Nil(t, err)
+	assert.Equal(t, "warning", line["msg"])
+	assert.Equal(t, "warn", line["level"])
+}
+
+func TestNewInvalid(t *testing.T) {
+	_, err := logger.New(&logger.Config{Format: "xml"}, &bytes.Buffer{})
+	assert.NotNil(t, err)
+
+	_, err = logger.New(&logger.Config{Level: "verbose"}, &bytes.Buffer{})
+	assert.NotNil(t, err)
+}
+
+func TestLevelVar(t *testing.T) {
+	var buf bytes.Buffer
+
+	levelVar, err := logger.NewLevelVar("warn")
+	assert.Nil(t, err)
+
+	l, err := logger.New(&logger.Config{LevelVar: levelVar}, &buf)
+	assert.Nil(t, err)
+
+	level.Info(l).Log("msg", "dropped")
+
+	err = levelVar.Set("info")
+	assert.Nil(t, err)
+	assert.Equal(t, "info", levelVar.String())
+
+	level.Info(l).Log("msg", "written")
+	level.Debug(l).Log("msg", "dropped")
+
+	assert.NotContains(t, buf.String(), "dropped")
+	assert.Contains(t, buf.String(), "msg=written")
+
+	err = levelVar.Set("verbose")
+	assert.NotNil(t, err)
+	assert.Equal(t, "info", levelVar.String())
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+
+	l, err := logger.New(&logger.Config{Format: logger.JSONFormat}, &buf)
+	assert.Nil(t, err)
+
+	handler := middleware.RequestIDMiddleware(logger.AccessLog(l)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logger.FromContext(r.Context(), l).Log("msg", "handling")
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})))
+
+	req := httptest.NewRequest(http.MethodPost, "/events?foo=bar", nil)
+	req.Header.Set(middleware.RequestIDHeader, "request-1")
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+
+	var line map[string]interface{}
+
+	err = json.Unmarshal([]byte(lines[0]), &line)
+	assert.Nil(t, err)
+	assert.Equal(t, "handling", line["msg"])
+	assert.Equal(t, "request-1", line["requestID"])
+
+	line = map[string]interface{}{}
+
+	err = json.Unmarshal([]byte(lines[1]), &line)
+	assert.Nil(t, err)
+	assert.Equal(t, "access", line["module"])
+	assert.Equal(t, "request-1", line["requestID"])
+	assert.Equal(t, "POST", line["method"])
+	assert.Equal(t, "/events", line["path"])
+	assert.Equal(t, float64(http.StatusCreated), line["status"])
+	assert.Equal(t, float64(5), line["bytes"])
+	assert.NotEmpty(t, line["duration"])
+}
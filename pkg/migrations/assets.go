@@ -10,6 +10,14 @@
 // sql/20190227121312_create_cert_cache.up.sql (106B)
 // sql/20190308140100_rename_policy_id.down.sql (130B)
 // sql/20190308140100_rename_policy_id.up.sql (130B)
+// sql/20261019090000_create_devices.down.sql (29B)
+// sql/20261019090000_create_devices.up.sql (500B)
+// sql/20261019100000_partition_events.down.sql (1.214kB)
+// sql/20261019100000_partition_events.up.sql (3.998kB)
+// sql/20261019110000_create_archived_segments.down.sql (40B)
+// sql/20261019110000_create_archived_segments.up.sql (497B)
+// sql/20261019120000_create_webhooks.down.sql (72B)
+// sql/20261019120000_create_webhooks.up.sql (1.084kB)
 
 package migrations
 
@@ -78,7 +86,7 @@ func (fi bindataFileInfo) Sys() interface{} {
 	return nil
 }
 
-var __20180519220506_add_events_tableDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x09\xf2\x0f\x50\xf0\xf4\x73\x71\x8d\x50\xf0\x74\x53\x70\x8d\xf0\x0c\x0e\x09\x56\x48\x2d\x4b\xcd\x2b\x29\x8e\x2f\x28\x4d\xca\xc9\x4c\x8e\xcf\x4e\xad\x8c\xcf\x4c\xa9\x50\x70\x76\x0c\x76\x76\x74\x71\xb5\xe6\xc2\xa7\xa7\x28\x35\x39\xbf\x28\x25\x35\x25\x3e\xb1\x04\x55\x13\x44\x57\x88\xa3\x93\x8f\x2b\x86\x2e\xb8\x2a\x40\x00\x00\x00\xff\xff\x33\x48\xa7\x81\x8e\x00\x00\x00")
+var __20180519220506_add_events_tableDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x09\xf2\x0f\x50\xf0\xf4\x73\x71\x8d\x50\xf0\x74\x53\x70\x8d\xf0\x0c\x0e\x09\x56\x48\x2d\x4b\xcd\x2b\x29\x8e\x2f\x28\x4d\xca\xc9\x4c\x8e\xcf\x4e\xad\x8c\xcf\x4c\xa9\x50\x70\x76\x0c\x76\x76\x74\x71\xb5\xe6\xc2\xa7\xa7\x28\x35\x39\xbf\x28\x25\x35\x25\x3e\xb1\x04\x55\x13\x44\x57\x88\xa3\x93\x8f\x2b\x86\x2e\x05\x67\xc7\x60\x67\x47\x17\x57\x6b\xc0\x00\x33\x48\xa7\x81\x8e\x00\x00\x00")
 
 func _20180519220506_add_events_tableDownSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -98,7 +106,7 @@ func _20180519220506_add_events_tableDownSql() (*asset, error) {
 	return a, nil
 }
 
-var __20180519220506_add_events_tableUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x8e\xc1\x4a\x86\x40\x18\x45\xf7\xf3\x14\x77\xa9\xd0\x1b\xb8\x1a\xf3\x93\x86\xc6\x51\x66\x3e\x51\xdb\x0c\xe6\xcc\x42\x8a\x0a\xb3\xa8\xb7\x0f\x25\x52\xe8\x5f\xfc\xcb\xcb\xe1\x5c\xce\xad\x25\xc9\x04\x96\xb9\x26\xa8\x12\xa6\x66\x50\xaf\x1c\x3b\xc4\xcf\xf8\xb2\xbe\x23\x11\xc0\x1c\xe0\xc8\x2a\xa9\xd1\x58\x55\x49\x3b\xe0\x9e\x86\x1b\x01\xbc\x7d\x3c\x3e\xcf\x93\x7f\x8a\xdf\x60\xea\x79\xd7\x4d\xab\xf5\xc6\x96\x38\xbd\x2e\x21\x06\x3f\xae\x60\x55\x91\x63\x59\x35\xe8\x14\xdf\xed\x13\x0f\xb5\x21\x14\x54\xca\x56\x6f\x62\x97\xa4\x9b\x15\xc6\x75\x44\x3e\x30\x49\x91\x66\x42\xfc\xf6\x29\x53\x50\x7f\xb1\xcf\x1f\x09\x7e\x0e\x5f\x02\xa8\xcd\x5f\xfa\xc1\xae\xfb\x3a\x25\xff\x3f\x3b\xc1\x34\xfb\x09\x00\x00\xff\xff\x4c\xd7\xeb\x51\x38\x01\x00\x00")
+var __20180519220506_add_events_tableUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x8c\x8e\xc1\x4a\x86\x40\x18\x45\xf7\xf3\x14\x77\xa9\xd0\x1b\xb8\x1a\xf3\x93\x86\xc6\x51\x66\x3e\x51\xdb\x0c\xe6\xcc\x42\x8a\x0a\xb3\xa8\xb7\x0f\x25\x52\xe8\x5f\xfc\xcb\xcb\xe1\x5c\xce\xad\x25\xc9\x04\x96\xb9\x26\xa8\x12\xa6\x66\x50\xaf\x1c\x3b\xc4\xcf\xf8\xb2\xbe\x23\x11\xc0\x1c\xe0\xc8\x2a\xa9\xd1\x58\x55\x49\x3b\xe0\x9e\x86\x1b\x01\xbc\x7d\x3c\x3e\xcf\x93\x7f\x8a\xdf\x60\xea\x79\xd7\x4d\xab\xf5\xc6\x96\x38\xbd\x2e\x21\x06\x3f\xae\x60\x55\x91\x63\x59\x35\xe8\x14\xdf\xed\x13\x0f\xb5\x21\x14\x54\xca\x56\x6f\x62\x97\xa4\x9b\x15\xc6\x75\x44\x3e\x30\x49\x91\x66\x42\xfc\xf6\x29\x53\x50\x7f\xb1\xcf\x1f\x09\x7e\x0e\x5f\x02\xa8\xcd\x5f\xfa\xc1\xae\xfb\x3a\x25\xff\x3f\x3b\xc1\x34\xfb\x19\x00\x4c\xd7\xeb\x51\x38\x01\x00\x00")
 
 func _20180519220506_add_events_tableUpSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -118,7 +126,7 @@ func _20180519220506_add_events_tableUpSql() (*asset, error) {
 	return a, nil
 }
 
-var __20181114165638_add_device_tokenDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x48\x2d\x4b\xcd\x2b\x29\xe6\x52\x50\x70\x09\xf2\x0f\x50\x70\xf6\xf7\x09\xf5\xf5\x53\x48\x49\x2d\xcb\x4c\x4e\x8d\x2f\xc9\xcf\x4e\xcd\xb3\x06\x04\x00\x00\xff\xff\x55\x1b\x61\x28\x2e\x00\x00\x00")
+var __20181114165638_add_device_tokenDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x2e\x00\xd1\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x65\x76\x65\x6e\x74\x73\x0a\x20\x20\x44\x52\x4f\x50\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x64\x65\x76\x69\x63\x65\x5f\x74\x6f\x6b\x65\x6e\x3b\x03\x00\x55\x1b\x61\x28\x2e\x00\x00\x00")
 
 func _20181114165638_add_device_tokenDownSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -138,7 +146,7 @@ func _20181114165638_add_device_tokenDownSql() (*asset, error) {
 	return a, nil
 }
 
-var __20181114165638_add_device_tokenUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x4c\xcc\xb1\x0a\xc2\x40\x0c\x87\xf1\x3d\x4f\xf1\x1f\xf5\x19\x3a\xc5\x5e\x84\x83\x98\x83\x36\x85\xdb\x6e\xb0\x19\x8a\x50\x07\x4b\xf1\xf1\x05\xe9\xd0\xf9\xc7\xf7\xb1\xba\x0c\x70\xbe\xa9\x20\xf6\x58\xb7\x0f\x01\x9c\x12\xfa\xa2\xd3\xc3\x30\xc7\xbe\x3c\xa3\x6d\xef\x57\xac\x70\xa9\x0e\x2b\x0e\x9b\x54\x3b\xa2\x7e\x10\x76\x41\xb6\x24\x15\xf9\xfe\x27\xa9\x79\xf4\xf1\x78\xb5\x73\xde\x96\xf9\x4b\x40\xb1\x03\x71\x39\xeb\xb5\xfb\x05\x00\x00\xff\xff\x1f\xa7\x88\x38\x8b\x00\x00\x00")
+var __20181114165638_add_device_tokenUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x4c\xcc\xb1\x0a\xc2\x40\x0c\x87\xf1\x3d\x4f\xf1\x1f\xf5\x19\x3a\xc5\x5e\x84\x83\x98\x83\x36\x85\xdb\x6e\xb0\x19\x8a\x50\x07\x4b\xf1\xf1\x05\xe9\xd0\xf9\xc7\xf7\xb1\xba\x0c\x70\xbe\xa9\x20\xf6\x58\xb7\x0f\x01\x9c\x12\xfa\xa2\xd3\xc3\x30\xc7\xbe\x3c\xa3\x6d\xef\x57\xac\x70\xa9\x0e\x2b\x0e\x9b\x54\x3b\xa2\x7e\x10\x76\x41\xb6\x24\x15\xf9\xfe\x27\xa9\x79\xf4\xf1\x78\xb5\x73\xde\x96\xf9\x4b\x40\xb1\x03\x71\x39\xeb\xb5\xfb\x0d\x00\x1f\xa7\x88\x38\x8b\x00\x00\x00")
 
 func _20181114165638_add_device_tokenUpSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -158,7 +166,7 @@ func _20181114165638_add_device_tokenUpSql() (*asset, error) {
 	return a, nil
 }
 
-var __20181123124641_public_key_to_policy_idDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x48\x2d\x4b\xcd\x2b\x29\xe6\x52\x50\x08\x72\xf5\x73\xf4\x75\x55\x70\xf6\xf7\x09\xf5\xf5\x53\x28\xc8\xcf\xc9\x4c\xae\x8c\xcf\x4c\x51\x08\xf1\x57\x28\x28\x4d\xca\xc9\x4c\x8e\xcf\x4e\xad\xb4\xe6\xe2\x82\x68\xf6\xf4\x73\x71\x8d\x80\x6a\x8e\x87\x2b\x8e\xcf\x4c\xa9\x80\x19\x14\xe2\x0f\x97\x86\x6b\x07\xc9\x5b\x03\x02\x00\x00\xff\xff\x97\x5a\x24\x4e\x7e\x00\x00\x00")
+var __20181123124641_public_key_to_policy_idDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x7e\x00\x81\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x65\x76\x65\x6e\x74\x73\x0a\x20\x20\x52\x45\x4e\x41\x4d\x45\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x70\x6f\x6c\x69\x63\x79\x5f\x69\x64\x20\x54\x4f\x20\x70\x75\x62\x6c\x69\x63\x5f\x6b\x65\x79\x3b\x0a\x0a\x41\x4c\x54\x45\x52\x20\x49\x4e\x44\x45\x58\x20\x65\x76\x65\x6e\x74\x73\x5f\x70\x6f\x6c\x69\x63\x79\x5f\x69\x64\x5f\x69\x64\x78\x20\x52\x45\x4e\x41\x4d\x45\x20\x54\x4f\x20\x65\x76\x65\x6e\x74\x73\x5f\x70\x75\x62\x6c\x69\x63\x5f\x6b\x65\x79\x5f\x69\x64\x78\x3b\x03\x00\x97\x5a\x24\x4e\x7e\x00\x00\x00")
 
 func _20181123124641_public_key_to_policy_idDownSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -178,7 +186,7 @@ func _20181123124641_public_key_to_policy_idDownSql() (*asset, error) {
 	return a, nil
 }
 
-var __20181123124641_public_key_to_policy_idUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x48\x2d\x4b\xcd\x2b\x29\xe6\x52\x50\x08\x72\xf5\x73\xf4\x75\x55\x70\xf6\xf7\x09\xf5\xf5\x53\x28\x28\x4d\xca\xc9\x4c\x8e\xcf\x4e\xad\x54\x08\xf1\x57\x28\xc8\xcf\xc9\x4c\xae\x8c\xcf\x4c\xb1\xe6\xe2\x82\x68\xf6\xf4\x73\x71\x8d\x80\x6a\x8e\x47\x28\x8e\xcf\x4c\xa9\x80\x99\x14\xe2\x0f\x97\x87\x69\x07\x49\x5b\x03\x02\x00\x00\xff\xff\xa4\xbf\x61\x1e\x7e\x00\x00\x00")
+var __20181123124641_public_key_to_policy_idUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x7e\x00\x81\xff\x41\x4c\x54\x45\x52\x20\x54\x41\x42\x4c\x45\x20\x65\x76\x65\x6e\x74\x73\x0a\x20\x20\x52\x45\x4e\x41\x4d\x45\x20\x43\x4f\x4c\x55\x4d\x4e\x20\x70\x75\x62\x6c\x69\x63\x5f\x6b\x65\x79\x20\x54\x4f\x20\x70\x6f\x6c\x69\x63\x79\x5f\x69\x64\x3b\x0a\x0a\x41\x4c\x54\x45\x52\x20\x49\x4e\x44\x45\x58\x20\x65\x76\x65\x6e\x74\x73\x5f\x70\x75\x62\x6c\x69\x63\x5f\x6b\x65\x79\x5f\x69\x64\x78\x20\x52\x45\x4e\x41\x4d\x45\x20\x54\x4f\x20\x65\x76\x65\x6e\x74\x73\x5f\x70\x6f\x6c\x69\x63\x79\x5f\x69\x64\x5f\x69\x64\x78\x3b\x03\x00\xa4\xbf\x61\x1e\x7e\x00\x00\x00")
 
 func _20181123124641_public_key_to_policy_idUpSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -198,7 +206,7 @@ func _20181123124641_public_key_to_policy_idUpSql() (*asset, error) {
 	return a, nil
 }
 
-var __20190227121312_create_cert_cacheDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x09\xf2\x0f\x50\x08\x71\x74\xf2\x71\x55\xf0\x74\x53\x70\x8d\xf0\x0c\x0e\x09\x56\x48\x4e\x2d\x2a\xc9\x4c\xcb\x4c\x4e\x2c\x49\x2d\xb6\x06\x04\x00\x00\xff\xff\x9b\x6a\xf7\x60\x22\x00\x00\x00")
+var __20190227121312_create_cert_cacheDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x22\x00\xdd\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x63\x65\x72\x74\x69\x66\x69\x63\x61\x74\x65\x73\x3b\x03\x00\x9b\x6a\xf7\x60\x22\x00\x00\x00")
 
 func _20190227121312_create_cert_cacheDownSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -218,7 +226,7 @@ func _20190227121312_create_cert_cacheDownSql() (*asset, error) {
 	return a, nil
 }
 
-var __20190227121312_create_cert_cacheUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\x0e\x72\x75\x0c\x71\x55\x08\x71\x74\xf2\x71\x55\xf0\x74\x53\xf0\xf3\x0f\x51\x70\x8d\xf0\x0c\x0e\x09\x56\x48\x4e\x2d\x2a\xc9\x4c\xcb\x4c\x4e\x2c\x49\x2d\x56\xd0\xe0\x52\x50\xc8\x4e\xad\x54\x08\x71\x8d\x08\x01\x2b\xf2\x0b\xf5\xf1\x51\x08\x08\xf2\xf4\x75\x0c\x8a\x54\xf0\x76\x8d\xd4\xe1\x52\x40\xd6\xa1\xe0\x14\x19\xe2\xea\x08\x57\xc9\xa5\x69\x0d\x08\x00\x00\xff\xff\x2d\x4d\xb2\x71\x6a\x00\x00\x00")
+var __20190227121312_create_cert_cacheUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x6a\x00\x95\xff\x43\x52\x45\x41\x54\x45\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x4e\x4f\x54\x20\x45\x58\x49\x53\x54\x53\x20\x63\x65\x72\x74\x69\x66\x69\x63\x61\x74\x65\x73\x20\x28\x0a\x20\x20\x6b\x65\x79\x20\x54\x45\x58\x54\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x20\x50\x52\x49\x4d\x41\x52\x59\x20\x4b\x45\x59\x2c\x0a\x20\x20\x63\x65\x72\x74\x69\x66\x69\x63\x61\x74\x65\x20\x42\x59\x54\x45\x41\x20\x4e\x4f\x54\x20\x4e\x55\x4c\x4c\x0a\x29\x3b\x03\x00\x2d\x4d\xb2\x71\x6a\x00\x00\x00")
 
 func _20190227121312_create_cert_cacheUpSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -238,7 +246,7 @@ func _20190227121312_create_cert_cacheUpSql() (*asset, error) {
 	return a, nil
 }
 
-var __20190308140100_rename_policy_idDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x48\x2d\x4b\xcd\x2b\x29\xe6\x52\x50\x08\x72\xf5\x73\xf4\x75\x55\x70\xf6\xf7\x09\xf5\xf5\x53\x48\xce\xcf\xcd\x2d\xcd\xcb\x2c\xa9\x8c\xcf\x4c\x51\x08\xf1\x57\x28\xc8\xcf\xc9\x4c\x06\x71\xac\xb9\xb8\x20\xda\x3d\xfd\x5c\x5c\x23\xa0\xda\xe3\x91\x95\xc7\x67\xa6\x54\xc0\x4c\x0b\xf1\x87\xa9\x80\x1b\x00\x92\xb6\x06\x04\x00\x00\xff\xff\xaa\x43\x03\xd0\x82\x00\x00\x00")
+var __20190308140100_rename_policy_idDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x48\x2d\x4b\xcd\x2b\x29\xe6\x52\x50\x08\x72\xf5\x73\xf4\x75\x55\x70\xf6\xf7\x09\xf5\xf5\x53\x48\xce\xcf\xcd\x2d\xcd\xcb\x2c\xa9\x8c\xcf\x4c\x51\x08\xf1\x57\x28\xc8\xcf\xc9\x4c\x06\x71\xac\xb9\xb8\x20\xda\x3d\xfd\x5c\x5c\x23\xa0\xda\xe3\x91\x95\xc7\x67\xa6\x54\xc0\x4c\x0b\xf1\x87\xa9\x80\x1b\x10\x9f\x99\x52\x61\x0d\x18\x00\xaa\x43\x03\xd0\x82\x00\x00\x00")
 
 func _20190308140100_rename_policy_idDownSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -258,7 +266,7 @@ func _20190308140100_rename_policy_idDownSql() (*asset, error) {
 	return a, nil
 }
 
-var __20190308140100_rename_policy_idUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x48\x2d\x4b\xcd\x2b\x29\xe6\x52\x50\x08\x72\xf5\x73\xf4\x75\x55\x70\xf6\xf7\x09\xf5\xf5\x53\x28\xc8\xcf\xc9\x4c\xae\x8c\xcf\x4c\x51\x08\xf1\x57\x48\xce\xcf\xcd\x2d\xcd\xcb\x2c\x01\xf1\xad\xb9\xb8\x20\xda\x3d\xfd\x5c\x5c\x23\xa0\xda\xe3\xe1\xca\xe3\x33\x53\x2a\x60\x46\x85\xf8\xc3\xa4\x91\x0d\x00\xa9\xb0\x06\x04\x00\x00\xff\xff\x9e\x8e\x99\x66\x82\x00\x00\x00")
+var __20190308140100_rename_policy_idUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x72\xf4\x09\x71\x0d\x52\x08\x71\x74\xf2\x71\x55\x48\x2d\x4b\xcd\x2b\x29\xe6\x52\x50\x08\x72\xf5\x73\xf4\x75\x55\x70\xf6\xf7\x09\xf5\xf5\x53\x28\xc8\xcf\xc9\x4c\xae\x8c\xcf\x4c\x51\x08\xf1\x57\x48\xce\xcf\xcd\x2d\xcd\xcb\x2c\x01\xf1\xad\xb9\xb8\x20\xda\x3d\xfd\x5c\x5c\x23\xa0\xda\xe3\xe1\xca\xe3\x33\x53\x2a\x60\x46\x85\xf8\xc3\xa4\x91\x0d\x88\xcf\x4c\xa9\xb0\x06\x0c\x00\x9e\x8e\x99\x66\x82\x00\x00\x00")
 
 func _20190308140100_rename_policy_idUpSqlBytes() ([]byte, error) {
 	return bindataRead(
@@ -278,6 +286,166 @@ func _20190308140100_rename_policy_idUpSql() (*asset, error) {
 	return a, nil
 }
 
+var __20261019090000_create_devicesDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x1d\x00\xe2\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x64\x65\x76\x69\x63\x65\x73\x3b\x03\x00\x35\x8f\xdd\xbf\x1d\x00\x00\x00")
+
+func _20261019090000_create_devicesDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261019090000_create_devicesDownSql,
+		"20261019090000_create_devices.down.sql",
+	)
+}
+
+func _20261019090000_create_devicesDownSql() (*asset, error) {
+	bytes, err := _20261019090000_create_devicesDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261019090000_create_devices.down.sql", size: 29, mode: os.FileMode(420), modTime: time.Unix(1792374849, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xf, 0x5a, 0xdb, 0xcc, 0xe3, 0xdb, 0x45, 0x29, 0x68, 0xb0, 0x1c, 0x1, 0x6, 0x2b, 0x31, 0x75, 0x7f, 0xe2, 0xab, 0xc5, 0x5f, 0x3b, 0x4b, 0x29, 0x31, 0x35, 0xae, 0x22, 0x7c, 0xea, 0x54, 0x9}}
+	return a, nil
+}
+
+var __20261019090000_create_devicesUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x7c\x90\x41\x6e\xf2\x30\x14\x84\xf7\x3e\xc5\x2c\x13\x29\x37\x60\x65\xcc\x0b\x58\xbf\x63\x23\xfb\x21\xc2\xbf\xb1\x10\xf1\x22\x6a\x09\x12\x49\x51\x7b\xfb\x2a\x94\xb6\x41\x6d\x59\x5a\x33\xf3\x79\xe6\x29\x4f\x92\x09\x2c\xe7\x86\xa0\x4b\x58\xc7\xa0\x5a\x07\x0e\x68\xd2\xa5\x3d\xa4\x1e\x99\x00\x0e\xa7\xe3\xf1\xa5\x6b\x87\xb7\xd8\x36\x60\xaa\xf9\x6a\xb4\x1b\x63\x0a\x81\x9b\x33\x0e\xa7\xa7\xd4\xfd\x54\x9f\xf7\xfd\x10\xfb\x94\xba\xb8\x1f\xc0\xba\xa2\xc0\xb2\x5a\x63\xab\x79\x75\x7d\xe2\xbf\xb3\xf4\x95\xc0\x82\x4a\xb9\x31\x23\x62\x9b\xe5\x63\x7e\xed\x75\x25\xfd\x0e\xff\x68\x87\x6c\x5a\xa4\xb8\xfb\x38\x17\xf9\x4c\x88\xdb\x1e\x6d\x17\x54\xff\xbe\x27\x4e\xfb\xc4\xb6\x79\x15\x80\xb3\x9f\x2a\xb2\xa9\x3c\x12\xb5\x0d\xe4\x19\xda\xb2\xfb\x36\xfd\x5d\xa3\xb8\xdb\x9b\x0b\x20\x90\x21\xc5\x78\x14\x51\x4e\x1a\x0a\x8a\xb2\x4a\xd6\xd9\x39\x1d\x4e\xe7\x26\x35\x63\xbc\xf8\x38\xc3\x88\x29\xbd\xab\x90\x2e\xa9\x1b\x7a\x01\x2c\xbd\xdb\xac\x31\xdf\x3d\xc0\x0a\x67\xa1\x9c\x2d\x8d\x56\x8c\x85\x83\x75\xbc\xd2\x76\x39\x13\xef\x03\x00\xfc\x26\x99\xc9\xf4\x01\x00\x00")
+
+func _20261019090000_create_devicesUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261019090000_create_devicesUpSql,
+		"20261019090000_create_devices.up.sql",
+	)
+}
+
+func _20261019090000_create_devicesUpSql() (*asset, error) {
+	bytes, err := _20261019090000_create_devicesUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261019090000_create_devices.up.sql", size: 500, mode: os.FileMode(420), modTime: time.Unix(1792374849, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xf6, 0xd4, 0x93, 0x23, 0xf9, 0x47, 0xec, 0x5a, 0x39, 0xc8, 0xf8, 0xa6, 0x76, 0x9, 0x45, 0xc6, 0x7a, 0x81, 0xe1, 0x71, 0xb3, 0x54, 0xfa, 0x28, 0x74, 0x40, 0x82, 0x1b, 0xee, 0x22, 0x68, 0xee}}
+	return a, nil
+}
+
+var __20261019100000_partition_eventsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x94\x94\x41\x8f\xda\x30\x10\x85\xef\xfe\x15\x73\xdb\x44\x8a\xfa\x07\x72\x32\xc9\xd0\x5a\x4d\xc6\x34\x99\x08\xe8\x25\x8a\xb0\x0f\x16\xdd\xb0\x65\x5d\xb4\xfc\xfb\x2a\x2c\x81\x24\x4b\x0b\x7b\x8c\xe7\xbd\xcf\xe6\xcd\x13\x32\x63\x2c\x80\xe5\x2c\x43\xb0\x07\xdb\xfa\x57\x28\x90\x64\x8e\xc0\xfa\x7c\x50\xbf\x34\x7b\xef\xbc\xdb\xb5\xd6\xc4\xe2\xa3\x61\x38\xef\xcd\x89\xa6\x92\x0b\xa9\x88\x2f\xa2\xad\x3d\xde\x66\xd6\x2f\x5b\x7b\xec\xc1\x8a\x52\x5c\xf5\xa2\xcd\xee\xf9\xf9\x4f\xeb\xfc\xb1\x76\xa6\x76\xe6\xed\xbf\x4f\xfb\xa0\xbe\x89\xdc\xdb\xcd\x6e\x6f\xac\xa9\x1b\x7f\x9f\x38\x11\xdf\x04\x1a\x7b\x70\x1b\x5b\xfb\xdd\xd6\xb6\xf7\x89\x53\x75\x8f\x2c\xf1\x47\x85\x94\x5c\x22\x75\xa6\x7e\xb5\xbf\x41\x2f\x09\x53\x98\xad\x81\x34\x61\x2c\x44\x52\xa0\x64\x1c\xaf\x2b\x10\x00\xce\xc0\x4c\x7d\xed\xd2\x26\xcd\x40\x55\x96\x41\x8a\x73\x59\x65\x0c\xad\x7d\xf3\x87\xe6\x57\xf0\x34\x22\x3f\x85\xb0\x28\x54\x2e\x8b\x35\x7c\xc7\x75\x24\x00\x86\xe9\x01\xe3\xea\x8a\xea\xa6\x83\x24\x80\x55\x8e\x25\xcb\x7c\x01\x4b\xc5\xdf\x4e\x9f\xf0\x53\x13\x5e\xae\x24\xbd\x0c\xc2\xce\x65\x1a\xdf\xc0\x6c\xcd\x28\x4f\x5f\x83\xdf\x3e\xbe\x41\x84\xb1\x78\x30\x88\xf7\xe3\x2f\xce\x5c\xd3\x78\x5f\x87\x9a\x9f\x1e\x8c\x2b\x55\x72\xd9\xbb\xa7\x95\x10\x00\x9a\xce\x43\x08\x86\xd3\xf0\x21\xde\xa4\x10\x63\xdc\x60\xf8\x18\x6d\x5a\x86\x31\x6e\x38\xed\x78\x8a\x4a\x2c\x18\x14\x5d\x9a\x05\x81\x33\xd1\x68\x71\xd1\x70\x51\xd1\x29\xff\x68\x94\x7b\x28\x00\x4a\xcc\x30\x61\xf8\xb4\x57\x00\xcc\x0b\x9d\xdf\xa8\x75\x2c\x44\x5a\xe8\xc5\xbf\xff\x15\x12\x59\x26\x32\xc5\x5e\x37\xaf\x28\x61\xa5\xa9\x8b\xe5\x1c\xc9\x66\x6f\x1b\x6f\xeb\xa9\x37\x18\x97\x4d\x57\x7c\xed\x5b\x18\x8b\xbf\x03\x00\x9d\x41\xdd\xba\xbe\x04\x00\x00")
+
+func _20261019100000_partition_eventsDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261019100000_partition_eventsDownSql,
+		"20261019100000_partition_events.down.sql",
+	)
+}
+
+func _20261019100000_partition_eventsDownSql() (*asset, error) {
+	bytes, err := _20261019100000_partition_eventsDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261019100000_partition_events.down.sql", size: 1214, mode: os.FileMode(420), modTime: time.Unix(1792374991, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x82, 0x4e, 0x7e, 0x99, 0xb0, 0x74, 0x43, 0x3c, 0x4f, 0x9a, 0x11, 0x85, 0xe, 0xd7, 0x2b, 0x6d, 0x79, 0x8d, 0xce, 0xbd, 0x8, 0x30, 0xc5, 0xc6, 0xd6, 0xed, 0xb2, 0x17, 0x22, 0x95, 0x32, 0x80}}
+	return a, nil
+}
+
+var __20261019100000_partition_eventsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\xb4\x57\x4f\x6f\xdb\x3e\x12\xbd\xeb\x53\x0c\x82\x04\x92\xba\x4e\x80\xee\xb1\xde\x2e\xc0\xc8\x74\x22\x54\x96\xbc\x12\xdd\xc4\x7b\x31\x58\x89\x89\x89\xca\x94\x2b\xd1\x4e\x0c\xf4\xc3\xff\x40\xea\xbf\xfc\xa7\xb9\xf4\x64\x4b\x1c\xbe\x79\x33\xf3\x38\x43\x21\x8f\xe0\x10\x08\xba\xf7\x30\xb0\x3d\x13\xb2\x80\x10\xfb\x68\x86\x81\x04\xd5\x8b\xd5\x4e\x6c\x69\x2e\xb9\xe4\x99\x60\xc9\xd8\x38\xde\xd2\xb7\xa8\x01\x9c\xc0\x8f\x48\x88\x5c\x9f\xd4\x66\xdb\x9f\xec\x70\x0e\x57\x2f\xd6\xe0\xae\x3f\xc1\xcf\xb5\x59\x9c\x6d\x36\x3b\xc1\xe5\x61\xc5\x93\x15\x4f\xde\xff\x40\xf0\xc8\xfe\x24\x68\xce\xe2\x2c\x4f\x58\xb2\xa2\xf2\x23\x98\x03\xf3\x93\x90\x09\xdb\xf3\x98\xad\x64\xf6\x93\x89\x8f\x60\x0e\xed\x6b\xd0\x08\xff\x6f\x81\x7d\xa7\x49\x2e\x4f\x56\x05\xfb\x05\xc1\x93\x8f\x27\x70\xbf\x04\x3f\xf0\xf1\x1f\x6c\x51\x04\xf7\xee\x83\xeb\x93\xb1\x61\x38\x21\x46\x04\xf7\x4b\x6c\x19\x00\x3c\xa9\x6c\xc0\x0f\x08\xf8\x0b\xcf\x83\x09\x9e\xa2\x85\x47\x40\xb0\x77\xb9\xa7\xa9\x65\xf6\x50\x4d\x7b\x64\x00\x74\xb3\x0b\x04\x3f\xb7\xdb\xd5\x6a\x27\x4f\x40\xdc\x19\x8e\x08\x9a\xcd\xe1\xc9\x25\x8f\xfa\x11\xfe\x1f\xf8\xf8\xd8\x9f\x1f\x3c\x59\x1a\x3c\xa1\x92\xc2\xfd\x92\x60\xa4\x9f\x3a\x09\x3a\x76\x35\x0f\xdd\x19\x0a\x97\xf0\x0d\x2f\xc1\xe2\xc9\xa8\xeb\xdb\x36\x6c\x98\xa3\x90\xb8\xc4\x0d\x7c\x95\xb3\x10\xf9\x0f\x18\xac\xae\xc9\xd8\xf8\x60\xbe\xcb\xd7\x77\x3c\x69\x93\x59\xd6\xdd\x9d\xea\x50\xf0\xb3\x1b\x91\xa8\xde\x3d\x54\x9f\x01\x10\xf8\xd5\x22\x58\xdd\x55\xfb\x43\x78\x1d\xca\xc7\x70\x83\x78\xfe\x8c\x36\xd4\x5c\x1f\xae\xbb\xaa\xd8\xdd\xde\x02\x59\x33\x48\xd8\x0b\xdd\xa5\x12\x1a\xf1\x42\x4c\x65\xbc\x66\x05\x50\x71\xa8\x37\xbf\xe5\x5c\x4a\x26\x20\xdb\xc9\x82\x27\x0c\xe4\x9a\x41\x4e\xc5\x2b\x83\xec\x45\x3d\x18\xb7\xb7\xb0\xc9\x84\x5c\xa7\x87\x16\xa8\x00\xeb\x25\xcb\x81\xbd\xd3\xcd\x36\x65\xb0\xe6\x85\xcc\x72\x1e\xd3\x54\x2b\xc1\x1e\x41\x91\x69\x60\x56\x80\x60\x7b\x96\xc3\x0b\xe5\xe9\x5d\x9d\xb5\x52\xd2\x67\xe2\x2c\x29\xb7\x22\x08\xa6\x35\xd3\x4a\x75\x65\x7c\x71\xce\xa8\x64\xab\x6a\x5b\x27\x42\xfd\xbe\x50\xcc\x8f\x69\x43\x9c\x09\x49\xb9\xe0\xe2\x55\x1b\xbc\xf2\x3d\x13\x0a\xcd\x5a\x10\xc7\x06\xc9\x37\xac\x90\x74\xb3\x05\xfe\x02\x5c\x42\x92\x29\xfe\x99\x04\x9a\xe6\x8c\x26\x07\x60\xef\xbc\x90\x4a\xb0\x72\x97\x37\x20\x2d\xba\xa0\x1b\x76\xa7\xe0\x50\x9b\x5e\x95\xa6\x86\x4b\x03\xb4\x66\x69\x02\x5c\x80\x3c\x59\x25\x9a\x2b\xfb\x3d\x4b\x14\x16\x17\x32\xd3\x76\x82\xbd\xb5\x36\x77\xba\xc0\x5b\x9a\x33\x21\x41\xd2\x1f\x29\x03\x5e\x40\x9a\xc5\x3f\x59\x02\xf4\x95\x72\x51\xc8\xba\x02\x2f\x3c\x57\xac\x8b\x4c\xc1\x89\xac\x64\x36\x20\x16\x53\x01\x3f\x58\x23\x06\x99\x9d\xa1\xf6\xb6\xe6\xca\x95\x2c\x14\x56\x9e\xbd\x15\x2d\x59\xa0\x22\x19\x24\x84\x4a\x49\xe3\x35\x4b\x9a\xca\x07\x21\x84\x78\xee\x21\x07\xc3\x74\xe1\x3b\xfa\x94\x9f\xa9\xa4\x25\x8b\x41\x27\x0a\x16\xa4\x6d\x46\xb6\x11\x62\xb2\x08\xfd\xa8\x6c\x30\x28\x82\xeb\x6b\x63\x82\x1d\x0f\x85\xd8\x80\x32\xaa\x55\x21\x69\x7e\xa1\x9f\x7d\xf9\xaa\xe4\xca\x56\x32\xdf\x89\xd8\x32\xf5\x1e\x73\x04\xb2\xb0\x01\x75\x5c\x81\xb9\x20\x8e\x39\x6e\x50\x99\x48\x2e\x62\x5a\xe7\x40\xff\x05\xae\x4f\x70\xf8\x1d\x79\x60\x7e\x2e\xc1\xcc\x73\xae\x9a\x3c\xac\x94\xaa\xca\x20\xbf\x7c\x85\xba\xb3\x9b\xf0\xfb\x37\xc8\x6c\x15\xaf\x69\x7e\xce\xdf\x08\xcc\xab\xc3\xd5\x72\xb9\x5c\x5e\x6d\xae\x66\x33\xd3\x1e\x1b\xf7\xf8\xc1\xf5\x0d\x50\x3d\x46\x66\xab\x9c\xbd\xc6\x29\x2d\x0a\xab\xef\xcc\x06\x37\x6a\x7b\x3d\x79\xc4\x6a\x07\x40\x99\xef\x01\x31\x45\x15\xfb\x13\x70\xa7\x63\xc3\x00\xf0\x02\xe7\x5b\x75\xbc\x2b\xfd\xbb\x3e\x44\x8f\x28\xc4\x10\x06\x4f\x80\x9f\x1d\x6f\x11\xb9\xdf\x31\xcc\x82\x09\xd6\x3b\x6e\x6f\x81\x8a\x4c\xae\x59\x0e\x31\x4d\x53\x96\xc3\x86\x1e\x60\x4d\xf7\xac\x3a\xca\x43\x4d\x95\x0a\x7c\x63\xf0\x46\xb9\x64\x49\xa3\x63\xa5\xfd\xbf\x18\x99\x3b\xad\x3b\x95\x15\x61\x0f\x3b\x04\x3e\xc3\x34\x0c\x66\xc3\xc6\xf5\xf4\x88\x43\xdc\x1b\xa7\xff\xfd\xda\x53\x23\xf2\x27\xbd\xe5\xff\xb4\xaa\xb2\x5b\x4a\xf8\x19\x3b\x0b\x82\x55\x74\x1b\x2a\x2d\xb3\xd7\x39\x6f\x5c\xb0\x3c\xf7\x5b\x27\xc7\x8e\xb7\x98\xb8\xfe\x43\xdd\x22\x23\xdb\x1c\x0d\xc2\xb1\xc7\xa7\x70\xf5\x3b\x00\x53\x0f\xfa\xf2\x14\xa3\x08\xac\x09\xf6\x30\xc1\x1f\x8f\xef\xc6\x3b\x11\xd6\x8d\x57\x25\x56\x11\xfb\x64\x83\xeb\x47\x38\x24\xea\x08\x04\x70\xe3\x42\x95\xc5\x4f\xa5\x17\xed\xdb\x1c\x55\x7c\x3a\xf9\x1a\xb5\xe9\x19\x86\xa4\x8d\x2f\xc7\x75\xe2\x96\x8c\x08\x41\xce\x63\x67\xc0\xdc\xb8\x30\x0d\x42\xf8\x8e\xbc\x05\x8e\x4a\x36\xd6\x8d\x67\xab\xfb\xae\xfa\x6d\x48\xf5\x9d\x8f\xce\x90\x6c\x49\x61\x2f\xc2\x97\xb8\x0d\x4b\x7a\x6a\xe4\xfd\x1d\x62\xad\xaa\xcf\x28\x1f\xfb\x93\xb1\x71\x7d\x0d\x1e\xf2\x1f\x16\xe8\x01\xc3\x36\xdd\xbe\x16\xbf\xd2\xee\xe8\x6d\x37\x95\x43\x8e\xa6\x69\x39\x1f\xd5\x5c\x54\xb7\x80\x11\x6c\xd3\x5d\x51\x8d\xae\x77\x09\x71\xb6\x53\x77\x85\xec\xa5\x24\x58\x18\x93\xa0\xdf\xb3\x5b\x16\xda\xe0\x52\xf7\x6f\x3b\x99\xca\xd0\x70\xa3\x6e\x71\x50\x2b\xec\x95\x09\x96\xab\x0e\x59\xb0\x9c\xb3\xc2\xd2\xe3\xb0\xb4\x1c\x41\x4a\xdb\xff\x27\x9a\xb3\xc6\x29\x25\x51\x25\xbb\x04\xad\x1e\xe0\xe4\xfc\xf0\x30\x8a\x88\xe5\x04\xc8\xc3\x91\x83\xad\x99\xeb\xf7\xee\x7b\xa3\xf2\xda\xdc\xfc\x9e\x18\x00\x36\xa0\x08\xba\x3c\x2f\xfa\xd3\x30\x27\x51\xba\x03\xe7\xdf\x55\xda\x4d\x85\xdd\x86\x5d\x21\x77\x8f\x7a\xef\x6b\x47\xaf\x6b\x3e\x3f\xb2\x9d\x48\x0a\xdd\xe4\x83\xb9\x7e\x3d\xc7\xe1\x34\x08\x67\x67\x27\x78\xf3\xaf\x74\xd5\x88\xcf\x0b\x82\x79\x23\xb2\xb1\x61\x74\x3b\x43\x25\x7c\xfd\x61\xd0\xbd\x74\xf7\x3e\x13\x46\x95\xc0\x7a\xf7\x5e\xa3\xa9\xf8\xf1\xde\xa6\x18\x3d\x90\xba\x0e\xc7\x60\xc6\x85\x8c\x8c\x0d\x63\x12\x06\xf3\x5e\x57\x19\x5a\xfc\x33\x00\x76\x3a\xc8\xe1\x9e\x0f\x00\x00")
+
+func _20261019100000_partition_eventsUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261019100000_partition_eventsUpSql,
+		"20261019100000_partition_events.up.sql",
+	)
+}
+
+func _20261019100000_partition_eventsUpSql() (*asset, error) {
+	bytes, err := _20261019100000_partition_eventsUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261019100000_partition_events.up.sql", size: 3998, mode: os.FileMode(420), modTime: time.Unix(1792374991, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xe8, 0x39, 0x56, 0x45, 0xff, 0xbd, 0x8c, 0xe5, 0x13, 0x83, 0x7, 0x73, 0x5, 0x9b, 0xb, 0x1e, 0x6d, 0x60, 0x96, 0xe1, 0x8c, 0xa0, 0x4c, 0x1a, 0x53, 0xf, 0xbd, 0x68, 0xcf, 0x6f, 0xb8, 0x25}}
+	return a, nil
+}
+
+var __20261019110000_create_archived_segmentsDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x28\x00\xd7\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x61\x72\x63\x68\x69\x76\x65\x64\x5f\x73\x65\x67\x6d\x65\x6e\x74\x73\x3b\x0a\x03\x00\x12\x66\x5e\x94\x28\x00\x00\x00")
+
+func _20261019110000_create_archived_segmentsDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261019110000_create_archived_segmentsDownSql,
+		"20261019110000_create_archived_segments.down.sql",
+	)
+}
+
+func _20261019110000_create_archived_segmentsDownSql() (*asset, error) {
+	bytes, err := _20261019110000_create_archived_segmentsDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261019110000_create_archived_segments.down.sql", size: 40, mode: os.FileMode(420), modTime: time.Unix(1792375723, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x9f, 0x2e, 0x9b, 0xa1, 0xab, 0xc6, 0x3b, 0xa4, 0x24, 0xa9, 0xdd, 0x2b, 0xaf, 0xe1, 0x6d, 0x9a, 0xd4, 0x98, 0xeb, 0x4d, 0xe4, 0xd6, 0x25, 0xcd, 0xf0, 0x85, 0x88, 0xf8, 0x9d, 0x16, 0x18, 0xaf}}
+	return a, nil
+}
+
+var __20261019110000_create_archived_segmentsUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x94\x90\xc1\x4f\x83\x30\x14\xc6\xef\xfd\x2b\xbe\xe3\x48\x38\x99\xe8\x65\x27\x94\x37\x6d\x84\xb2\xc0\x5b\xc6\xbc\x34\x84\x36\x8e\x18\x4a\x02\xdd\x32\xfe\x7b\x83\x1a\x83\xec\xa0\x1e\xdb\xd7\xdf\xf7\xf5\xfd\x1e\x72\x8a\x98\xc0\xd1\x7d\x42\x90\x1b\xa8\x8c\x41\xa5\x2c\xb8\x40\xd5\xd7\xc7\xe6\x6c\x8d\x1e\xec\x6b\x6b\x9d\x1f\xb0\x12\xc0\x9b\x1d\xc1\x54\x32\xb6\xb9\x4c\xa3\xfc\x80\x67\x3a\x84\x02\xa8\xbb\xb6\x3d\xb9\xc6\x8f\xba\x31\x9f\x0f\xa6\x28\xb5\x4b\x92\x69\x6a\xaa\x11\xf1\x54\x34\xbf\x6c\x1b\xa7\x7b\x5b\x77\xbd\xb1\x46\x57\x1e\x2c\x53\x2a\x38\x4a\xb7\xd8\x4b\x7e\xfa\x38\xe2\x25\x53\x0b\xa8\xba\xfc\x1f\xb2\x67\xeb\xbc\xae\xbb\x93\xf3\x90\x8a\xe9\x91\xf2\x1f\xf3\xe1\x58\xdd\xdc\xde\x5d\x7f\xfb\x5b\xc1\x5f\x8a\x10\xd3\x26\xda\x25\x53\xc2\x7e\x15\x88\x60\x2d\xc4\x97\x5d\xa9\x62\x2a\x7f\xb3\xab\xe7\x06\xe7\x2b\xea\xc6\x5c\x04\x90\xa9\x6b\x06\xab\x39\x14\x2e\x8d\x86\x4b\x5b\xc1\x5a\xbc\x0f\x00\x72\x19\x76\x9c\xf1\x01\x00\x00")
+
+func _20261019110000_create_archived_segmentsUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261019110000_create_archived_segmentsUpSql,
+		"20261019110000_create_archived_segments.up.sql",
+	)
+}
+
+func _20261019110000_create_archived_segmentsUpSql() (*asset, error) {
+	bytes, err := _20261019110000_create_archived_segmentsUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261019110000_create_archived_segments.up.sql", size: 497, mode: os.FileMode(420), modTime: time.Unix(1792375723, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x7a, 0x3f, 0xf0, 0x9, 0x94, 0xbf, 0xe1, 0xa0, 0xdd, 0x62, 0xb5, 0x37, 0x42, 0xae, 0x39, 0x6f, 0x1c, 0x9c, 0x24, 0x57, 0x3a, 0x16, 0x42, 0xa5, 0xd2, 0xc3, 0x41, 0xb0, 0x9d, 0x7c, 0xe4, 0x70}}
+	return a, nil
+}
+
+var __20261019120000_create_webhooksDownSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x00\x48\x00\xb7\xff\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x77\x65\x62\x68\x6f\x6f\x6b\x5f\x64\x65\x6c\x69\x76\x65\x72\x69\x65\x73\x3b\x0a\x44\x52\x4f\x50\x20\x54\x41\x42\x4c\x45\x20\x49\x46\x20\x45\x58\x49\x53\x54\x53\x20\x77\x65\x62\x68\x6f\x6f\x6b\x73\x3b\x0a\x03\x00\xc3\x05\xa3\x53\x48\x00\x00\x00")
+
+func _20261019120000_create_webhooksDownSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261019120000_create_webhooksDownSql,
+		"20261019120000_create_webhooks.down.sql",
+	)
+}
+
+func _20261019120000_create_webhooksDownSql() (*asset, error) {
+	bytes, err := _20261019120000_create_webhooksDownSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261019120000_create_webhooks.down.sql", size: 72, mode: os.FileMode(420), modTime: time.Unix(1792377358, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0xf4, 0x76, 0xcc, 0xc8, 0x9c, 0x88, 0x66, 0xf2, 0x38, 0x68, 0x1, 0xd9, 0x5e, 0x62, 0x7f, 0xb3, 0x26, 0xbb, 0x4b, 0x22, 0x4b, 0x31, 0xb3, 0x9a, 0x44, 0xa0, 0x85, 0x68, 0x75, 0xf1, 0x17, 0x96}}
+	return a, nil
+}
+
+var __20261019120000_create_webhooksUpSql = []byte("\x1f\x8b\x08\x00\x00\x00\x00\x00\x00\xff\x9c\x53\x4d\x6f\x1a\x31\x14\xbc\xef\xaf\x98\x63\x90\x42\xd4\x7b\x4e\x0b\x3c\xd2\x55\x97\x25\x5a\x1c\x05\x7a\x59\x99\xf5\x4b\xd7\x02\x6c\x64\x9b\x8f\xfc\xfb\xca\x1b\x20\x14\x1a\x51\xf5\x64\x3d\xcf\x78\xc6\xcf\xf3\xdc\x2f\x29\x15\x04\x91\xf6\x72\x42\x36\x44\x31\x16\xa0\x69\x36\x11\x13\xec\x78\xde\x58\xbb\xf0\xb8\x4b\x00\xad\xd0\xcb\x9e\x26\x54\x66\x69\x8e\xe7\x32\x1b\xa5\xe5\x0c\x3f\x68\x76\x9f\x00\xb5\x5d\xad\x36\x46\x87\xf7\x4a\x2b\x08\x9a\x8a\x56\xa5\x78\xc9\xf3\x88\x6e\xdc\xf2\x7a\xd3\x73\xed\x38\x5c\xef\xd7\x8e\x65\x60\x55\xc9\x00\x91\x8d\x68\x22\xd2\xd1\x33\x5e\x33\xf1\xbd\x2d\xf1\x73\x5c\xd0\x89\x8f\x01\x0d\xd3\x97\x3c\x0a\xbc\xde\x75\x92\xce\x63\x92\x1c\xba\xc9\x8a\x01\x4d\xbf\xe8\xa6\x3a\xbf\x6d\xa5\xd5\x3e\x01\xc6\xc5\x59\xb3\xe7\x78\xd4\xec\x76\x8f\x60\xa5\x78\xa9\xb7\xec\x34\x7b\x68\x8f\xd0\x30\xec\x26\xcc\xed\x1e\xf6\x0d\xbc\x65\x13\x3c\x7c\xd0\xcb\x25\x82\xc5\x9c\x71\xa0\xb3\x8a\x35\xcb\xba\x49\xba\x5d\xf8\xcd\xdc\xd7\x4e\xcf\x59\x1d\x65\x1f\x50\xda\x9d\x87\x74\x8c\x9d\xd3\x21\xb0\x81\x36\xad\xba\x97\x2b\x46\x70\xd2\x78\x59\x07\x6d\x0d\xe4\x87\x6b\xeb\x75\x1f\xd5\xa4\x51\xd1\x86\x03\x2b\x58\x53\x73\x0b\x1f\x74\xd1\x48\x0f\x59\xd7\xbc\x8e\x68\x68\x4e\x17\x7a\x7f\x40\x0a\xc3\xfb\x50\xc9\x10\x78\xb5\x8e\x2b\xec\x5b\xd4\x8b\x39\x60\x25\xdd\xc2\x43\x9e\xe8\xd8\x31\x1a\xb9\x65\xfc\xd2\x5b\x36\xd8\xac\x61\xcd\x43\x72\x7b\x72\xce\xdf\xeb\xd6\x0c\x1d\x8f\x7c\x70\xb2\xe2\x73\x2c\x50\xd2\x90\x4a\x2a\xfa\xf4\x19\x22\xee\xb4\xea\xc4\xd8\x06\x94\x93\x20\xf4\xd3\x49\x3f\x1d\xd0\xed\x61\x54\xbc\xd5\x35\x57\xc1\x2e\xd8\xfc\x05\x95\x41\xa2\x37\x13\x94\xc6\xca\x71\x6d\x9d\xfa\xaf\x61\x8c\xc7\x0f\x6f\xeb\x91\x15\x82\x9e\xa8\xbc\xa6\x7e\x8b\xb4\xcb\x1c\xbe\x74\xba\x32\x58\x4a\x1f\x2a\x76\xce\xba\xb6\x93\x7f\xfc\x00\x67\xa1\x54\x17\xde\x57\xbf\xe1\x8f\x00\x2f\xc8\x9d\xc7\xe4\xf7\x00\x3b\x67\xd1\xb3\x3c\x04\x00\x00")
+
+func _20261019120000_create_webhooksUpSqlBytes() ([]byte, error) {
+	return bindataRead(
+		__20261019120000_create_webhooksUpSql,
+		"20261019120000_create_webhooks.up.sql",
+	)
+}
+
+func _20261019120000_create_webhooksUpSql() (*asset, error) {
+	bytes, err := _20261019120000_create_webhooksUpSqlBytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindataFileInfo{name: "20261019120000_create_webhooks.up.sql", size: 1084, mode: os.FileMode(420), modTime: time.Unix(1792377358, 0)}
+	a := &asset{bytes: bytes, info: info, digest: [32]uint8{0x79, 0x4b, 0x78, 0x6c, 0x2e, 0x3d, 0xe9, 0xe2, 0xaf, 0xa7, 0x1c, 0xd8, 0x1b, 0xc0, 0xef, 0x8a, 0x6f, 0xdd, 0xd7, 0xe6, 0x85, 0x88, 0x5, 0x2c, 0xb0, 0xee, 0x71, 0xb3, 0xa4, 0xfe, 0x4e, 0x8d}}
+	return a, nil
+}
+
 // Asset loads and returns the asset for the given name.
 // It returns an error if the asset could not be found or
 // could not be loaded.
@@ -388,6 +556,22 @@ var _bindata = map[string]func() (*asset, error){
 	"20190308140100_rename_policy_id.down.sql": _20190308140100_rename_policy_idDownSql,
 
 	"20190308140100_rename_policy_id.up.sql": _20190308140100_rename_policy_idUpSql,
+
+	"20261019090000_create_devices.down.sql": _20261019090000_create_devicesDownSql,
+
+	"20261019090000_create_devices.up.sql": _20261019090000_create_devicesUpSql,
+
+	"20261019100000_partition_events.down.sql": _20261019100000_partition_eventsDownSql,
+
+	"20261019100000_partition_events.up.sql": _20261019100000_partition_eventsUpSql,
+
+	"20261019110000_create_archived_segments.down.sql": _20261019110000_create_archived_segmentsDownSql,
+
+	"20261019110000_create_archived_segments.up.sql": _20261019110000_create_archived_segmentsUpSql,
+
+	"20261019120000_create_webhooks.down.sql": _20261019120000_create_webhooksDownSql,
+
+	"20261019120000_create_webhooks.up.sql": _20261019120000_create_webhooksUpSql,
 }
 
 // AssetDir returns the file names below a certain
@@ -431,16 +615,24 @@ type bintree struct {
 }
 
 var _bintree = &bintree{nil, map[string]*bintree{
-	"20180519220506_add_events_table.down.sql":        &bintree{_20180519220506_add_events_tableDownSql, map[string]*bintree{}},
-	"20180519220506_add_events_table.up.sql":          &bintree{_20180519220506_add_events_tableUpSql, map[string]*bintree{}},
-	"20181114165638_add_device_token.down.sql":        &bintree{_20181114165638_add_device_tokenDownSql, map[string]*bintree{}},
-	"20181114165638_add_device_token.up.sql":          &bintree{_20181114165638_add_device_tokenUpSql, map[string]*bintree{}},
-	"20181123124641_public_key_to_policy_id.down.sql": &bintree{_20181123124641_public_key_to_policy_idDownSql, map[string]*bintree{}},
-	"20181123124641_public_key_to_policy_id.up.sql":   &bintree{_20181123124641_public_key_to_policy_idUpSql, map[string]*bintree{}},
-	"20190227121312_create_cert_cache.down.sql":       &bintree{_20190227121312_create_cert_cacheDownSql, map[string]*bintree{}},
-	"20190227121312_create_cert_cache.up.sql":         &bintree{_20190227121312_create_cert_cacheUpSql, map[string]*bintree{}},
-	"20190308140100_rename_policy_id.down.sql":        &bintree{_20190308140100_rename_policy_idDownSql, map[string]*bintree{}},
-	"20190308140100_rename_policy_id.up.sql":          &bintree{_20190308140100_rename_policy_idUpSql, map[string]*bintree{}},
+	"20180519220506_add_events_table.down.sql":         &bintree{_20180519220506_add_events_tableDownSql, map[string]*bintree{}},
+	"20180519220506_add_events_table.up.sql":           &bintree{_20180519220506_add_events_tableUpSql, map[string]*bintree{}},
+	"20181114165638_add_device_token.down.sql":         &bintree{_20181114165638_add_device_tokenDownSql, map[string]*bintree{}},
+	"20181114165638_add_device_token.up.sql":           &bintree{_20181114165638_add_device_tokenUpSql, map[string]*bintree{}},
+	"20181123124641_public_key_to_policy_id.down.sql":  &bintree{_20181123124641_public_key_to_policy_idDownSql, map[string]*bintree{}},
+	"20181123124641_public_key_to_policy_id.up.sql":    &bintree{_20181123124641_public_key_to_policy_idUpSql, map[string]*bintree{}},
+	"20190227121312_create_cert_cache.down.sql":        &bintree{_20190227121312_create_cert_cacheDownSql, map[string]*bintree{}},
+	"20190227121312_create_cert_cache.up.sql":          &bintree{_20190227121312_create_cert_cacheUpSql, map[string]*bintree{}},
+	"20190308140100_rename_policy_id.down.sql":         &bintree{_20190308140100_rename_policy_idDownSql, map[string]*bintree{}},
+	"20190308140100_rename_policy_id.up.sql":           &bintree{_20190308140100_rename_policy_idUpSql, map[string]*bintree{}},
+	"20261019090000_create_devices.down.sql":           &bintree{_20261019090000_create_devicesDownSql, map[string]*bintree{}},
+	"20261019090000_create_devices.up.sql":             &bintree{_20261019090000_create_devicesUpSql, map[string]*bintree{}},
+	"20261019100000_partition_events.down.sql":         &bintree{_20261019100000_partition_eventsDownSql, map[string]*bintree{}},
+	"20261019100000_partition_events.up.sql":           &bintree{_20261019100000_partition_eventsUpSql, map[string]*bintree{}},
+	"20261019110000_create_archived_segments.down.sql": &bintree{_20261019110000_create_archived_segmentsDownSql, map[string]*bintree{}},
+	"20261019110000_create_archived_segments.up.sql":   &bintree{_20261019110000_create_archived_segmentsUpSql, map[string]*bintree{}},
+	"20261019120000_create_webhooks.down.sql":          &bintree{_20261019120000_create_webhooksDownSql, map[string]*bintree{}},
+	"20261019120000_create_webhooks.up.sql":            &bintree{_20261019120000_create_webhooksUpSql, map[string]*bintree{}},
 }}
 
 // RestoreAsset restores an asset under the given directory.
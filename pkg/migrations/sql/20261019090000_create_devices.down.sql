@@ -0,0 +1 @@
+DROP TABLE IF EXISTS devices;
\ No newline at end of file
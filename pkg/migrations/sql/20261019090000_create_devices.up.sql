@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS devices (
+  community_id TEXT NOT NULL,
+  device_token TEXT NOT NULL,
+  last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (community_id, device_token)
+);
+
+CREATE INDEX IF NOT EXISTS devices_last_seen_at_idx
+  ON devices (last_seen_at);
+
+INSERT INTO devices (community_id, device_token, last_seen_at)
+  SELECT community_id, device_token, COALESCE(MAX(recorded_at), NOW())
+  FROM events
+  GROUP BY community_id, device_token
+ON CONFLICT DO NOTHING;
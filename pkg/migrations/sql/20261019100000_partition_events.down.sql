@@ -0,0 +1,33 @@
+ALTER TABLE events RENAME TO events_partitioned;
+ALTER TABLE events_partitioned RENAME CONSTRAINT events_pkey TO events_partitioned_pkey;
+ALTER INDEX events_community_id_idx RENAME TO events_partitioned_community_id_idx;
+ALTER INDEX events_recorded_at_idx RENAME TO events_partitioned_recorded_at_idx;
+ALTER INDEX events_device_token_idx RENAME TO events_partitioned_device_token_idx;
+ALTER SEQUENCE events_id_seq OWNED BY NONE;
+
+CREATE TABLE events (
+  id BIGINT NOT NULL DEFAULT nextval('events_id_seq') PRIMARY KEY,
+  community_id TEXT NOT NULL,
+  recorded_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  data BYTEA,
+  device_token TEXT NOT NULL
+);
+
+ALTER SEQUENCE events_id_seq OWNED BY events.id;
+
+CREATE INDEX IF NOT EXISTS events_community_id_idx
+  ON events (community_id);
+
+CREATE INDEX IF NOT EXISTS events_recorded_at_idx
+  ON events (recorded_at);
+
+CREATE INDEX IF NOT EXISTS events_device_token_idx
+  ON events (device_token);
+
+INSERT INTO events (id, community_id, recorded_at, data, device_token)
+  SELECT id, community_id, recorded_at, data, device_token
+  FROM events_partitioned;
+
+DROP TABLE events_partitioned CASCADE;
+
+DROP FUNCTION IF EXISTS create_events_partition(TIMESTAMP WITHOUT TIME ZONE);
@@ -0,0 +1,101 @@
+ALTER TABLE events RENAME TO events_unpartitioned;
+ALTER TABLE events_unpartitioned RENAME CONSTRAINT events_pkey TO events_unpartitioned_pkey;
+ALTER INDEX events_community_id_idx RENAME TO events_unpartitioned_community_id_idx;
+ALTER INDEX events_recorded_at_idx RENAME TO events_unpartitioned_recorded_at_idx;
+ALTER INDEX events_device_token_idx RENAME TO events_unpartitioned_device_token_idx;
+ALTER SEQUENCE events_id_seq OWNED BY NONE;
+ALTER SEQUENCE events_id_seq AS BIGINT;
+
+CREATE TABLE events (
+  id BIGINT NOT NULL DEFAULT nextval('events_id_seq'),
+  community_id TEXT NOT NULL,
+  recorded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  data BYTEA,
+  device_token TEXT NOT NULL,
+  PRIMARY KEY (id, recorded_at)
+) PARTITION BY RANGE (recorded_at);
+
+ALTER SEQUENCE events_id_seq OWNED BY events.id;
+
+CREATE INDEX IF NOT EXISTS events_community_id_idx
+  ON events (community_id);
+
+CREATE INDEX IF NOT EXISTS events_recorded_at_idx
+  ON events (recorded_at);
+
+CREATE INDEX IF NOT EXISTS events_device_token_idx
+  ON events (device_token);
+
+-- The default partition catches any events written outside the range of the
+-- monthly partitions (for example historical data), so writes never fail.
+CREATE TABLE IF NOT EXISTS events_default PARTITION OF events DEFAULT;
+
+-- create_events_partition creates the monthly partition containing the given
+-- (UTC) timestamp if it does not already exist, returning the partition name.
+-- Any events for the month already held in the default partition are moved
+-- into the new partition. The parent table is locked against writes first, so
+-- no event for the month can be written to the default partition while its
+-- rows are moved and the partition attached.
+CREATE OR REPLACE FUNCTION create_events_partition(ts TIMESTAMP WITHOUT TIME ZONE)
+RETURNS TEXT AS $$
+DECLARE
+  month_start TIMESTAMP WITH TIME ZONE := date_trunc('month', ts) AT TIME ZONE 'UTC';
+  month_end TIMESTAMP WITH TIME ZONE := (date_trunc('month', ts) + INTERVAL '1 month') AT TIME ZONE 'UTC';
+  partition_name TEXT := 'events_' || to_char(date_trunc('month', ts), '"y"YYYY"m"MM');
+BEGIN
+  IF to_regclass(partition_name) IS NOT NULL THEN
+    RETURN partition_name;
+  END IF;
+
+  LOCK TABLE events IN SHARE ROW EXCLUSIVE MODE;
+
+  -- another caller may have created the partition while we waited for the lock
+  IF to_regclass(partition_name) IS NOT NULL THEN
+    RETURN partition_name;
+  END IF;
+
+  IF EXISTS (SELECT 1 FROM events_default WHERE recorded_at >= month_start AND recorded_at < month_end) THEN
+    EXECUTE format('CREATE TABLE %I (LIKE events INCLUDING DEFAULTS)', partition_name);
+    EXECUTE format(
+      'WITH moved AS (DELETE FROM events_default WHERE recorded_at >= %L AND recorded_at < %L RETURNING *) INSERT INTO %I SELECT * FROM moved',
+      month_start, month_end, partition_name
+    );
+    EXECUTE format(
+      'ALTER TABLE events ATTACH PARTITION %I FOR VALUES FROM (%L) TO (%L)',
+      partition_name, month_start, month_end
+    );
+  ELSE
+    EXECUTE format(
+      'CREATE TABLE %I PARTITION OF events FOR VALUES FROM (%L) TO (%L)',
+      partition_name, month_start, month_end
+    );
+  END IF;
+
+  RETURN partition_name;
+END;
+$$ LANGUAGE plpgsql;
+
+-- create partitions for all existing data, plus the next couple of months
+DO $$
+DECLARE
+  partition_month TIMESTAMP WITHOUT TIME ZONE;
+BEGIN
+  FOR partition_month IN
+    SELECT generate_series(first_month, last_month, INTERVAL '1 month')
+    FROM (
+      SELECT
+        date_trunc('month', LEAST(COALESCE(MIN(recorded_at), NOW()), NOW()) AT TIME ZONE 'UTC') AS first_month,
+        date_trunc('month', NOW() AT TIME ZONE 'UTC') + INTERVAL '2 months' AS last_month
+      FROM events_unpartitioned
+    ) AS bounds
+  LOOP
+    PERFORM create_events_partition(partition_month);
+  END LOOP;
+END;
+$$;
+
+INSERT INTO events (id, community_id, recorded_at, data, device_token)
+  SELECT id, community_id, COALESCE(recorded_at, NOW()), data, device_token
+  FROM events_unpartitioned;
+
+DROP TABLE events_unpartitioned;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS archived_segments (
+  key TEXT PRIMARY KEY,
+  community_id TEXT NOT NULL,
+  day DATE NOT NULL,
+  min_recorded_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  max_recorded_at TIMESTAMP WITH TIME ZONE NOT NULL,
+  event_count INTEGER NOT NULL,
+  sha256 TEXT NOT NULL,
+  archived_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS archived_segments_community_id_recorded_at_idx
+  ON archived_segments (community_id, min_recorded_at, max_recorded_at);
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+  id BIGSERIAL PRIMARY KEY,
+  community_id TEXT NOT NULL,
+  url TEXT NOT NULL,
+  secret TEXT NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS webhooks_community_id_idx
+  ON webhooks (community_id);
+
+-- webhook_deliveries is the outbox of events still to be delivered to each
+-- subscribed webhook. Rows are written in the same transaction as the event,
+-- and deleted once the webhook has accepted the delivery. A next_attempt_at of
+-- NULL marks a delivery we have given up on.
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+  id BIGSERIAL PRIMARY KEY,
+  webhook_id BIGINT NOT NULL REFERENCES webhooks (id) ON DELETE CASCADE,
+  community_id TEXT NOT NULL,
+  device_token TEXT NOT NULL,
+  data BYTEA,
+  recorded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  attempts INTEGER NOT NULL DEFAULT 0,
+  next_attempt_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+  last_error TEXT
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_next_attempt_at_idx
+  ON webhook_deliveries (next_attempt_at);
@@ -0,0 +1,197 @@
+package mqtt_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	datastore "github.com/thingful/twirp-datastore-go"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotstore/pkg/mqtt"
+)
+
+// fakeWriter records the requests it is asked to write, failing the first
+// failures requests.
+type fakeWriter struct {
+	sync.Mutex
+	failures int
+	attempts int
+	requests []*datastore.WriteRequest
+}
+
+func (f *fakeWriter) WriteData(ctx context.Context, req *datastore.WriteRequest) (*datastore.WriteResponse, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	f.attempts++
+
+	if req.DeviceToken == "" {
+		return nil, twirp.RequiredArgumentError("device_token")
+	}
+
+	if f.failures > 0 {
+		f.failures--
+		return nil, errors.New("database unavailable")
+	}
+
+	f.requests = append(f.requests, req)
+	return &datastore.WriteResponse{}, nil
+}
+
+func (f *fakeWriter) written() []*datastore.WriteRequest {
+	f.Lock()
+	defer f.Unlock()
+	return f.requests
+}
+
+func (f *fakeWriter) attempted() int {
+	f.Lock()
+	defer f.Unlock()
+	return f.attempts
+}
+
+// eventually waits up to 10 seconds for cond to return true, failing the test
+// if it does not.
+func eventually(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for condition")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// brokerURL returns the URL of the broker to test against, i.e. the mosquitto
+// service from docker-compose, skipping the test if none is configured.
+func brokerURL(t *testing.T) string {
+	u := os.Getenv("IOTSTORE_MQTT_BROKER_URL")
+	if u == "" {
+		t.Skip("IOTSTORE_MQTT_BROKER_URL is not set")
+	}
+	return u
+}
+
+// publisher returns a client connected to the broker with which the test
+// publishes messages.
+func publisher(t *testing.T, broker string) paho.Client {
+	c := paho.NewClient(
+		paho.NewClientOptions().
+			AddBroker(broker).
+			SetClientID(fmt.Sprintf("iotstore-test-publisher-%d", time.Now().UnixNano())),
+	)
+
+	token := c.Connect()
+	assert.True(t, token.WaitTimeout(10*time.Second))
+	assert.Nil(t, token.Error())
+
+	return c
+}
+
+func publish(t *testing.T, c paho.Client, topic, payload string) {
+	token := c.Publish(topic, 1, false, payload)
+	assert.True(t, token.WaitTimeout(10*time.Second))
+	assert.Nil(t, token.Error())
+}
+
+func newSubscriber(t *testing.T, broker, prefix, clientID string, writer mqtt.Writer) *mqtt.Subscriber {
+	sub, err := mqtt.NewSubscriber(
+		&mqtt.Config{
+			BrokerURL: broker,
+			Topic:     prefix + "/community/+/device/+",
+			ClientID:  clientID,
+		},
+		writer,
+		kitlog.NewNopLogger(),
+	)
+	assert.Nil(t, err)
+
+	sub.Start()
+
+	eventually(t, sub.Connected)
+
+	return sub
+}
+
+func TestSubscriber(t *testing.T) {
+	broker := brokerURL(t)
+	prefix := fmt.Sprintf("iotstore-test-%d", time.Now().UnixNano())
+
+	writer := &fakeWriter{failures: 1}
+
+	sub := newSubscriber(t, broker, prefix, prefix+"-subscriber", writer)
+	defer sub.Stop()
+
+	pub := publisher(t, broker)
+	defer pub.Disconnect(250)
+
+	// the first message fails to be stored once and is retried, invalid
+	// messages are acknowledged and dropped, and messages are stored in order
+	publish(t, pub, prefix+"/community/abc123/device/device-1", "first")
+	publish(t, pub, prefix+"/community/abc123/device/", "invalid")
+	publish(t, pub, prefix+"/community/abc123/device/device-2", "second")
+
+	eventually(t, func() bool { return len(writer.written()) == 2 })
+
+	written := writer.written()
+	assert.Len(t, written, 2)
+	assert.Equal(t, "abc123", written[0].CommunityId)
+	assert.Equal(t, "device-1", written[0].DeviceToken)
+	assert.Equal(t, "first", string(written[0].Data))
+	assert.Equal(t, "device-2", written[1].DeviceToken)
+	assert.Equal(t, "second", string(written[1].Data))
+}
+
+func TestSubscriberRedelivery(t *testing.T) {
+	broker := brokerURL(t)
+	prefix := fmt.Sprintf("iotstore-test-%d", time.Now().UnixNano())
+
+	failing := &fakeWriter{failures: 1000}
+
+	sub := newSubscriber(t, broker, prefix, prefix+"-subscriber", failing)
+
+	pub := publisher(t, broker)
+	defer pub.Disconnect(250)
+
+	publish(t, pub, prefix+"/community/abc123/device/device-1", "first")
+
+	eventually(t, func() bool { return failing.attempted() > 0 })
+
+	// stopping while the message is still failing leaves it unacknowledged, so
+	// the broker redelivers it to the next session with the same client id
+	sub.Stop()
+	sub.Stop()
+
+	writer := &fakeWriter{}
+
+	sub = newSubscriber(t, broker, prefix, prefix+"-subscriber", writer)
+	defer sub.Stop()
+
+	eventually(t, func() bool { return len(writer.written()) == 1 })
+
+	written := writer.written()
+	assert.Equal(t, "device-1", written[0].DeviceToken)
+	assert.Equal(t, "first", string(written[0].Data))
+}
+
+func TestNewSubscriberInvalidURL(t *testing.T) {
+	_, err := mqtt.NewSubscriber(&mqtt.Config{BrokerURL: "http://broker"}, &fakeWriter{}, kitlog.NewNopLogger())
+	assert.NotNil(t, err)
+}
+
+func TestSubscriberStopWithoutStart(t *testing.T) {
+	sub, err := mqtt.NewSubscriber(&mqtt.Config{BrokerURL: "tcp://localhost:1"}, &fakeWriter{}, kitlog.NewNopLogger())
+	assert.Nil(t, err)
+
+	sub.Stop()
+	assert.False(t, sub.Connected())
+}
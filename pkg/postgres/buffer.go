@@ -0,0 +1,321 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	sq "github.com/elgris/sqrl"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	registry "github.com/thingful/retryable-registry-prometheus"
+
+	"github.com/DECODEproject/iotstore/pkg/reporting"
+)
+
+const (
+	// DefaultWriteBufferSize is the default maximum number of events we collect
+	// into a single batch before flushing the write buffer.
+	DefaultWriteBufferSize = 500
+)
+
+var (
+	// flushDuration is a histogram recording how long it takes to flush a batch
+	// of buffered writes to the database.
+	flushDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "write_flush_duration_sec",
+			Help:      "Time (in seconds) spent flushing buffered writes to Postgres",
+			Buckets:   prometheus.DefBuckets,
+		},
+	)
+
+	// batchSize is a histogram recording the number of events written in each
+	// flush of the write buffer.
+	batchSize = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "write_batch_size",
+			Help:      "Number of events written to Postgres per flush of the write buffer",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 11),
+		},
+	)
+)
+
+func init() {
+	registry.MustRegister(flushDuration, batchSize)
+}
+
+// writeRequest is an internal type used to pass a single write into the write
+// buffer. The result of the flush in which the write is committed is sent back
+// on the result channel.
+type writeRequest struct {
+	communityID string
+	data        []byte
+	deviceToken string
+	result      chan error
+}
+
+// writeBuffer collects incoming writes for a short interval and then flushes
+// them to the database as a single batch using COPY, which is considerably
+// faster than committing a transaction per event. Callers block until the batch
+// containing their write has been committed, so a write is only acknowledged
+// once it is durable.
+type writeBuffer struct {
+	db       *sqlx.DB
+	interval time.Duration
+	size     int
+	requests chan *writeRequest
+	quit     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+	logger   kitlog.Logger
+}
+
+// newWriteBuffer returns a new writeBuffer that flushes after the given interval
+// or once size events have been collected, whichever comes first.
+func newWriteBuffer(db *sqlx.DB, interval time.Duration, size int, logger kitlog.Logger) *writeBuffer {
+	if size <= 0 {
+		size = DefaultWriteBufferSize
+	}
+
+	return &writeBuffer{
+		db:       db,
+		interval: interval,
+		size:     size,
+		requests: make(chan *writeRequest),
+		quit:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		logger:   kitlog.With(logger, "component", "writeBuffer"),
+	}
+}
+
+// Start starts the goroutine that collects and flushes writes.
+func (b *writeBuffer) Start() {
+	b.logger.Log("msg", "starting write buffer", "interval", b.interval, "size", b.size)
+
+	go b.run()
+}
+
+// Stop stops accepting new writes, flushes any pending writes and waits for the
+// flush to complete. It is safe to call Stop more than once.
+func (b *writeBuffer) Stop() {
+	b.stopOnce.Do(func() {
+		b.logger.Log("msg", "stopping write buffer")
+
+		close(b.quit)
+	})
+
+	<-b.stopped
+}
+
+// write submits a single event to the buffer, blocking until the batch
+// containing the event has been committed or has failed. If the context is
+// cancelled before the batch is committed we return the context's error, but
+// note the event may still be committed as part of the batch.
+func (b *writeBuffer) write(ctx context.Context, communityID string, data []byte, deviceToken string) error {
+	req := &writeRequest{
+		communityID: communityID,
+		data:        data,
+		deviceToken: deviceToken,
+		result:      make(chan error, 1),
+	}
+
+	select {
+	case b.requests <- req:
+	case <-b.quit:
+		return errors.New("write buffer is stopped")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-req.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run is the main loop of the buffer, collecting requests into a batch until
+// either the batch is full or the flush interval has elapsed since the first
+// request in the batch was received.
+func (b *writeBuffer) run() {
+	defer close(b.stopped)
+
+	batch := []*writeRequest{}
+
+	var (
+		timer  *time.Timer
+		timerC <-chan time.Time
+	)
+
+	flush := func() {
+		if timer != nil {
+			timer.Stop()
+			timer = nil
+			timerC = nil
+		}
+
+		if len(batch) == 0 {
+			return
+		}
+
+		err := b.flush(batch)
+		if err != nil && len(batch) > 1 && rolledBack(err) {
+			// a single invalid write fails the whole COPY, so write each event
+			// in its own transaction so that only the invalid writes fail
+			b.logger.Log("msg", "failed to flush batch, retrying writes individually", "size", len(batch), "err", err)
+
+			for _, req := range batch {
+				req.result <- b.flush([]*writeRequest{req})
+			}
+		} else {
+			for _, req := range batch {
+				req.result <- err
+			}
+		}
+
+		batch = []*writeRequest{}
+	}
+
+	for {
+		select {
+		case req := <-b.requests:
+			batch = append(batch, req)
+
+			if len(batch) == 1 {
+				timer = time.NewTimer(b.interval)
+				timerC = timer.C
+			}
+
+			if len(batch) >= b.size {
+				flush()
+			}
+		case <-timerC:
+			flush()
+		case <-b.quit:
+			// accept any writes that were already waiting, then flush
+			for {
+				select {
+				case req := <-b.requests:
+					batch = append(batch, req)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush writes the given batch of events to the database within a single
+// transaction, using COPY for the events themselves and a multi-row upsert to
+// record device liveness, before queueing any webhook deliveries.
+func (b *writeBuffer) flush(batch []*writeRequest) error {
+	start := time.Now()
+	defer func() {
+		flushDuration.Observe(time.Since(start).Seconds())
+		batchSize.Observe(float64(len(batch)))
+	}()
+
+	tx, err := b.db.Begin()
+	if err != nil {
+		reporting.Report(context.Background(), err, map[string]string{"operation": "flushWrites"})
+		return errors.Wrap(err, "failed to begin transaction")
+	}
+
+	stmt, err := tx.Prepare(pq.CopyIn("events", "community_id", "data", "device_token"))
+	if err != nil {
+		tx.Rollback()
+		reporting.Report(context.Background(), err, map[string]string{"operation": "flushWrites"})
+		return errors.Wrap(err, "failed to prepare copy statement")
+	}
+
+	// devices are keyed by community and token, as a device must only appear
+	// once within an upsert statement
+	devices := map[[2]string]bool{}
+	builder := sq.Insert("devices").
+		Columns("community_id", "device_token", "last_seen_at").
+		Suffix("ON CONFLICT (community_id, device_token) DO UPDATE SET last_seen_at = EXCLUDED.last_seen_at").
+		PlaceholderFormat(sq.Dollar)
+
+	for _, req := range batch {
+		_, err = stmt.Exec(req.communityID, req.data, req.deviceToken)
+		if err != nil {
+			stmt.Close()
+			tx.Rollback()
+			reporting.Report(context.Background(), err, map[string]string{"operation": "flushWrites"})
+			return errors.Wrap(err, "failed to copy event")
+		}
+
+		key := [2]string{req.communityID, req.deviceToken}
+		if !devices[key] {
+			devices[key] = true
+			builder = builder.Values(req.communityID, req.deviceToken, sq.Expr("NOW()"))
+		}
+	}
+
+	_, err = stmt.Exec()
+	if err != nil {
+		stmt.Close()
+		tx.Rollback()
+		reporting.Report(context.Background(), err, map[string]string{"operation": "flushWrites"})
+		return errors.Wrap(err, "failed to flush copy statement")
+	}
+
+	err = stmt.Close()
+	if err != nil {
+		tx.Rollback()
+		reporting.Report(context.Background(), err, map[string]string{"operation": "flushWrites"})
+		return errors.Wrap(err, "failed to close copy statement")
+	}
+
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		tx.Rollback()
+		reporting.Report(context.Background(), err, map[string]string{"operation": "flushWrites"})
+		return errors.Wrap(err, "failed to build device query")
+	}
+
+	_, err = tx.Exec(sql, args...)
+	if err != nil {
+		tx.Rollback()
+		reporting.Report(context.Background(), err, map[string]string{"operation": "flushWrites"})
+		return errors.Wrap(err, "failed to record device liveness")
+	}
+
+	err = enqueueDeliveries(context.Background(), tx, batch)
+	if err != nil {
+		tx.Rollback()
+		reporting.Report(context.Background(), err, map[string]string{"operation": "flushWrites"})
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		reporting.Report(context.Background(), err, map[string]string{"operation": "flushWrites"})
+		return errors.Wrap(err, "failed to commit buffered writes")
+	}
+
+	return nil
+}
+
+// rolledBack returns true if the given error from flush was returned by the
+// server, so we know the transaction was rolled back. Other errors, including
+// connection exceptions (class 08), may have been returned after the commit was
+// sent, so the batch may already have been written and must not be retried.
+func rolledBack(err error) bool {
+	pqErr, ok := errors.Cause(err).(*pq.Error)
+	if !ok {
+		return false
+	}
+
+	return pqErr.Code.Class() != "08"
+}
@@ -0,0 +1,473 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	sq "github.com/elgris/sqrl"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	registry "github.com/thingful/retryable-registry-prometheus"
+
+	"github.com/DECODEproject/iotstore/pkg/archive"
+	"github.com/DECODEproject/iotstore/pkg/redact"
+	"github.com/DECODEproject/iotstore/pkg/reporting"
+)
+
+var (
+	// archivedEvents is a counter recording the number of events moved from the
+	// events table into cold storage.
+	archivedEvents = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "archived_events_total",
+			Help:      "Number of events moved into cold storage",
+		},
+	)
+
+	// segmentReads is a counter recording the number of cold storage segments
+	// read in order to answer queries.
+	segmentReads = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "segment_reads_total",
+			Help:      "Number of cold storage segments read to answer queries",
+		},
+	)
+)
+
+func init() {
+	registry.MustRegister(archivedEvents, segmentReads)
+}
+
+// Segment is a type used to read the catalog of segments that have been
+// written to cold storage.
+type Segment struct {
+	Key           string    `db:"key"`
+	CommunityID   string    `db:"community_id"`
+	Day           time.Time `db:"day"`
+	MinRecordedAt time.Time `db:"min_recorded_at"`
+	MaxRecordedAt time.Time `db:"max_recorded_at"`
+	EventCount    int       `db:"event_count"`
+	SHA256        string    `db:"sha256"`
+}
+
+// ArchiveData moves every event recorded on a day (in UTC) that ended before
+// the given time out of the events table and into cold storage. Events are
+// written to one immutable, compressed segment per community and day, which
+// is recorded in the archived_segments table in the same transaction in which
+// the events are deleted, so an event is always readable from exactly one
+// place. If a segment cannot be archived the failure is logged and we move on
+// to the next, so one community cannot hold up archival for every other. We
+// return the number of events archived, and an error if any segment failed.
+func (d *DB) ArchiveData(ctx context.Context, before time.Time) (int, error) {
+	if d.coldStore == nil {
+		return 0, errors.New("cold storage is not configured")
+	}
+
+	before = before.UTC().Truncate(24 * time.Hour)
+
+	sql := `SELECT community_id, date_trunc('day', recorded_at AT TIME ZONE 'UTC') AS day
+		FROM events
+		WHERE recorded_at < $1
+		GROUP BY 1, 2
+		ORDER BY 2, 1`
+
+	var days []struct {
+		CommunityID string    `db:"community_id"`
+		Day         time.Time `db:"day"`
+	}
+
+	err := d.DB.SelectContext(ctx, &days, sql, before)
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "archiveData"})
+		return 0, errors.Wrap(err, "failed to list days to archive")
+	}
+
+	var total, failed int
+
+	for _, day := range days {
+		count, err := d.archiveDay(ctx, day.CommunityID, day.Day)
+		if err != nil {
+			d.logger.Log("msg", "failed to archive events", "communityID", redact.CommunityID(day.CommunityID), "day", day.Day.Format("2006-01-02"), "err", err)
+			failed++
+			continue
+		}
+
+		if d.isVerbose() {
+			d.logger.Log("msg", "archived events", "communityID", redact.CommunityID(day.CommunityID), "day", day.Day.Format("2006-01-02"), "count", count)
+		}
+
+		total = total + count
+	}
+
+	d.logger.Log("msg", "archived old events", "count", total, "before", before.Format(time.RFC3339))
+
+	if failed > 0 {
+		return total, errors.Errorf("failed to archive %d of %d segments", failed, len(days))
+	}
+
+	return total, nil
+}
+
+// archiveDay writes a single community's events for a single day to a new
+// cold storage segment, and then deletes them from the events table.
+func (d *DB) archiveDay(ctx context.Context, communityID string, day time.Time) (int, error) {
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+
+	sql := `SELECT id, community_id, recorded_at, data, device_token
+		FROM events
+		WHERE community_id = $1 AND recorded_at >= $2 AND recorded_at < $3
+		ORDER BY recorded_at ASC, id ASC`
+
+	events := []*Event{}
+
+	err := d.DB.SelectContext(ctx, &events, sql, communityID, day, day.AddDate(0, 0, 1))
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "archiveData"})
+		return 0, errors.Wrap(err, "failed to read events to archive")
+	}
+
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	records := make([]*archive.Record, len(events))
+	ids := make([]int64, len(events))
+
+	for i, e := range events {
+		records[i] = eventToRecord(e)
+		ids[i] = e.ID
+	}
+
+	key := archive.SegmentKey(communityID, day, events[0].ID, events[len(events)-1].ID)
+
+	sum, err := d.putSegment(ctx, key, records)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := d.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		d.removeSegment(key)
+		reporting.Report(ctx, err, map[string]string{"operation": "archiveData"})
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	err = insertSegment(ctx, tx, key, day, records, sum)
+	if err != nil {
+		tx.Rollback()
+		d.removeSegment(key)
+		reporting.Report(ctx, err, map[string]string{"operation": "archiveData"})
+		return 0, errors.Wrap(err, "failed to record segment")
+	}
+
+	_, err = tx.ExecContext(
+		ctx,
+		`DELETE FROM events WHERE community_id = $1 AND recorded_at >= $2 AND recorded_at < $3 AND id = ANY($4)`,
+		communityID, day, day.AddDate(0, 0, 1), pq.Array(ids),
+	)
+	if err != nil {
+		tx.Rollback()
+		d.removeSegment(key)
+		reporting.Report(ctx, err, map[string]string{"operation": "archiveData"})
+		return 0, errors.Wrap(err, "failed to delete archived events")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		d.removeSegment(key)
+		reporting.Report(ctx, err, map[string]string{"operation": "archiveData"})
+		return 0, errors.Wrap(err, "failed to commit archived events")
+	}
+
+	archivedEvents.Add(float64(len(events)))
+
+	return len(events), nil
+}
+
+// readArchived returns up to pageSize archived events for the given community
+// that match the given window and cursor, in the same order as ReadData. It
+// only reads as many segments as are needed to be sure that it has found the
+// earliest matching events. The catalog is read using the given reader, which
+// ReadData passes so that the catalog is read from the same snapshot as the
+// events table.
+func (d *DB) readArchived(ctx context.Context, reader sqlx.QueryerContext, communityID string, pageSize uint64, startTime, endTime time.Time, cursor *Cursor) ([]*Event, error) {
+	builder := sq.Select("key", "community_id", "day", "min_recorded_at", "max_recorded_at", "event_count", "sha256").
+		From("archived_segments").
+		Where(sq.Eq{"community_id": communityID}).
+		Where(sq.GtOrEq{"max_recorded_at": startTime}).
+		OrderBy("min_recorded_at ASC", "key ASC")
+
+	if !endTime.IsZero() {
+		builder = builder.Where(sq.Lt{"min_recorded_at": endTime})
+	}
+
+	if cursor != nil {
+		builder = builder.Where(sq.GtOrEq{"max_recorded_at": cursor.Timestamp})
+	}
+
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build segment query")
+	}
+
+	segments := []*Segment{}
+
+	err = sqlx.SelectContext(ctx, reader, &segments, d.DB.Rebind(sql), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list archived segments")
+	}
+
+	events := []*Event{}
+
+	for _, s := range segments {
+		// segments are ordered by their earliest event, so once we have a full
+		// page of events before this segment starts we can stop
+		if len(events) >= int(pageSize) && s.MinRecordedAt.After(events[pageSize-1].RecordedAt) {
+			break
+		}
+
+		records, err := d.getSegment(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range records {
+			if r.RecordedAt.Before(startTime) {
+				continue
+			}
+
+			if !endTime.IsZero() && !r.RecordedAt.Before(endTime) {
+				continue
+			}
+
+			if cursor != nil && !eventAfter(r.RecordedAt, r.ID, cursor.Timestamp, cursor.EventID) {
+				continue
+			}
+
+			events = append(events, recordToEvent(r))
+		}
+
+		sortEvents(events)
+
+		if len(events) > int(pageSize) {
+			events = events[:pageSize]
+		}
+	}
+
+	return events, nil
+}
+
+// deleteArchived deletes archived events recorded before the given time. Whole
+// segments before the time are removed, and any segment straddling it is
+// replaced by a new segment without the deleted events. If execute is false we
+// just count the events that would be deleted.
+func (d *DB) deleteArchived(ctx context.Context, before time.Time, execute bool) (int, error) {
+	segments := []*Segment{}
+
+	err := d.DB.SelectContext(
+		ctx,
+		&segments,
+		`SELECT key, community_id, day, min_recorded_at, max_recorded_at, event_count, sha256
+		FROM archived_segments
+		WHERE min_recorded_at < $1
+		ORDER BY min_recorded_at ASC, key ASC`,
+		before,
+	)
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "deleteData"})
+		return 0, errors.Wrap(err, "failed to list archived segments")
+	}
+
+	var total int
+
+	for _, s := range segments {
+		var remaining []*archive.Record
+
+		if !s.MaxRecordedAt.Before(before) {
+			records, err := d.getSegment(ctx, s)
+			if err != nil {
+				return total, err
+			}
+
+			for _, r := range records {
+				if !r.RecordedAt.Before(before) {
+					remaining = append(remaining, r)
+				}
+			}
+		}
+
+		deleted := s.EventCount - len(remaining)
+
+		if !execute {
+			total = total + deleted
+			continue
+		}
+
+		var key, sum string
+		if len(remaining) > 0 {
+			key = archive.SegmentKey(s.CommunityID, s.Day, remaining[0].ID, remaining[len(remaining)-1].ID)
+
+			sum, err = d.putSegment(ctx, key, remaining)
+			if err != nil {
+				return total, err
+			}
+		}
+
+		tx, err := d.DB.BeginTxx(ctx, nil)
+		if err != nil {
+			reporting.Report(ctx, err, map[string]string{"operation": "deleteData"})
+			return total, errors.Wrap(err, "failed to begin transaction")
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM archived_segments WHERE key = $1`, s.Key)
+		if err == nil && key != "" {
+			err = insertSegment(ctx, tx, key, s.Day, remaining, sum)
+		}
+		if err == nil {
+			err = tx.Commit()
+		} else {
+			tx.Rollback()
+		}
+		if err != nil {
+			if key != "" {
+				d.removeSegment(key)
+			}
+			reporting.Report(ctx, err, map[string]string{"operation": "deleteData"})
+			return total, errors.Wrap(err, "failed to update segment catalog")
+		}
+
+		d.removeSegment(s.Key)
+
+		total = total + deleted
+	}
+
+	return total, nil
+}
+
+// putSegment encodes the given records and writes them to cold storage,
+// returning the hex encoded SHA-256 of the segment.
+func (d *DB) putSegment(ctx context.Context, key string, records []*archive.Record) (string, error) {
+	b, err := archive.EncodeSegment(records)
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "putSegment"})
+		return "", errors.Wrap(err, "failed to encode segment")
+	}
+
+	err = d.coldStore.Put(ctx, key, b)
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "putSegment"})
+		return "", errors.Wrap(err, "failed to write segment")
+	}
+
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// getSegment reads and decodes a segment from cold storage, returning an error
+// if its SHA-256 does not match the one recorded in the catalog, so a corrupted
+// or replaced segment is never merged into our results.
+func (d *DB) getSegment(ctx context.Context, s *Segment) ([]*archive.Record, error) {
+	if d.coldStore == nil {
+		return nil, errors.New("cold storage is not configured")
+	}
+
+	segmentReads.Inc()
+
+	b, err := d.coldStore.Get(ctx, s.Key)
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "getSegment"})
+		return nil, errors.Wrapf(err, "failed to read segment %s", s.Key)
+	}
+
+	sum := sha256.Sum256(b)
+	if hex.EncodeToString(sum[:]) != s.SHA256 {
+		err = errors.Errorf("segment %s does not match its recorded SHA-256", s.Key)
+		reporting.Report(ctx, err, map[string]string{"operation": "getSegment"})
+		return nil, err
+	}
+
+	records, err := archive.DecodeSegment(b)
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "getSegment"})
+		return nil, errors.Wrapf(err, "failed to decode segment %s", s.Key)
+	}
+
+	return records, nil
+}
+
+// removeSegment deletes a segment from cold storage that is no longer, or was
+// never, referenced from the catalog. Failures are only logged as an orphaned
+// segment is never read.
+func (d *DB) removeSegment(key string) {
+	err := d.coldStore.Delete(context.Background(), key)
+	if err != nil {
+		d.logger.Log("msg", "failed to remove segment", "key", redact.SegmentKey(key), "err", err)
+	}
+}
+
+// insertSegment records a segment containing the given records, which must be
+// ordered by time, in the archived_segments catalog.
+func insertSegment(ctx context.Context, tx *sqlx.Tx, key string, day time.Time, records []*archive.Record, sum string) error {
+	_, err := tx.ExecContext(
+		ctx,
+		`INSERT INTO archived_segments (key, community_id, day, min_recorded_at, max_recorded_at, event_count, sha256)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		key,
+		records[0].CommunityID,
+		day,
+		records[0].RecordedAt,
+		records[len(records)-1].RecordedAt,
+		len(records),
+		sum,
+	)
+
+	return err
+}
+
+// eventToRecord converts an Event to the type stored in cold storage.
+func eventToRecord(e *Event) *archive.Record {
+	return &archive.Record{
+		ID:          e.ID,
+		CommunityID: e.CommunityID,
+		DeviceToken: e.DeviceToken,
+		RecordedAt:  e.RecordedAt,
+		Data:        e.Data,
+	}
+}
+
+// recordToEvent converts a record read from cold storage to an Event.
+func recordToEvent(r *archive.Record) *Event {
+	return &Event{
+		ID:          r.ID,
+		CommunityID: r.CommunityID,
+		DeviceToken: r.DeviceToken,
+		RecordedAt:  r.RecordedAt,
+		Data:        r.Data,
+	}
+}
+
+// eventAfter returns true if an event with the given timestamp and id sorts
+// after the other, i.e. it is equivalent to (recorded_at, id) > (ts, id).
+func eventAfter(recordedAt time.Time, id int64, ts time.Time, otherID int64) bool {
+	if recordedAt.Equal(ts) {
+		return id > otherID
+	}
+	return recordedAt.After(ts)
+}
+
+// sortEvents sorts events in place by recorded_at and id.
+func sortEvents(events []*Event) {
+	sort.Slice(events, func(i, j int) bool {
+		return eventAfter(events[j].RecordedAt, events[j].ID, events[i].RecordedAt, events[i].ID)
+	})
+}
@@ -0,0 +1,162 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	sq "github.com/elgris/sqrl"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotstore/pkg/reporting"
+)
+
+const (
+	// exportPageSize is the number of events we fetch per query when exporting.
+	exportPageSize = 1000
+)
+
+// ExportData streams every event for the given community recorded within the
+// given time window to the passed function, in order of recorded_at and id.
+// Events are fetched in pages using keyset pagination, so the export does not
+// hold a transaction open or slow down as it progresses through the table. As
+// with ReadData, events that have been moved to cold storage are included, and
+// each page reads the events table and the segment catalog from a single
+// snapshot so that no event is missed or repeated while archival runs. If the
+// community has archived events but cold storage is not configured we return
+// an error rather than an incomplete export. A zero endTime means there is no
+// upper bound. If the function returns an error the export is aborted and that
+// error is returned.
+func (d *DB) ExportData(ctx context.Context, communityId string, startTime, endTime time.Time, fn func(*Event) error) error {
+	if d.coldStore == nil {
+		archived, err := d.hasArchived(ctx, d.reader(), communityId, startTime, endTime)
+		if err != nil {
+			reporting.Report(ctx, err, map[string]string{"operation": "exportData"})
+			return err
+		}
+
+		if archived {
+			return errors.New("events have been archived to cold storage, which must be configured to export them")
+		}
+	}
+
+	var cursor *Cursor
+
+	for {
+		events, err := d.exportPage(ctx, communityId, startTime, endTime, cursor)
+		if err != nil {
+			reporting.Report(ctx, err, map[string]string{"operation": "exportData"})
+			return err
+		}
+
+		for _, e := range events {
+			err = fn(e)
+			if err != nil {
+				return err
+			}
+		}
+
+		if len(events) < exportPageSize {
+			return nil
+		}
+
+		last := events[len(events)-1]
+
+		cursor = &Cursor{
+			EventID:   last.ID,
+			Timestamp: last.RecordedAt,
+		}
+	}
+}
+
+// exportPage returns the next page of up to exportPageSize events after the
+// given cursor, merging in any archived events.
+func (d *DB) exportPage(ctx context.Context, communityId string, startTime, endTime time.Time, cursor *Cursor) ([]*Event, error) {
+	builder := sq.Select("id", "community_id", "recorded_at", "data", "device_token").
+		From("events").
+		OrderBy("recorded_at ASC", "id ASC").
+		Where(sq.Eq{"community_id": communityId}).
+		Where(sq.GtOrEq{"recorded_at": startTime}).
+		Limit(exportPageSize)
+
+	if !endTime.IsZero() {
+		builder = builder.Where(sq.Lt{"recorded_at": endTime})
+	}
+
+	if cursor != nil {
+		builder = builder.Where("(recorded_at, id) > (?, ?)", cursor.Timestamp, cursor.EventID)
+	}
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build sql query")
+	}
+
+	query = d.DB.Rebind(query)
+
+	var reader sqlx.QueryerContext = d.reader()
+
+	if d.coldStore != nil {
+		tx, err := d.reader().BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to begin transaction")
+		}
+		defer tx.Rollback()
+
+		reader = tx
+	}
+
+	events := []*Event{}
+
+	err = sqlx.SelectContext(ctx, reader, &events, query, args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to execute query")
+	}
+
+	if d.coldStore != nil {
+		archived, err := d.readArchived(ctx, reader, communityId, exportPageSize, startTime, endTime, cursor)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read archived events")
+		}
+
+		if len(archived) > 0 {
+			events = append(events, archived...)
+			sortEvents(events)
+
+			if len(events) > exportPageSize {
+				events = events[:exportPageSize]
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// hasArchived returns true if any segments in the catalog may hold events for
+// the given community within the given time window.
+func (d *DB) hasArchived(ctx context.Context, reader sqlx.QueryerContext, communityId string, startTime, endTime time.Time) (bool, error) {
+	builder := sq.Select("1").
+		From("archived_segments").
+		Where(sq.Eq{"community_id": communityId}).
+		Where(sq.GtOrEq{"max_recorded_at": startTime}).
+		Limit(1)
+
+	if !endTime.IsZero() {
+		builder = builder.Where(sq.Lt{"min_recorded_at": endTime})
+	}
+
+	query, args, err := builder.ToSql()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to build segment query")
+	}
+
+	var found []int
+
+	err = sqlx.SelectContext(ctx, reader, &found, d.DB.Rebind(query), args...)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to list archived segments")
+	}
+
+	return len(found) > 0, nil
+}
@@ -0,0 +1,269 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	sq "github.com/elgris/sqrl"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotstore/pkg/reporting"
+)
+
+const (
+	// DedupeByHash is the import mode in which an event is skipped if an event
+	// with the same community, device token, timestamp and data already exists.
+	// New IDs are allocated to imported events, so this is the mode to use when
+	// moving a community between datastores.
+	DedupeByHash = "hash"
+
+	// DedupeByID is the import mode in which events keep their original IDs, and
+	// an event is skipped if an event with the same ID already exists. This is
+	// the mode to use when restoring a backup into the datastore it came from.
+	DedupeByID = "id"
+)
+
+const (
+	// importByHashSQL inserts an event unless an identical event already exists.
+	importByHashSQL = `INSERT INTO events (community_id, recorded_at, data, device_token)
+	SELECT $1::TEXT, $2::TIMESTAMPTZ, $3::BYTEA, $4::TEXT
+	WHERE NOT EXISTS (
+		SELECT 1 FROM events
+		WHERE community_id = $1 AND recorded_at = $2 AND data = $3 AND device_token = $4
+	)`
+
+	// importByIDSQL inserts an event with its original ID unless an event with
+	// that ID already exists.
+	importByIDSQL = `INSERT INTO events (id, community_id, recorded_at, data, device_token)
+	SELECT $5::BIGINT, $1::TEXT, $2::TIMESTAMPTZ, $3::BYTEA, $4::TEXT
+	WHERE NOT EXISTS (
+		SELECT 1 FROM events WHERE id = $5
+	)`
+)
+
+// ImportData inserts the given events into the database within a single
+// transaction, preserving their original recorded_at values and skipping any
+// duplicates according to the dedupe mode (either DedupeByHash or DedupeByID).
+// Devices are recorded as having been seen at the time of their most recent
+// imported event unless they have been seen more recently. Imported events are
+// not delivered to webhooks. Events which have been moved to cold storage are
+// also considered when looking for duplicates, so if any archived segments
+// overlap the imported events cold storage must be configured. We return the
+// number of events actually inserted.
+func (d *DB) ImportData(ctx context.Context, events []*Event, dedupe string) (int, error) {
+	var query string
+
+	switch dedupe {
+	case DedupeByHash:
+		query = importByHashSQL
+	case DedupeByID:
+		query = importByIDSQL
+	default:
+		return 0, fmt.Errorf("unsupported dedupe mode: %s", dedupe)
+	}
+
+	// make sure imported events land in their monthly partitions rather than the
+	// default partition
+	months := map[int64]bool{}
+	for _, e := range events {
+		month := monthStart(e.RecordedAt)
+		if !months[month.Unix()] {
+			months[month.Unix()] = true
+
+			err := d.CreatePartitions(month, 0)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	archived, err := d.archivedKeys(ctx, events, dedupe)
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "importData"})
+		return 0, err
+	}
+
+	tx, err := d.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "importData"})
+		return 0, errors.Wrap(err, "failed to begin transaction")
+	}
+
+	stmt, err := tx.PrepareContext(ctx, query)
+	if err != nil {
+		tx.Rollback()
+		reporting.Report(ctx, err, map[string]string{"operation": "importData"})
+		return 0, errors.Wrap(err, "failed to prepare import statement")
+	}
+	defer stmt.Close()
+
+	var (
+		inserted int
+		maxID    int64
+	)
+
+	// the most recent event per device, keyed by community and token
+	lastSeen := map[[2]string]*Event{}
+
+	for _, e := range events {
+		if !archived[dedupeKey(e, dedupe)] {
+			args := []interface{}{e.CommunityID, e.RecordedAt.UTC(), e.Data, e.DeviceToken}
+			if dedupe == DedupeByID {
+				args = append(args, e.ID)
+			}
+
+			result, err := stmt.ExecContext(ctx, args...)
+			if err != nil {
+				tx.Rollback()
+				reporting.Report(ctx, err, map[string]string{"operation": "importData"})
+				return 0, errors.Wrap(err, "failed to import event")
+			}
+
+			n, err := result.RowsAffected()
+			if err != nil {
+				tx.Rollback()
+				reporting.Report(ctx, err, map[string]string{"operation": "importData"})
+				return 0, errors.Wrap(err, "failed to read rows affected")
+			}
+			inserted += int(n)
+		}
+
+		if e.ID > maxID {
+			maxID = e.ID
+		}
+
+		key := [2]string{e.CommunityID, e.DeviceToken}
+		if last, ok := lastSeen[key]; !ok || e.RecordedAt.After(last.RecordedAt) {
+			lastSeen[key] = e
+		}
+	}
+
+	if dedupe == DedupeByID && maxID > 0 {
+		// move the sequence past the imported IDs so new writes don't collide
+		_, err = tx.ExecContext(ctx, `SELECT setval('events_id_seq', GREATEST($1, (SELECT last_value FROM events_id_seq)))`, maxID)
+		if err != nil {
+			tx.Rollback()
+			reporting.Report(ctx, err, map[string]string{"operation": "importData"})
+			return 0, errors.Wrap(err, "failed to advance events sequence")
+		}
+	}
+
+	if len(lastSeen) > 0 {
+		builder := sq.Insert("devices").
+			Columns("community_id", "device_token", "last_seen_at").
+			Suffix("ON CONFLICT (community_id, device_token) DO UPDATE SET last_seen_at = GREATEST(devices.last_seen_at, EXCLUDED.last_seen_at)").
+			PlaceholderFormat(sq.Dollar)
+
+		for _, e := range lastSeen {
+			builder = builder.Values(e.CommunityID, e.DeviceToken, e.RecordedAt.UTC())
+		}
+
+		sql, args, err := builder.ToSql()
+		if err != nil {
+			tx.Rollback()
+			reporting.Report(ctx, err, map[string]string{"operation": "importData"})
+			return 0, errors.Wrap(err, "failed to build device query")
+		}
+
+		_, err = tx.ExecContext(ctx, sql, args...)
+		if err != nil {
+			tx.Rollback()
+			reporting.Report(ctx, err, map[string]string{"operation": "importData"})
+			return 0, errors.Wrap(err, "failed to record device liveness")
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "importData"})
+		return 0, errors.Wrap(err, "failed to commit imported events")
+	}
+
+	return inserted, nil
+}
+
+// eventKey identifies an event when looking for duplicates, by its ID when
+// deduping by ID or otherwise by its contents.
+type eventKey struct {
+	id          int64
+	communityID string
+	recordedAt  int64
+	data        string
+	deviceToken string
+}
+
+// dedupeKey returns the key identifying the given event in the given dedupe
+// mode. Timestamps are rounded to the microsecond precision of Postgres.
+func dedupeKey(e *Event, dedupe string) eventKey {
+	if dedupe == DedupeByID {
+		return eventKey{id: e.ID}
+	}
+
+	return eventKey{
+		communityID: e.CommunityID,
+		recordedAt:  e.RecordedAt.Round(time.Microsecond).UnixNano(),
+		data:        string(e.Data),
+		deviceToken: e.DeviceToken,
+	}
+}
+
+// archivedKeys returns the keys of any archived events recorded within the
+// time span of the given events, for each community they belong to. If cold
+// storage is not configured we return an error if any archived segments
+// overlap the events, as we cannot tell whether they are duplicates.
+func (d *DB) archivedKeys(ctx context.Context, events []*Event, dedupe string) (map[eventKey]bool, error) {
+	type span struct {
+		start, end time.Time
+	}
+
+	spans := map[string]*span{}
+
+	for _, e := range events {
+		s, ok := spans[e.CommunityID]
+		if !ok {
+			spans[e.CommunityID] = &span{start: e.RecordedAt, end: e.RecordedAt}
+			continue
+		}
+
+		if e.RecordedAt.Before(s.start) {
+			s.start = e.RecordedAt
+		}
+
+		if e.RecordedAt.After(s.end) {
+			s.end = e.RecordedAt
+		}
+	}
+
+	keys := map[eventKey]bool{}
+
+	for communityID, s := range spans {
+		// windows are exclusive of their end
+		end := s.end.Add(time.Microsecond)
+
+		if d.coldStore == nil {
+			found, err := d.hasArchived(ctx, d.DB, communityID, s.start, end)
+			if err != nil {
+				return nil, err
+			}
+
+			if found {
+				return nil, errors.New("imported events overlap events archived to cold storage, which must be configured to check them for duplicates")
+			}
+
+			continue
+		}
+
+		archived, err := d.readArchived(ctx, d.DB, communityID, math.MaxInt32, s.start, end, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read archived events")
+		}
+
+		for _, e := range archived {
+			keys[dedupeKey(e, dedupe)] = true
+		}
+	}
+
+	return keys, nil
+}
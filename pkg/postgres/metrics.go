@@ -0,0 +1,224 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	registry "github.com/thingful/retryable-registry-prometheus"
+
+	"github.com/DECODEproject/iotstore/pkg/redact"
+	"github.com/DECODEproject/iotstore/pkg/reporting"
+)
+
+const (
+	// DefaultMaxCommunityLabels is the default number of distinct communities
+	// for which we record per community metrics.
+	DefaultMaxCommunityLabels = 100
+
+	// otherCommunities is the label under which we record the metrics of any
+	// communities beyond the maximum, which can't clash with a real community
+	// as IDs don't start with an underscore.
+	otherCommunities = "_other"
+)
+
+var (
+	// eventsWritten is a counter recording the number of events written to
+	// each community.
+	eventsWritten = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "events_written_total",
+			Help:      "Number of events written by community",
+		}, []string{"community"},
+	)
+
+	// bytesWritten is a counter recording the size of the encrypted payloads
+	// written to each community.
+	bytesWritten = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "event_bytes_written_total",
+			Help:      "Size in bytes of the event payloads written by community",
+		}, []string{"community"},
+	)
+
+	// pageSize is a histogram recording the number of events in each page read.
+	pageSize = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "read_page_size",
+			Help:      "Number of events returned in each page read",
+			Buckets:   []float64{0, 1, 10, 50, 100, 250, 500, 1000},
+		},
+	)
+
+	// pagesRead is a counter recording the pages read, labelled by whether
+	// they are the first page of a read or a subsequent page requested with a
+	// cursor.
+	pagesRead = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "read_pages_total",
+			Help:      "Number of pages read by whether a page cursor was given",
+		}, []string{"cursor"},
+	)
+
+	// eventsDeleted is a counter recording the events deleted for being older
+	// than the retention period, from either the events table or cold storage.
+	eventsDeleted = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "events_deleted_total",
+			Help:      "Number of old events deleted by where they were stored",
+		}, []string{"store"},
+	)
+
+	// migrationVersion is a gauge exposing the current version of the schema.
+	migrationVersion = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "migration_version",
+			Help:      "Version of the most recent migration applied to the database",
+		},
+	)
+
+	// migrationDirty is a gauge set to 1 if a migration failed part way
+	// through, leaving the database in need of manual repair.
+	migrationDirty = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "migration_dirty",
+			Help:      "Set to 1 if the most recent migration failed part way through",
+		},
+	)
+
+	// certificateCache is a counter recording the autocert cache lookups by
+	// whether the certificate was found.
+	certificateCache = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "certificate_cache_requests_total",
+			Help:      "Number of certificate cache lookups by result",
+		}, []string{"result"},
+	)
+)
+
+func init() {
+	registry.MustRegister(eventsWritten)
+	registry.MustRegister(bytesWritten)
+	registry.MustRegister(pageSize)
+	registry.MustRegister(pagesRead)
+	registry.MustRegister(eventsDeleted)
+	registry.MustRegister(migrationVersion)
+	registry.MustRegister(migrationDirty)
+	registry.MustRegister(certificateCache)
+}
+
+// communityLabels limits the number of distinct community labels we record,
+// as each label creates a new time series. The first communities seen are
+// given their own label, with the metrics of any others recorded together.
+type communityLabels struct {
+	max int
+
+	mu     sync.Mutex
+	labels map[string]bool
+}
+
+// newCommunityLabels returns a new communityLabels allowing at most max
+// distinct labels.
+func newCommunityLabels(max int) *communityLabels {
+	if max <= 0 {
+		max = DefaultMaxCommunityLabels
+	}
+
+	return &communityLabels{
+		max:    max,
+		labels: map[string]bool{},
+	}
+}
+
+// label returns the label under which the metrics of the community are
+// recorded. The community ID is redacted according to the redaction policy.
+func (c *communityLabels) label(communityID string) string {
+	label := redact.CommunityID(communityID)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.labels[label] {
+		return label
+	}
+
+	if len(c.labels) >= c.max {
+		return otherCommunities
+	}
+
+	c.labels[label] = true
+
+	return label
+}
+
+// recordWrite records an event successfully written to the community.
+func (d *DB) recordWrite(communityID string, data []byte) {
+	label := d.communities.label(communityID)
+
+	eventsWritten.WithLabelValues(label).Inc()
+	bytesWritten.WithLabelValues(label).Add(float64(len(data)))
+}
+
+// recordRead records a page successfully read.
+func recordRead(page *Page, pageCursor string) {
+	pageSize.Observe(float64(len(page.Events)))
+
+	if pageCursor == "" {
+		pagesRead.WithLabelValues("first").Inc()
+	} else {
+		pagesRead.WithLabelValues("next").Inc()
+	}
+}
+
+// TableSizes returns the total size on disk in bytes of each table in the
+// schema, including indexes and TOAST data. The size of a partitioned table,
+// or of a TimescaleDB hypertable, includes its partitions or chunks.
+func (d *DB) TableSizes(ctx context.Context) (map[string]int64, error) {
+	sql := `SELECT c.relname AS name,
+		pg_total_relation_size(c.oid) + COALESCE((
+			SELECT SUM(pg_total_relation_size(i.inhrelid))
+			FROM pg_inherits i
+			WHERE i.inhparent = c.oid
+		), 0)::bigint AS size
+	FROM pg_class c
+	JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE n.nspname = current_schema()
+		AND c.relkind IN ('r', 'p')
+		AND NOT c.relispartition`
+
+	var rows []struct {
+		Name string `db:"name"`
+		Size int64  `db:"size"`
+	}
+
+	err := d.DB.SelectContext(ctx, &rows, sql)
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "tableSizes"})
+		return nil, errors.Wrap(err, "failed to read table sizes")
+	}
+
+	sizes := map[string]int64{}
+
+	for _, row := range rows {
+		sizes[row.Name] = row.Size
+	}
+
+	return sizes, nil
+}
@@ -1,23 +1,34 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/golang-migrate/migrate"
 	"github.com/golang-migrate/migrate/database/postgres"
 	bindata "github.com/golang-migrate/migrate/source/go-bindata"
+	"github.com/jmoiron/sqlx"
 	"github.com/pkg/errors"
 	"github.com/serenize/snaker"
 
 	"github.com/DECODEproject/iotstore/pkg/migrations"
 )
 
+// partitionEventsVersion is the version of the migration converting the events
+// table into a partitioned table. Its down migration cannot be run once the
+// events table has been converted into a TimescaleDB hypertable, as that
+// conversion happens outside of our migrations.
+const partitionEventsVersion uint = 20261019100000
+
 // MigrateUp attempts to run all up migrations against Postgres. Migrations are
 // loaded from a bindata generated module that is compiled into the binary. It
 // takes as parameters an sql.DB instance, and a logger instance.
@@ -30,11 +41,11 @@ func MigrateUp(db *sql.DB, logger kitlog.Logger) error {
 	}
 
 	err = m.Up()
-	if err != migrate.ErrNoChange {
+	if err != nil && err != migrate.ErrNoChange {
 		return err
 	}
 
-	return nil
+	return recordVersion(m)
 }
 
 // MigrateDown attempts to run down migrations against Postgres. It takes as
@@ -49,6 +60,29 @@ func MigrateDown(db *sql.DB, steps int, logger kitlog.Logger) error {
 		return errors.Wrap(err, "failed to create migrator")
 	}
 
+	current, _, err := m.Version()
+	if err != nil && err != migrate.ErrNilVersion {
+		return errors.Wrap(err, "failed to read migration version")
+	}
+
+	// find the version we would be left at after running the steps
+	var target uint
+
+	all := Migrations()
+	for i := len(all) - 1; i >= 0; i-- {
+		if all[i].Version <= current {
+			if i-steps >= 0 {
+				target = all[i-steps].Version
+			}
+			break
+		}
+	}
+
+	err = checkDown(db, current, target)
+	if err != nil {
+		return err
+	}
+
 	return m.Steps(-steps)
 }
 
@@ -63,9 +97,310 @@ func MigrateDownAll(db *sql.DB, logger kitlog.Logger) error {
 		return errors.Wrap(err, "failed to create migrator")
 	}
 
+	current, _, err := m.Version()
+	if err != nil && err != migrate.ErrNilVersion {
+		return errors.Wrap(err, "failed to read migration version")
+	}
+
+	err = checkDown(db, current, 0)
+	if err != nil {
+		return err
+	}
+
 	return m.Down()
 }
 
+// MigrateUpTo runs the up migrations against Postgres until the schema is at
+// the given version, which must be that of one of the migrations compiled into
+// the binary. An error is returned if the schema is already beyond the version,
+// as MigrateTo should be used to migrate down.
+func MigrateUpTo(db *sql.DB, version uint, logger kitlog.Logger) error {
+	logger.Log("msg", "migrating DB up", "version", version)
+
+	m, err := getMigrator(db, logger)
+	if err != nil {
+		return errors.Wrap(err, "failed to create migrator")
+	}
+
+	current, _, err := m.Version()
+	if err != nil && err != migrate.ErrNilVersion {
+		return errors.Wrap(err, "failed to read migration version")
+	}
+
+	if current > version {
+		return fmt.Errorf("schema is at version %d which is beyond %d, use goto to migrate down", current, version)
+	}
+
+	return migrateTo(m, version)
+}
+
+// MigrateTo runs the up or down migrations against Postgres required to bring
+// the schema to the given version, which must be that of one of the
+// migrations compiled into the binary.
+func MigrateTo(db *sql.DB, version uint, logger kitlog.Logger) error {
+	logger.Log("msg", "migrating DB to version", "version", version)
+
+	m, err := getMigrator(db, logger)
+	if err != nil {
+		return errors.Wrap(err, "failed to create migrator")
+	}
+
+	current, _, err := m.Version()
+	if err != nil && err != migrate.ErrNilVersion {
+		return errors.Wrap(err, "failed to read migration version")
+	}
+
+	err = checkDown(db, current, version)
+	if err != nil {
+		return err
+	}
+
+	return migrateTo(m, version)
+}
+
+// checkDown returns an error if migrating down from the current version to the
+// target would run the down migration of partitionEventsVersion while the
+// events table is a TimescaleDB hypertable. The hypertable must first be
+// converted back into a plain table by hand.
+func checkDown(db *sql.DB, current, target uint) error {
+	if current < partitionEventsVersion || target >= partitionEventsVersion {
+		return nil
+	}
+
+	ht, err := detectHypertable(sqlx.NewDb(db, "postgres"))
+	if err != nil {
+		return err
+	}
+
+	if ht != nil {
+		return fmt.Errorf("events is a timescale hypertable, which migrations before version %d cannot convert back", partitionEventsVersion)
+	}
+
+	return nil
+}
+
+// migrateTo migrates the schema to the given version, returning an error if
+// there is no migration with that version.
+func migrateTo(m *migrate.Migrate, version uint) error {
+	if !hasMigration(version) {
+		return fmt.Errorf("no migration with version %d", version)
+	}
+
+	err := m.Migrate(version)
+	if err != nil && err != migrate.ErrNoChange {
+		return err
+	}
+
+	return recordVersion(m)
+}
+
+// MigrateForce records the schema as being at the given version and no longer
+// dirty, without running any migrations. It is used to recover after a
+// migration failed part way through, once the schema has been repaired by
+// hand. The version must be that of one of the migrations compiled into the
+// binary, or -1 to record that no migrations have been run.
+func MigrateForce(db *sql.DB, version int, logger kitlog.Logger) error {
+	logger.Log("msg", "forcing DB migration version", "version", version)
+
+	if version != -1 && (version < 0 || !hasMigration(uint(version))) {
+		return fmt.Errorf("no migration with version %d", version)
+	}
+
+	m, err := getMigrator(db, logger)
+	if err != nil {
+		return errors.Wrap(err, "failed to create migrator")
+	}
+
+	err = m.Force(version)
+	if err != nil {
+		return err
+	}
+
+	return recordVersion(m)
+}
+
+// Migration describes one of the migrations compiled into the binary.
+type Migration struct {
+	Version uint
+	Name    string
+}
+
+// MigrationState describes the schema of the database compared to the
+// migrations compiled into the binary.
+type MigrationState struct {
+	// Version is the version of the most recent migration applied, or zero if
+	// none have been.
+	Version uint
+
+	// Dirty is true if the most recent migration failed part way through.
+	Dirty bool
+
+	// Latest is the version of the most recent migration compiled into the
+	// binary.
+	Latest uint
+
+	// Pending are the migrations after Version which have not been applied.
+	Pending []*Migration
+}
+
+// MigrationStatus returns the current state of the schema, including any
+// migrations compiled into the binary which have not yet been applied.
+func MigrationStatus(db *sql.DB, logger kitlog.Logger) (*MigrationState, error) {
+	m, err := getMigrator(db, logger)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create migrator")
+	}
+
+	version, dirty, err := m.Version()
+	if err != nil && err != migrate.ErrNilVersion {
+		return nil, errors.Wrap(err, "failed to read migration version")
+	}
+
+	state := &MigrationState{
+		Version: version,
+		Dirty:   dirty,
+		Latest:  LatestMigration(),
+		Pending: []*Migration{},
+	}
+
+	for _, migration := range Migrations() {
+		if migration.Version > version {
+			state.Pending = append(state.Pending, migration)
+		}
+	}
+
+	return state, nil
+}
+
+// checkMigrated returns an error unless the schema has been migrated to at
+// least the version of the most recent migration compiled into the binary.
+// It is used in place of running the up migrations when they are applied
+// separately.
+func checkMigrated(db *sql.DB, logger kitlog.Logger) error {
+	state, err := MigrationStatus(db, logger)
+	if err != nil {
+		return err
+	}
+
+	migrationVersion.Set(float64(state.Version))
+
+	if state.Dirty {
+		migrationDirty.Set(1)
+		return fmt.Errorf("schema is dirty at version %d, repair it then use migrate force", state.Version)
+	}
+
+	migrationDirty.Set(0)
+
+	if state.Version < state.Latest {
+		return fmt.Errorf("schema is at version %d but version %d is required, use migrate up", state.Version, state.Latest)
+	}
+
+	return nil
+}
+
+// recordVersion exposes the current version of the schema, and whether it is
+// dirty, via our prometheus gauges.
+func recordVersion(m *migrate.Migrate) error {
+	version, dirty, err := m.Version()
+	if err != nil {
+		if err == migrate.ErrNilVersion {
+			return nil
+		}
+		return errors.Wrap(err, "failed to read migration version")
+	}
+
+	migrationVersion.Set(float64(version))
+
+	if dirty {
+		migrationDirty.Set(1)
+	} else {
+		migrationDirty.Set(0)
+	}
+
+	return nil
+}
+
+// LatestMigration returns the version of the most recent migration compiled
+// into the binary, which is the version the schema is at once all up
+// migrations have been run.
+func LatestMigration() uint {
+	var latest uint
+
+	for _, migration := range Migrations() {
+		if migration.Version > latest {
+			latest = migration.Version
+		}
+	}
+
+	return latest
+}
+
+// Migrations returns the migrations compiled into the binary in the order in
+// which they are applied, named as their up migration file without the
+// version or extension.
+func Migrations() []*Migration {
+	all := []*Migration{}
+
+	for _, name := range migrations.AssetNames() {
+		if !strings.HasSuffix(name, ".up.sql") {
+			continue
+		}
+
+		i := strings.Index(name, "_")
+		if i == -1 {
+			continue
+		}
+
+		version, err := strconv.ParseUint(name[:i], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		all = append(all, &Migration{
+			Version: uint(version),
+			Name:    strings.TrimSuffix(name[i+1:], ".up.sql"),
+		})
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Version < all[j].Version
+	})
+
+	return all
+}
+
+// hasMigration returns true if a migration with the given version is compiled
+// into the binary.
+func hasMigration(version uint) bool {
+	for _, migration := range Migrations() {
+		if migration.Version == version {
+			return true
+		}
+	}
+
+	return false
+}
+
+// MigrationVersion returns the current version of the schema, and whether the
+// migration to it failed part way through leaving the schema dirty. Zero is
+// returned if no migrations have been run.
+func (d *DB) MigrationVersion(ctx context.Context) (uint, bool, error) {
+	var row struct {
+		Version int64 `db:"version"`
+		Dirty   bool  `db:"dirty"`
+	}
+
+	err := d.DB.GetContext(ctx, &row, `SELECT version, dirty FROM schema_migrations LIMIT 1`)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, false, nil
+		}
+		return 0, false, errors.Wrap(err, "failed to read migration version")
+	}
+
+	return uint(row.Version), row.Dirty, nil
+}
+
 // NewMigration creates a new pair of files into which an SQL migration should
 // be written. All this is doing is ensuring files created are correctly named.
 func NewMigration(dirName, migrationName string, logger kitlog.Logger) error {
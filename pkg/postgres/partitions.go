@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotstore/pkg/reporting"
+)
+
+const (
+	// partitionNameFormat is the format of the names of the monthly partitions
+	// of the events table, e.g. events_y2019m03.
+	partitionNameFormat = "events_y%04dm%02d"
+)
+
+// Partition is a type used to describe one of the monthly partitions into which
+// the events table is divided. Start is inclusive, End is exclusive.
+type Partition struct {
+	Name  string
+	Start time.Time
+	End   time.Time
+}
+
+// CreatePartitions ensures that monthly partitions of the events table exist
+// for the month containing the given time, plus the given number of months
+// after it. Creating partitions ahead of time means that writes never have to
+// fall back to the default partition. Existing partitions are left untouched.
+// When running in TimescaleDB mode this is a no-op as chunks are created
+// automatically.
+func (d *DB) CreatePartitions(from time.Time, ahead int) error {
+	if d.hypertable != nil {
+		// timescale manages its own chunks
+		return nil
+	}
+
+	month := monthStart(from)
+
+	for i := 0; i <= ahead; i++ {
+		var name string
+
+		err := d.DB.Get(&name, `SELECT create_events_partition($1)`, month.AddDate(0, i, 0))
+		if err != nil {
+			reporting.Report(context.Background(), err, map[string]string{"operation": "createPartitions"})
+			return errors.Wrap(err, "failed to create events partition")
+		}
+
+		if d.isVerbose() {
+			d.logger.Log("msg", "ensured events partition", "partition", name)
+		}
+	}
+
+	return nil
+}
+
+// Partitions returns a list of all monthly partitions of the events table
+// ordered by time. The default partition is not included in the returned list.
+func (d *DB) Partitions() ([]*Partition, error) {
+	sql := `SELECT c.relname
+		FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		JOIN pg_class p ON p.oid = i.inhparent
+		WHERE p.relname = 'events'
+		ORDER BY c.relname ASC`
+
+	var names []string
+	err := d.DB.Select(&names, sql)
+	if err != nil {
+		reporting.Report(context.Background(), err, map[string]string{"operation": "partitions"})
+		return nil, errors.Wrap(err, "failed to list events partitions")
+	}
+
+	partitions := []*Partition{}
+
+	for _, name := range names {
+		var year, month int
+
+		_, err := fmt.Sscanf(name, partitionNameFormat, &year, &month)
+		if err != nil {
+			// not a monthly partition, i.e. the default partition
+			continue
+		}
+
+		start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+
+		partitions = append(partitions, &Partition{
+			Name:  name,
+			Start: start,
+			End:   start.AddDate(0, 1, 0),
+		})
+	}
+
+	return partitions, nil
+}
+
+// monthStart returns the first instant of the UTC month containing t.
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
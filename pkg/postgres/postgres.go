@@ -5,29 +5,52 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/acme/autocert"
 
 	sq "github.com/elgris/sqrl"
-	raven "github.com/getsentry/raven-go"
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq" // required way of importing pq
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
+	registry "github.com/thingful/retryable-registry-prometheus"
+
+	"github.com/DECODEproject/iotstore/pkg/archive"
+	"github.com/DECODEproject/iotstore/pkg/redact"
+	"github.com/DECODEproject/iotstore/pkg/reporting"
+	"github.com/DECODEproject/iotstore/pkg/tracing"
 )
 
+// driverName is the name under which we register the pq driver wrapped to
+// trace queries.
+const driverName = "postgres+tracing"
+
+func init() {
+	sql.Register(driverName, tracing.WrapDriver(&pq.Driver{}))
+}
+
 // Open takes as input a connection string for a DB, and returns either a
-// sqlx.DB instance or an error.
+// sqlx.DB instance or an error. Queries made with a context carrying a trace
+// span are recorded as child spans.
 func Open(connStr string) (*sqlx.DB, error) {
-	return sqlx.Open("postgres", connStr)
+	db, err := sql.Open(driverName, connStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return sqlx.NewDb(db, "postgres"), nil
 }
 
 // Event is a type used to read encrypted events back from the database
 type Event struct {
-	ID         int64     `db:"id"`
-	RecordedAt time.Time `db:"recorded_at"`
-	Data       []byte    `db:"data"`
+	ID          int64     `db:"id"`
+	CommunityID string    `db:"community_id"`
+	RecordedAt  time.Time `db:"recorded_at"`
+	Data        []byte    `db:"data"`
+	DeviceToken string    `db:"device_token"`
 }
 
 // Cursor is an internal type used for serializing or parsing page cursors.
@@ -43,6 +66,14 @@ type Page struct {
 	NextPageCursor string
 }
 
+// Device is a type used to read device liveness information back from the
+// database, i.e. when we last received an event from a specific device.
+type Device struct {
+	CommunityID string    `db:"community_id"`
+	DeviceToken string    `db:"device_token"`
+	LastSeenAt  time.Time `db:"last_seen_at"`
+}
+
 // Certificate is an internal type used for persisting and reading TLS
 // certificates from Postgres
 type Certificate struct {
@@ -50,74 +81,300 @@ type Certificate struct {
 	Certificate []byte `db:"certificate"`
 }
 
+// Config is a struct used to pass configuration into our DB instance.
+type Config struct {
+	// ConnStr is the connection string for the database.
+	ConnStr string
+
+	// Verbose causes the component to output more verbose log information.
+	Verbose bool
+
+	// Timescale requests that the events table be converted into a TimescaleDB
+	// hypertable if the extension is available on the server. Like the up
+	// migrations the conversion is not run if NoAutoMigrate is set.
+	Timescale bool
+
+	// CompressAfter is the age after which TimescaleDB chunks are compressed.
+	// Only used when running in TimescaleDB mode, zero disables compression.
+	CompressAfter time.Duration
+
+	// WriteBufferInterval is the maximum time writes are buffered before being
+	// flushed to the database in a single batch. Zero disables buffering, in
+	// which case each write is committed in its own transaction.
+	WriteBufferInterval time.Duration
+
+	// WriteBufferSize is the maximum number of writes collected into a single
+	// batch before the buffer is flushed.
+	WriteBufferSize int
+
+	// MaxOpenConns is the maximum number of open connections to the database,
+	// zero means unlimited.
+	MaxOpenConns int
+
+	// MaxIdleConns is the maximum number of idle connections kept in the pool,
+	// zero means we use the database/sql default.
+	MaxIdleConns int
+
+	// ConnMaxLifetime is the maximum time a connection may be reused, zero
+	// means connections are reused forever.
+	ConnMaxLifetime time.Duration
+
+	// StatementTimeout is the maximum time Postgres allows any statement to
+	// run before cancelling it, zero means no timeout.
+	StatementTimeout time.Duration
+
+	// ReadConnStr is an optional connection string for a streaming replica. If
+	// set, read queries are sent to the replica while writes, deletes and the
+	// certificate cache continue to use the primary.
+	ReadConnStr string
+
+	// ReplicaMaxLag is the replication lag beyond which reads fall back to the
+	// primary, zero means reads are always sent to the replica.
+	ReplicaMaxLag time.Duration
+
+	// ColdStorageURL is an optional location (a local path or an s3:// URL) to
+	// which old events may be archived. If set, ReadData also reads from any
+	// archived segments that overlap the requested window.
+	ColdStorageURL string
+
+	// MaxCommunityLabels is the maximum number of distinct communities for
+	// which we record per community metrics, with any others recorded
+	// together. Zero means DefaultMaxCommunityLabels.
+	MaxCommunityLabels int
+
+	// NoAutoMigrate disables running the up migrations and the TimescaleDB
+	// conversion when we start, for when schema changes are applied
+	// separately. Instead Start returns an error if the schema has not been
+	// migrated to the latest version.
+	NoAutoMigrate bool
+}
+
 // DB is a struct that wraps an sqlx.DB instance that exposes some methods to
 // read and write data.
 type DB struct {
 	DB *sqlx.DB
 
-	connStr string
-	verbose bool
-	logger  kitlog.Logger
+	connStr             string
+	verbose             int32
+	timescale           bool
+	compressAfter       time.Duration
+	writeBufferInterval time.Duration
+	writeBufferSize     int
+	maxOpenConns        int
+	maxIdleConns        int
+	connMaxLifetime     time.Duration
+	statementTimeout    time.Duration
+	readConnStr         string
+	replicaMaxLag       time.Duration
+	coldStorageURL      string
+	noAutoMigrate       bool
+	coldStore           archive.Store
+	replica             *sqlx.DB
+	replicaHealthy      int32
+	replicaQuit         chan struct{}
+	replicaStopped      chan struct{}
+	hypertable          *hypertable
+	buffer              *writeBuffer
+	communities         *communityLabels
+	logger              kitlog.Logger
 }
 
 // NewDB is a constructor that returns a new DB instance for the given
-// configuration. We pass in a connection string for the database, and in
-// addition we can pass in a verbose flag which causes the binary to output more
-// verbose log information.
-func NewDB(connStr string, verbose bool, logger kitlog.Logger) *DB {
+// configuration. We pass in a Config struct containing the connection string
+// for the database along with the other options for the component.
+func NewDB(config *Config, logger kitlog.Logger) *DB {
 	logger = kitlog.With(logger, "module", "postgres")
 
 	db := &DB{
-		connStr: connStr,
-		verbose: verbose,
-		logger:  logger,
-	}
+		connStr:             config.ConnStr,
+		timescale:           config.Timescale,
+		compressAfter:       config.CompressAfter,
+		writeBufferInterval: config.WriteBufferInterval,
+		writeBufferSize:     config.WriteBufferSize,
+		maxOpenConns:        config.MaxOpenConns,
+		maxIdleConns:        config.MaxIdleConns,
+		connMaxLifetime:     config.ConnMaxLifetime,
+		statementTimeout:    config.StatementTimeout,
+		readConnStr:         config.ReadConnStr,
+		replicaMaxLag:       config.ReplicaMaxLag,
+		coldStorageURL:      config.ColdStorageURL,
+		noAutoMigrate:       config.NoAutoMigrate,
+		communities:         newCommunityLabels(config.MaxCommunityLabels),
+		logger:              logger,
+	}
+
+	db.SetVerbose(config.Verbose)
 
 	return db
 }
 
+// SetVerbose switches verbose logging on or off, and may be called while the
+// DB is in use.
+func (d *DB) SetVerbose(verbose bool) {
+	var v int32
+	if verbose {
+		v = 1
+	}
+	atomic.StoreInt32(&d.verbose, v)
+}
+
+// isVerbose returns true if verbose logging is switched on.
+func (d *DB) isVerbose() bool {
+	return atomic.LoadInt32(&d.verbose) == 1
+}
+
 // Start connects to the database, and runs any pending up migrations.
 func (d *DB) Start() error {
 	d.logger.Log("msg", "starting postgres connection")
 
-	db, err := Open(d.connStr)
+	connStr := d.connStr
+
+	if d.statementTimeout > 0 {
+		var err error
+		connStr, err = withStatementTimeout(connStr, d.statementTimeout)
+		if err != nil {
+			return errors.Wrap(err, "failed to set statement timeout")
+		}
+	}
+
+	db, err := Open(connStr)
 	if err != nil {
 		return errors.Wrap(err, "failed to open db connection")
 	}
 
+	db.SetMaxOpenConns(d.maxOpenConns)
+	db.SetConnMaxLifetime(d.connMaxLifetime)
+
+	if d.maxIdleConns > 0 {
+		db.SetMaxIdleConns(d.maxIdleConns)
+	}
+
+	registry.MustRegister(newStatsCollector(db.DB, "primary"))
+
 	d.DB = db
 
-	err = MigrateUp(d.DB.DB, d.logger)
+	if d.noAutoMigrate {
+		err = checkMigrated(d.DB.DB, d.logger)
+		if err != nil {
+			return errors.Wrap(err, "database not migrated")
+		}
+
+		if d.timescale {
+			d.logger.Log("msg", "auto migration disabled, not converting events table into a timescale hypertable")
+		}
+	} else {
+		err = MigrateUp(d.DB.DB, d.logger)
+		if err != nil {
+			return errors.Wrap(err, "failed to run up migrations")
+		}
+
+		if d.timescale {
+			err = MigrateTimescale(d.DB.DB, d.compressAfter, d.logger)
+			if err != nil {
+				return errors.Wrap(err, "failed to run timescale migration")
+			}
+		}
+	}
+
+	d.hypertable, err = detectHypertable(d.DB)
 	if err != nil {
-		return errors.Wrap(err, "failed to run up migrations")
+		return errors.Wrap(err, "failed to detect timescale hypertable")
+	}
+
+	if d.coldStorageURL != "" {
+		d.coldStore, err = archive.NewStore(d.coldStorageURL)
+		if err != nil {
+			return errors.Wrap(err, "failed to open cold storage")
+		}
+	}
+
+	if d.readConnStr != "" {
+		readConnStr := d.readConnStr
+
+		if d.statementTimeout > 0 {
+			readConnStr, err = withStatementTimeout(readConnStr, d.statementTimeout)
+			if err != nil {
+				return errors.Wrap(err, "failed to set replica statement timeout")
+			}
+		}
+
+		err = d.startReplica(readConnStr)
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.writeBufferInterval > 0 {
+		d.buffer = newWriteBuffer(d.DB, d.writeBufferInterval, d.writeBufferSize, d.logger)
+		d.buffer.Start()
 	}
 
 	return nil
 }
 
-// Stop terminates the DB connection, closing the pool of connections.
+// Stop terminates the DB connection, closing the pool of connections. Any
+// buffered writes are flushed before the connection is closed.
 func (d *DB) Stop() error {
 	d.logger.Log("msg", "stopping postgres connection")
 
+	// we may be stopped after failing to start
+	if d.DB == nil {
+		return nil
+	}
+
+	if d.buffer != nil {
+		d.buffer.Stop()
+	}
+
+	if d.replica != nil {
+		err := d.stopReplica()
+		if err != nil {
+			return errors.Wrap(err, "failed to close replica connection")
+		}
+	}
+
 	return d.DB.Close()
 }
 
 // WriteData is the function that is responsible for writing data to the actual
 // database. Takes as input the id of the policy the policy for which we are
 // storing data and a byte slice containing the encrypted data to be persisted.
-// In addition we also pass in the unique device token.
-func (d *DB) WriteData(communityId string, data []byte, deviceToken string) error {
+// In addition we also pass in the unique device token. Within the same
+// transaction we record the time at which we last saw the device so that we
+// are able to detect devices that have gone silent, and queue a delivery of the
+// event to each of the community's webhooks. If the write buffer is
+// enabled the event is instead written as part of a batch, but we still only
+// return once the batch has been committed. The passed in context is used to
+// cancel the write if the caller goes away or the request times out.
+func (d *DB) WriteData(ctx context.Context, communityId string, data []byte, deviceToken string) error {
+	if d.buffer != nil {
+		err := d.buffer.write(ctx, communityId, data, deviceToken)
+		if err != nil {
+			return err
+		}
+
+		d.recordWrite(communityId, data)
+
+		return nil
+	}
+
 	sql := `INSERT INTO events
 		(community_id, data, device_token)
 		VALUES (:community_id, :data, :device_token)`
 
+	deviceSQL := `INSERT INTO devices
+		(community_id, device_token, last_seen_at)
+		VALUES (:community_id, :device_token, NOW())
+	ON CONFLICT (community_id, device_token)
+	DO UPDATE SET last_seen_at = EXCLUDED.last_seen_at`
+
 	mapArgs := map[string]interface{}{
 		"community_id": communityId,
 		"data":         data,
 		"device_token": deviceToken,
 	}
 
-	tx, err := d.DB.Beginx()
+	tx, err := d.DB.BeginTxx(ctx, nil)
 	if err != nil {
 		return errors.Wrap(err, "failed to begin transaction")
 	}
@@ -125,22 +382,58 @@ func (d *DB) WriteData(communityId string, data []byte, deviceToken string) erro
 	sql, args, err := tx.BindNamed(sql, mapArgs)
 	if err != nil {
 		tx.Rollback()
-		raven.CaptureError(err, map[string]string{"operation": "writeData"})
+		reporting.Report(ctx, err, map[string]string{"operation": "writeData"})
 		return errors.Wrap(err, "failed to bind named query")
 	}
 
-	_, err = tx.Exec(sql, args...)
+	_, err = tx.ExecContext(ctx, sql, args...)
 	if err != nil {
 		tx.Rollback()
-		raven.CaptureError(err, map[string]string{"operation": "writeData"})
+		reporting.Report(ctx, err, map[string]string{"operation": "writeData"})
 		return errors.Wrap(err, "failed to execute write query")
 	}
 
-	return tx.Commit()
+	deviceSQL, args, err = tx.BindNamed(deviceSQL, mapArgs)
+	if err != nil {
+		tx.Rollback()
+		reporting.Report(ctx, err, map[string]string{"operation": "writeData"})
+		return errors.Wrap(err, "failed to bind named device query")
+	}
+
+	_, err = tx.ExecContext(ctx, deviceSQL, args...)
+	if err != nil {
+		tx.Rollback()
+		reporting.Report(ctx, err, map[string]string{"operation": "writeData"})
+		return errors.Wrap(err, "failed to record device liveness")
+	}
+
+	err = enqueueDeliveries(ctx, tx.Tx, []*writeRequest{
+		{communityID: communityId, data: data, deviceToken: deviceToken},
+	})
+	if err != nil {
+		tx.Rollback()
+		reporting.Report(ctx, err, map[string]string{"operation": "writeData"})
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	d.recordWrite(communityId, data)
+
+	return nil
 }
 
-// ReadData returns a list of Event types for the given query parameters.
-func (d *DB) ReadData(communityId string, pageSize uint64, startTime, endTime time.Time, pageCursor string) (*Page, error) {
+// ReadData returns a list of Event types for the given query parameters. The
+// passed in context is used to cancel the query if the caller goes away or the
+// request times out. Reads are sent to the read replica if one is configured.
+// If cold storage is configured, events from archived segments overlapping the
+// requested window are merged with those still in the events table.
+func (d *DB) ReadData(ctx context.Context, communityId string, pageSize uint64, startTime, endTime time.Time, pageCursor string) (*Page, error) {
+	var cursor *Cursor
+
 	// use sqrl builder here as it simplifies the creation of the query.
 	builder := sq.Select("id", "recorded_at", "data").
 		From("events").
@@ -154,27 +447,47 @@ func (d *DB) ReadData(communityId string, pageSize uint64, startTime, endTime ti
 	}
 
 	if pageCursor != "" {
-		cursor, err := decodeCursor(pageCursor)
+		var err error
+		cursor, err = decodeCursor(pageCursor)
 		if err != nil {
-			raven.CaptureError(err, map[string]string{"operation": "readData"})
+			reporting.Report(ctx, err, map[string]string{"operation": "readData"})
 			return nil, errors.Wrap(err, "failed to decode page cursor")
 		}
-		builder = builder.Where(sq.GtOrEq{"recorded_at": cursor.Timestamp}).Where(sq.Gt{"id": cursor.EventID})
+		// compare as a row, matching our ordering, as IDs are not necessarily
+		// in the same order as timestamps (e.g. for imported events)
+		builder = builder.Where("(recorded_at, id) > (?, ?)", cursor.Timestamp, cursor.EventID)
 	}
 
-	sql, args, err := builder.ToSql()
+	var reader sqlx.QueryerContext = d.reader()
+
+	// if we have cold storage we read the events table and the segment catalog
+	// from a single snapshot, as archiving an event moves it from one to the
+	// other in a single transaction, so it is seen exactly once
+	if d.coldStore != nil {
+		tx, err := d.reader().BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+		if err != nil {
+			reporting.Report(ctx, err, map[string]string{"operation": "readData"})
+			return nil, errors.Wrap(err, "failed to begin transaction")
+		}
+		defer tx.Rollback()
+
+		reader = tx
+	}
+
+	query, args, err := builder.ToSql()
 	if err != nil {
-		raven.CaptureError(err, map[string]string{"operation": "readData"})
+		reporting.Report(ctx, err, map[string]string{"operation": "readData"})
 		return nil, errors.Wrap(err, "failed to build sql query")
 	}
 
-	sql = d.DB.Rebind(sql)
+	query = d.DB.Rebind(query)
 
-	rows, err := d.DB.Queryx(sql, args...)
+	rows, err := reader.QueryxContext(ctx, query, args...)
 	if err != nil {
-		raven.CaptureError(err, map[string]string{"operation": "readData"})
+		reporting.Report(ctx, err, map[string]string{"operation": "readData"})
 		return nil, errors.Wrap(err, "failed to execute query")
 	}
+	defer rows.Close()
 
 	events := []*Event{}
 
@@ -182,43 +495,135 @@ func (d *DB) ReadData(communityId string, pageSize uint64, startTime, endTime ti
 		var e Event
 		err = rows.StructScan(&e)
 		if err != nil {
-			raven.CaptureError(err, map[string]string{"operation": "readData"})
+			reporting.Report(ctx, err, map[string]string{"operation": "readData"})
 			return nil, errors.Wrap(err, "failed to populate Event type")
 		}
 		events = append(events, &e)
 	}
 
+	err = rows.Err()
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "readData"})
+		return nil, errors.Wrap(err, "failed to read events")
+	}
+
+	if d.coldStore != nil {
+		archived, err := d.readArchived(ctx, reader, communityId, pageSize, startTime, endTime, cursor)
+		if err != nil {
+			reporting.Report(ctx, err, map[string]string{"operation": "readData"})
+			return nil, errors.Wrap(err, "failed to read archived events")
+		}
+
+		if len(archived) > 0 {
+			events = append(events, archived...)
+			sortEvents(events)
+
+			if len(events) > int(pageSize) {
+				events = events[:pageSize]
+			}
+		}
+	}
+
 	var nextCursor string
 
 	if len(events) == int(pageSize) {
 		// we should construct a next page cursor value to return
 		nextCursor, err = encodeCursor(events[len(events)-1])
 		if err != nil {
-			raven.CaptureError(err, map[string]string{"operation": "readData"})
+			reporting.Report(ctx, err, map[string]string{"operation": "readData"})
 			return nil, errors.Wrap(err, "failed to build next page cursor")
 		}
 	}
 
-	return &Page{
+	page := &Page{
 		Events:         events,
 		NextPageCursor: nextCursor,
-	}, nil
+	}
+
+	recordRead(page, pageCursor)
+
+	return page, nil
 }
 
 // DeleteData takes as input a timestamp, and after it is executed will have
 // deleted all events in the database from before the submitted time interval
-// for any policy. This function also takes a `execute` parameter. If set to
-// true the delete operation is performed and committed, but if set to false it
-// is executed without committing the transaction. This allows a caller to see
-// how many events would be deleted.
+// for any policy. Monthly partitions that lie entirely before the timestamp are
+// detached and dropped as a whole, which avoids the vacuum load of deleting
+// rows individually; only events in the partition straddling the timestamp (or
+// in the default partition) are deleted row by row. When the events table is a
+// TimescaleDB hypertable, old chunks are instead dropped via drop_chunks. If
+// cold storage is configured, archived events before the timestamp are also
+// deleted, as are any webhook deliveries of events before the timestamp. This
+// function also takes a `execute` parameter. If set to true the delete
+// operation is performed and committed, but if set to false we just count the
+// events that would be deleted, allowing a caller to see how many events would
+// be removed.
 func (d *DB) DeleteData(before time.Time, execute bool) error {
+	if !execute {
+		sql := `SELECT COUNT(*) FROM events WHERE recorded_at < $1`
+
+		var count int
+		err := d.DB.Get(&count, sql, before)
+		if err != nil {
+			reporting.Report(context.Background(), err, map[string]string{"operation": "deleteData"})
+			return errors.Wrap(err, "failed to count old events")
+		}
+
+		if d.coldStore != nil {
+			archived, err := d.deleteArchived(context.Background(), before, execute)
+			if err != nil {
+				return err
+			}
+
+			d.logger.Log("msg", "deleted archived events", "count", archived, "execute", execute)
+		}
+
+		deliveries, err := d.deleteDeliveries(before, execute)
+		if err != nil {
+			return err
+		}
+
+		d.logger.Log("msg", "deleted old webhook deliveries", "count", deliveries, "execute", execute)
+
+		d.logger.Log("msg", "deleted old events", "count", count, "execute", execute)
+
+		return nil
+	}
+
+	if d.coldStore != nil {
+		archived, err := d.deleteArchived(context.Background(), before, execute)
+		if err != nil {
+			return err
+		}
+
+		eventsDeleted.WithLabelValues("archive").Add(float64(archived))
+
+		d.logger.Log("msg", "deleted archived events", "count", archived, "execute", execute)
+	}
+
+	deliveries, err := d.deleteDeliveries(before, execute)
+	if err != nil {
+		return err
+	}
+
+	d.logger.Log("msg", "deleted old webhook deliveries", "count", deliveries, "execute", execute)
+
+	if d.hypertable != nil {
+		return d.dropChunks(before)
+	}
+
+	partitions, err := d.Partitions()
+	if err != nil {
+		return err
+	}
+
 	sql := `WITH deleted AS
 		(DELETE FROM events WHERE recorded_at < ? RETURNING *)
 		SELECT COUNT(*) FROM deleted`
 
 	sql = d.DB.Rebind(sql)
 
-	if d.verbose {
+	if d.isVerbose() {
 		d.logger.Log(
 			"msg", "deleting old events",
 			"sql", sql,
@@ -229,25 +634,112 @@ func (d *DB) DeleteData(before time.Time, execute bool) error {
 
 	tx, err := d.DB.Beginx()
 	if err != nil {
-		raven.CaptureError(err, map[string]string{"operation": "deleteData"})
+		reporting.Report(context.Background(), err, map[string]string{"operation": "deleteData"})
 		return errors.Wrap(err, "failed to start transaction")
 	}
 
+	var total int
+
+	for _, p := range partitions {
+		if p.End.After(before) {
+			continue
+		}
+
+		var count int
+		err = tx.Get(&count, fmt.Sprintf(`SELECT COUNT(*) FROM %s`, p.Name))
+		if err != nil {
+			tx.Rollback()
+			reporting.Report(context.Background(), err, map[string]string{"operation": "deleteData"})
+			return errors.Wrap(err, "failed to count events in partition")
+		}
+
+		_, err = tx.Exec(fmt.Sprintf(`ALTER TABLE events DETACH PARTITION %s`, p.Name))
+		if err != nil {
+			tx.Rollback()
+			reporting.Report(context.Background(), err, map[string]string{"operation": "deleteData"})
+			return errors.Wrap(err, "failed to detach partition")
+		}
+
+		_, err = tx.Exec(fmt.Sprintf(`DROP TABLE %s`, p.Name))
+		if err != nil {
+			tx.Rollback()
+			reporting.Report(context.Background(), err, map[string]string{"operation": "deleteData"})
+			return errors.Wrap(err, "failed to drop partition")
+		}
+
+		if d.isVerbose() {
+			d.logger.Log("msg", "dropped events partition", "partition", p.Name, "count", count)
+		}
+
+		total = total + count
+	}
+
 	var count int
 	err = tx.Get(&count, sql, before)
 	if err != nil {
 		tx.Rollback()
-		raven.CaptureError(err, map[string]string{"operation": "deleteData"})
+		reporting.Report(context.Background(), err, map[string]string{"operation": "deleteData"})
 		return errors.Wrap(err, "failed to execute delete query")
 	}
 
-	d.logger.Log("msg", "deleted old events", "count", count, "execute", execute)
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	eventsDeleted.WithLabelValues("events").Add(float64(total + count))
 
-	if !execute {
-		return tx.Rollback()
+	d.logger.Log("msg", "deleted old events", "count", total+count, "execute", execute)
+
+	return nil
+}
+
+// StaleDevices returns a list of devices from which we have not received any
+// events for longer than the given threshold, ordered so that the devices that
+// have been silent longest are returned first. If communityId is empty, stale
+// devices for all communities are returned.
+func (d *DB) StaleDevices(ctx context.Context, communityId string, threshold time.Duration) ([]*Device, error) {
+	builder := sq.Select("community_id", "device_token", "last_seen_at").
+		From("devices").
+		Where(sq.Lt{"last_seen_at": time.Now().Add(-threshold)}).
+		OrderBy("last_seen_at ASC", "device_token ASC")
+
+	if communityId != "" {
+		builder = builder.Where(sq.Eq{"community_id": communityId})
 	}
 
-	return tx.Commit()
+	sql, args, err := builder.ToSql()
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "staleDevices"})
+		return nil, errors.Wrap(err, "failed to build sql query")
+	}
+
+	sql = d.DB.Rebind(sql)
+
+	devices := []*Device{}
+
+	err = d.reader().SelectContext(ctx, &devices, sql, args...)
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "staleDevices"})
+		return nil, errors.Wrap(err, "failed to read stale devices")
+	}
+
+	return devices, nil
+}
+
+// CountStaleDevices returns the number of devices across all communities from
+// which we have not received any events for longer than the given threshold.
+func (d *DB) CountStaleDevices(ctx context.Context, threshold time.Duration) (int, error) {
+	sql := `SELECT COUNT(*) FROM devices WHERE last_seen_at < $1`
+
+	var count int
+	err := d.reader().GetContext(ctx, &count, sql, time.Now().Add(-threshold))
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "countStaleDevices"})
+		return 0, errors.Wrap(err, "failed to count stale devices")
+	}
+
+	return count, nil
 }
 
 // Ping attempts to verify a connection to the database is still alive,
@@ -255,8 +747,8 @@ func (d *DB) DeleteData(before time.Time, execute bool) error {
 // agianst the DB. Note using DB.Ping() did not work as expected as if there are
 // existing connections in the pool that aren't used it will return no error
 // without actually going to the DB to check.
-func (d *DB) Ping() error {
-	_, err := d.DB.Exec("SELECT 1")
+func (d *DB) Ping(ctx context.Context) error {
+	_, err := d.DB.ExecContext(ctx, "SELECT 1")
 	if err != nil {
 		return err
 	}
@@ -266,35 +758,38 @@ func (d *DB) Ping() error {
 // Get is our implementation of the method defined in the autocert.Cache
 // interface for reading certificates from some underlying datastore.
 func (d *DB) Get(ctx context.Context, key string) ([]byte, error) {
-	if d.verbose {
+	if d.isVerbose() {
 		d.logger.Log(
 			"msg", "get certificate",
-			"key", key,
+			"key", redact.CertificateKey(key),
 		)
 	}
 
 	query := `SELECT certificate FROM certificates WHERE key = $1`
 
 	var certificate []byte
-	err := d.DB.Get(&certificate, query, key)
+	err := d.DB.GetContext(ctx, &certificate, query, key)
 	if err != nil {
 		if err == sql.ErrNoRows {
+			certificateCache.WithLabelValues("miss").Inc()
 			return nil, autocert.ErrCacheMiss
 		}
-		raven.CaptureError(err, map[string]string{"operation": "getCertificate"})
+		reporting.Report(ctx, err, map[string]string{"operation": "getCertificate"})
 		return nil, errors.Wrap(err, "failed to read certificate from DB")
 	}
 
+	certificateCache.WithLabelValues("hit").Inc()
+
 	return certificate, nil
 }
 
 // Put is our implementation of the method defined in the autocert.Cache
 // interface for writing certificates to some underlying datastore.
 func (d *DB) Put(ctx context.Context, key string, data []byte) error {
-	if d.verbose {
+	if d.isVerbose() {
 		d.logger.Log(
 			"msg", "putting certificate",
-			"key", key,
+			"key", redact.CertificateKey(key),
 		)
 	}
 
@@ -308,23 +803,23 @@ func (d *DB) Put(ctx context.Context, key string, data []byte) error {
 		"certificate": data,
 	}
 
-	tx, err := d.DB.Beginx()
+	tx, err := d.DB.BeginTxx(ctx, nil)
 	if err != nil {
-		raven.CaptureError(err, map[string]string{"operation": "putCertificate"})
+		reporting.Report(ctx, err, map[string]string{"operation": "putCertificate"})
 		return errors.Wrap(err, "failed to begin transaction when writing certificate")
 	}
 
 	sql, args, err := tx.BindNamed(sql, mapArgs)
 	if err != nil {
 		tx.Rollback()
-		raven.CaptureError(err, map[string]string{"operation": "putCertificate"})
+		reporting.Report(ctx, err, map[string]string{"operation": "putCertificate"})
 		return errors.Wrap(err, "failed to bind named parameters")
 	}
 
-	_, err = tx.Exec(sql, args...)
+	_, err = tx.ExecContext(ctx, sql, args...)
 	if err != nil {
 		tx.Rollback()
-		raven.CaptureError(err, map[string]string{"operation": "putCertificate"})
+		reporting.Report(ctx, err, map[string]string{"operation": "putCertificate"})
 		return errors.Wrap(err, "failed to insert certificate")
 	}
 
@@ -334,25 +829,25 @@ func (d *DB) Put(ctx context.Context, key string, data []byte) error {
 // Delete is our implementation of the autocert.Cache interface for deleting
 // certificates from some underlying datastore.
 func (d *DB) Delete(ctx context.Context, key string) error {
-	if d.verbose {
+	if d.isVerbose() {
 		d.logger.Log(
 			"msg", "deleting certificate",
-			"key", key,
+			"key", redact.CertificateKey(key),
 		)
 	}
 
 	sql := `DELETE FROM certificates WHERE key = $1`
 
-	tx, err := d.DB.Beginx()
+	tx, err := d.DB.BeginTxx(ctx, nil)
 	if err != nil {
-		raven.CaptureError(err, map[string]string{"operation": "deleteCertificate"})
+		reporting.Report(ctx, err, map[string]string{"operation": "deleteCertificate"})
 		return errors.Wrap(err, "failed to begin transaction when deleting certificate")
 	}
 
-	_, err = tx.Exec(sql, key)
+	_, err = tx.ExecContext(ctx, sql, key)
 	if err != nil {
 		tx.Rollback()
-		raven.CaptureError(err, map[string]string{"operation": "deleteCertificate"})
+		reporting.Report(ctx, err, map[string]string{"operation": "deleteCertificate"})
 		return errors.Wrap(err, "failed to delete certificate")
 	}
 
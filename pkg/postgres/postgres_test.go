@@ -2,15 +2,21 @@ package postgres_test
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	kitlog "github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"golang.org/x/crypto/acme/autocert"
 
+	"github.com/DECODEproject/iotstore/pkg/archive"
 	"github.com/DECODEproject/iotstore/pkg/postgres"
 )
 
@@ -35,7 +41,7 @@ func (s *PostgresSuite) SetupTest() {
 		s.T().Fatalf("Failed to close DB: %v", err)
 	}
 
-	s.db = postgres.NewDB(connStr, true, logger)
+	s.db = postgres.NewDB(&postgres.Config{ConnStr: connStr, Verbose: true}, logger)
 
 	err = s.db.Start()
 	if err != nil {
@@ -48,20 +54,21 @@ func (s *PostgresSuite) TearDownTest() {
 }
 
 func (s *PostgresSuite) TestRoundTripEvent() {
+	ctx := context.Background()
 	startTime := time.Now().Add(time.Hour * -1)
 	communityId := "abc123"
 	deviceToken := "device-token"
 
-	err := s.db.WriteData(communityId, []byte("encrypted bytes"), deviceToken)
+	err := s.db.WriteData(ctx, communityId, []byte("encrypted bytes"), deviceToken)
 	assert.Nil(s.T(), err)
-	err = s.db.WriteData(communityId, []byte("encrypted bytes"), deviceToken)
+	err = s.db.WriteData(ctx, communityId, []byte("encrypted bytes"), deviceToken)
 	assert.Nil(s.T(), err)
-	err = s.db.WriteData(communityId, []byte("encrypted bytes"), deviceToken)
+	err = s.db.WriteData(ctx, communityId, []byte("encrypted bytes"), deviceToken)
 	assert.Nil(s.T(), err)
-	err = s.db.WriteData(communityId, []byte("encrypted bytes"), deviceToken)
+	err = s.db.WriteData(ctx, communityId, []byte("encrypted bytes"), deviceToken)
 	assert.Nil(s.T(), err)
 
-	page, err := s.db.ReadData(communityId, 3, startTime, time.Time{}, "")
+	page, err := s.db.ReadData(ctx, communityId, 3, startTime, time.Time{}, "")
 	assert.Nil(s.T(), err)
 	assert.Len(s.T(), page.Events, 3)
 	assert.NotEqual(s.T(), "", page.NextPageCursor)
@@ -70,41 +77,910 @@ func (s *PostgresSuite) TestRoundTripEvent() {
 	assert.Equal(s.T(), []byte("encrypted bytes"), event.Data)
 
 	// get next page
-	page, err = s.db.ReadData(communityId, 3, startTime, time.Time{}, page.NextPageCursor)
+	page, err = s.db.ReadData(ctx, communityId, 3, startTime, time.Time{}, page.NextPageCursor)
 	assert.Nil(s.T(), err)
 	assert.Len(s.T(), page.Events, 1)
 
 	err = s.db.DeleteData(time.Now(), false)
 	assert.Nil(s.T(), err)
 
-	page, err = s.db.ReadData(communityId, 50, startTime, time.Time{}, "")
+	page, err = s.db.ReadData(ctx, communityId, 50, startTime, time.Time{}, "")
 	assert.Nil(s.T(), err)
 	assert.Len(s.T(), page.Events, 4)
 
 	err = s.db.DeleteData(time.Now(), true)
 	assert.Nil(s.T(), err)
 
-	page, err = s.db.ReadData(communityId, 50, startTime, time.Time{}, "")
+	page, err = s.db.ReadData(ctx, communityId, 50, startTime, time.Time{}, "")
 	assert.Nil(s.T(), err)
 	assert.Len(s.T(), page.Events, 0)
 }
 
 func (s *PostgresSuite) TestReadWithEndTime() {
+	ctx := context.Background()
 	startTime := time.Now().Add(time.Hour * -1)
 	endTime := time.Now().Add(time.Minute * -30)
 	communityId := "abc123"
 	deviceToken := "device-token"
 
-	err := s.db.WriteData(communityId, []byte("encrypted bytes"), deviceToken)
+	err := s.db.WriteData(ctx, communityId, []byte("encrypted bytes"), deviceToken)
 	assert.Nil(s.T(), err)
 
-	page, err := s.db.ReadData(communityId, 50, startTime, endTime, "")
+	page, err := s.db.ReadData(ctx, communityId, 50, startTime, endTime, "")
 	assert.Nil(s.T(), err)
 	assert.Len(s.T(), page.Events, 0)
 }
 
+func (s *PostgresSuite) TestExportData() {
+	ctx := context.Background()
+	startTime, _ := time.Parse(time.RFC3339, "2018-05-01T00:00:00Z")
+	communityId := "abc123"
+
+	// events sharing a timestamp must not be skipped or repeated across pages
+	for i := 0; i < 1500; i++ {
+		s.db.DB.MustExec("INSERT INTO events (community_id, recorded_at, data, device_token) VALUES ($1, $2, $3, $4)", communityId, "2018-05-15T12:00:00Z", []byte("encrypted bytes"), fmt.Sprintf("device-%d", i%3))
+	}
+	s.db.DB.MustExec("INSERT INTO events (community_id, recorded_at, data, device_token) VALUES ($1, $2, $3, $4)", "other", "2018-05-15T12:00:00Z", []byte("encrypted bytes"), "device-0")
+
+	seen := map[int64]bool{}
+	var lastID int64
+
+	err := s.db.ExportData(ctx, communityId, startTime, time.Time{}, func(e *postgres.Event) error {
+		assert.False(s.T(), seen[e.ID])
+		assert.True(s.T(), e.ID > lastID)
+		assert.NotEmpty(s.T(), e.DeviceToken)
+		seen[e.ID] = true
+		lastID = e.ID
+		return nil
+	})
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), seen, 1500)
+
+	endTime, _ := time.Parse(time.RFC3339, "2018-05-15T00:00:00Z")
+	count := 0
+
+	err = s.db.ExportData(ctx, communityId, startTime, endTime, func(e *postgres.Event) error {
+		count++
+		return nil
+	})
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, count)
+}
+
+func (s *PostgresSuite) TestImportData() {
+	ctx := context.Background()
+	startTime, _ := time.Parse(time.RFC3339, "2018-05-01T00:00:00Z")
+	recordedAt, _ := time.Parse(time.RFC3339, "2018-05-15T12:00:00Z")
+
+	events := []*postgres.Event{
+		{ID: 100, CommunityID: "abc123", DeviceToken: "device-a", RecordedAt: recordedAt, Data: []byte("one")},
+		{ID: 101, CommunityID: "abc123", DeviceToken: "device-a", RecordedAt: recordedAt.Add(time.Second), Data: []byte("two")},
+		{ID: 102, CommunityID: "abc123", DeviceToken: "device-a", RecordedAt: recordedAt.Add(time.Second), Data: []byte("two")},
+	}
+
+	// the third event is identical to the second apart from its ID
+	inserted, err := s.db.ImportData(ctx, events, postgres.DedupeByHash)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, inserted)
+
+	inserted, err = s.db.ImportData(ctx, events, postgres.DedupeByHash)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, inserted)
+
+	page, err := s.db.ReadData(ctx, "abc123", 50, startTime, time.Time{}, "")
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), page.Events, 2)
+	assert.True(s.T(), recordedAt.Equal(page.Events[0].RecordedAt))
+
+	_, err = s.db.ImportData(ctx, events, "bogus")
+	assert.NotNil(s.T(), err)
+}
+
+func (s *PostgresSuite) TestImportDataByID() {
+	ctx := context.Background()
+	startTime, _ := time.Parse(time.RFC3339, "2018-05-01T00:00:00Z")
+	recordedAt, _ := time.Parse(time.RFC3339, "2018-05-15T12:00:00Z")
+
+	events := []*postgres.Event{
+		{ID: 100, CommunityID: "abc123", DeviceToken: "device-a", RecordedAt: recordedAt, Data: []byte("one")},
+		{ID: 101, CommunityID: "abc123", DeviceToken: "device-a", RecordedAt: recordedAt, Data: []byte("one")},
+	}
+
+	inserted, err := s.db.ImportData(ctx, events, postgres.DedupeByID)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, inserted)
+
+	inserted, err = s.db.ImportData(ctx, events, postgres.DedupeByID)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, inserted)
+
+	// new writes must be allocated IDs after the imported ones
+	err = s.db.WriteData(ctx, "abc123", []byte("three"), "device-a")
+	assert.Nil(s.T(), err)
+
+	page, err := s.db.ReadData(ctx, "abc123", 50, startTime, time.Time{}, "")
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), page.Events, 3)
+	assert.Equal(s.T(), int64(100), page.Events[0].ID)
+	assert.True(s.T(), page.Events[2].ID > 101)
+}
+
+func (s *PostgresSuite) TestPartitions() {
+	ctx := context.Background()
+	startTime, _ := time.Parse(time.RFC3339, "2018-05-01T00:00:00Z")
+	communityId := "abc123"
+	deviceToken := "device-token"
+
+	// events outside any monthly partition are written to the default partition
+	for _, ts := range []string{"2018-05-31T23:59:59Z", "2018-06-01T00:00:00Z", "2018-07-15T12:00:00Z"} {
+		s.db.DB.MustExec("INSERT INTO events (community_id, recorded_at, data, device_token) VALUES ($1, $2, $3, $4)", communityId, ts, []byte("encrypted bytes"), deviceToken)
+	}
+
+	// creating partitions should move events out of the default partition
+	err := s.db.CreatePartitions(startTime, 2)
+	assert.Nil(s.T(), err)
+
+	partitions, err := s.db.Partitions()
+	assert.Nil(s.T(), err)
+
+	names := []string{}
+	for _, p := range partitions {
+		names = append(names, p.Name)
+	}
+	assert.Contains(s.T(), names, "events_y2018m05")
+	assert.Contains(s.T(), names, "events_y2018m06")
+	assert.Contains(s.T(), names, "events_y2018m07")
+
+	var count int
+	err = s.db.DB.Get(&count, "SELECT COUNT(*) FROM events_default")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, count)
+
+	// reads should span partition boundaries
+	page, err := s.db.ReadData(ctx, communityId, 2, startTime, time.Time{}, "")
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), page.Events, 2)
+
+	page, err = s.db.ReadData(ctx, communityId, 2, startTime, time.Time{}, page.NextPageCursor)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), page.Events, 1)
+
+	// deleting should drop whole partitions before the timestamp
+	before, _ := time.Parse(time.RFC3339, "2018-07-01T00:00:00Z")
+	err = s.db.DeleteData(before, true)
+	assert.Nil(s.T(), err)
+
+	partitions, err = s.db.Partitions()
+	assert.Nil(s.T(), err)
+
+	names = []string{}
+	for _, p := range partitions {
+		names = append(names, p.Name)
+	}
+	assert.NotContains(s.T(), names, "events_y2018m05")
+	assert.NotContains(s.T(), names, "events_y2018m06")
+	assert.Contains(s.T(), names, "events_y2018m07")
+
+	page, err = s.db.ReadData(ctx, communityId, 50, startTime, time.Time{}, "")
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), page.Events, 1)
+}
+
+func (s *PostgresSuite) TestReadDataCursorOrder() {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	communityId := "abc123"
+
+	// IDs are not in the same order as timestamps, as is the case for imported
+	// events
+	for _, e := range []struct {
+		id         int64
+		recordedAt time.Time
+	}{
+		{100, now.Add(-3 * time.Minute)},
+		{50, now.Add(-2 * time.Minute)},
+		{200, now.Add(-time.Minute)},
+	} {
+		s.db.DB.MustExec(
+			`INSERT INTO events (id, community_id, recorded_at, data, device_token) VALUES ($1, $2, $3, $4, $5)`,
+			e.id, communityId, e.recordedAt, []byte("encrypted bytes"), "device-token",
+		)
+	}
+
+	ids := []int64{}
+	cursor := ""
+
+	for {
+		page, err := s.db.ReadData(ctx, communityId, 1, now.Add(-time.Hour), time.Time{}, cursor)
+		assert.Nil(s.T(), err)
+
+		for _, e := range page.Events {
+			ids = append(ids, e.ID)
+		}
+
+		if page.NextPageCursor == "" {
+			break
+		}
+		cursor = page.NextPageCursor
+	}
+
+	assert.Equal(s.T(), []int64{100, 50, 200}, ids)
+}
+
+func (s *PostgresSuite) TestWriteBuffer() {
+	ctx := context.Background()
+	startTime := time.Now().Add(time.Hour * -1)
+	communityId := "abc123"
+
+	db := postgres.NewDB(&postgres.Config{
+		ConnStr:             os.Getenv("IOTSTORE_DATABASE_URL"),
+		WriteBufferInterval: 10 * time.Millisecond,
+		WriteBufferSize:     5,
+	}, kitlog.NewNopLogger())
+
+	err := db.Start()
+	assert.Nil(s.T(), err)
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := db.WriteData(ctx, communityId, []byte("encrypted bytes"), fmt.Sprintf("device-%d", i%3))
+			assert.Nil(s.T(), err)
+		}(i)
+	}
+
+	wg.Wait()
+
+	// writes are acknowledged only after commit, so are immediately readable
+	page, err := db.ReadData(ctx, communityId, 50, startTime, time.Time{}, "")
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), page.Events, 12)
+
+	var count int
+	err = db.DB.Get(&count, "SELECT COUNT(*) FROM devices WHERE community_id = $1", communityId)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 3, count)
+
+	err = db.Stop()
+	assert.Nil(s.T(), err)
+}
+
+func (s *PostgresSuite) TestWriteBufferInvalidWrite() {
+	ctx := context.Background()
+	startTime := time.Now().Add(time.Hour * -1)
+	communityId := "abc123"
+
+	db := postgres.NewDB(&postgres.Config{
+		ConnStr:             os.Getenv("IOTSTORE_DATABASE_URL"),
+		WriteBufferInterval: 50 * time.Millisecond,
+		WriteBufferSize:     5,
+	}, kitlog.NewNopLogger())
+
+	err := db.Start()
+	assert.Nil(s.T(), err)
+
+	var wg sync.WaitGroup
+
+	errs := make([]error, 5)
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			// postgres rejects text containing NUL bytes, failing the COPY
+			deviceToken := fmt.Sprintf("device-%d", i)
+			if i == 2 {
+				deviceToken = "device-\x00"
+			}
+
+			errs[i] = db.WriteData(ctx, communityId, []byte("encrypted bytes"), deviceToken)
+		}(i)
+	}
+
+	wg.Wait()
+
+	// only the invalid write fails
+	for i, err := range errs {
+		if i == 2 {
+			assert.NotNil(s.T(), err)
+		} else {
+			assert.Nil(s.T(), err)
+		}
+	}
+
+	page, err := db.ReadData(ctx, communityId, 50, startTime, time.Time{}, "")
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), page.Events, 4)
+
+	err = db.Stop()
+	assert.Nil(s.T(), err)
+
+	// stopping again is a no-op rather than a panic
+	assert.NotPanics(s.T(), func() { db.Stop() })
+}
+
+func (s *PostgresSuite) TestWebhookDeliveries() {
+	ctx := context.Background()
+
+	webhook, err := s.db.CreateWebhook(ctx, "abc123", "https://example.com/hook", "secret")
+	assert.Nil(s.T(), err)
+
+	webhooks, err := s.db.Webhooks(ctx, "")
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), webhooks, 1)
+	assert.Equal(s.T(), "https://example.com/hook", webhooks[0].URL)
+
+	err = s.db.WriteData(ctx, "abc123", []byte("encrypted bytes"), "device-1")
+	assert.Nil(s.T(), err)
+
+	// events for other communities are not delivered
+	err = s.db.WriteData(ctx, "def456", []byte("encrypted bytes"), "device-1")
+	assert.Nil(s.T(), err)
+
+	deliveries, err := s.db.ClaimDeliveries(ctx, 10, time.Minute)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), deliveries, 1)
+
+	delivery := deliveries[0]
+	assert.Equal(s.T(), webhook.ID, delivery.WebhookID)
+	assert.Equal(s.T(), "secret", delivery.Secret)
+	assert.Equal(s.T(), "device-1", delivery.DeviceToken)
+	assert.Equal(s.T(), []byte("encrypted bytes"), delivery.Data)
+	assert.Equal(s.T(), 1, delivery.Attempts)
+
+	// a claimed delivery is leased until it is failed or completed
+	deliveries, err = s.db.ClaimDeliveries(ctx, 10, time.Minute)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), deliveries, 0)
+
+	err = s.db.FailDelivery(ctx, delivery.ID, time.Millisecond, "webhook responded with status 500")
+	assert.Nil(s.T(), err)
+
+	time.Sleep(10 * time.Millisecond)
+
+	deliveries, err = s.db.ClaimDeliveries(ctx, 10, time.Minute)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), deliveries, 1)
+	assert.Equal(s.T(), 2, deliveries[0].Attempts)
+
+	err = s.db.CompleteDelivery(ctx, delivery.ID)
+	assert.Nil(s.T(), err)
+
+	var count int
+	err = s.db.DB.Get(&count, "SELECT COUNT(*) FROM webhook_deliveries")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, count)
+
+	err = s.db.DeleteWebhook(ctx, webhook.ID)
+	assert.Nil(s.T(), err)
+
+	err = s.db.DeleteWebhook(ctx, webhook.ID)
+	assert.NotNil(s.T(), err)
+}
+
+func (s *PostgresSuite) TestAbandonedWebhookDeliveries() {
+	ctx := context.Background()
+
+	webhook, err := s.db.CreateWebhook(ctx, "abc123", "https://example.com/hook", "secret")
+	assert.Nil(s.T(), err)
+
+	err = s.db.WriteData(ctx, "abc123", []byte("encrypted bytes"), "device-1")
+	assert.Nil(s.T(), err)
+
+	deliveries, err := s.db.ClaimDeliveries(ctx, 10, time.Millisecond)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), deliveries, 1)
+
+	// giving up on a delivery discards its copy of the event
+	err = s.db.FailDelivery(ctx, deliveries[0].ID, 0, "webhook responded with status 500")
+	assert.Nil(s.T(), err)
+
+	time.Sleep(10 * time.Millisecond)
+
+	deliveries, err = s.db.ClaimDeliveries(ctx, 10, time.Minute)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), deliveries, 0)
+
+	var row struct {
+		DeviceToken string `db:"device_token"`
+		Data        []byte `db:"data"`
+		LastError   string `db:"last_error"`
+	}
+	err = s.db.DB.Get(&row, "SELECT device_token, data, last_error FROM webhook_deliveries WHERE webhook_id = $1", webhook.ID)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "", row.DeviceToken)
+	assert.Nil(s.T(), row.Data)
+	assert.Equal(s.T(), "webhook responded with status 500", row.LastError)
+
+	// a pending delivery is kept by a dry run but deleted with its event
+	err = s.db.WriteData(ctx, "abc123", []byte("encrypted bytes"), "device-1")
+	assert.Nil(s.T(), err)
+
+	var count int
+	err = s.db.DeleteData(time.Now(), false)
+	assert.Nil(s.T(), err)
+
+	err = s.db.DB.Get(&count, "SELECT COUNT(*) FROM webhook_deliveries")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, count)
+
+	err = s.db.DeleteData(time.Now(), true)
+	assert.Nil(s.T(), err)
+
+	err = s.db.DB.Get(&count, "SELECT COUNT(*) FROM webhook_deliveries")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, count)
+}
+
+func (s *PostgresSuite) TestPoolConfig() {
+	db := postgres.NewDB(&postgres.Config{
+		ConnStr:          os.Getenv("IOTSTORE_DATABASE_URL"),
+		MaxOpenConns:     3,
+		MaxIdleConns:     1,
+		StatementTimeout: 100 * time.Millisecond,
+	}, kitlog.NewNopLogger())
+
+	err := db.Start()
+	assert.Nil(s.T(), err)
+	defer db.Stop()
+
+	assert.Equal(s.T(), 3, db.DB.Stats().MaxOpenConnections)
+
+	var timeout string
+	err = db.DB.Get(&timeout, "SHOW statement_timeout")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "100ms", timeout)
+
+	_, err = db.DB.Exec("SELECT pg_sleep(1)")
+	assert.NotNil(s.T(), err)
+}
+
+func (s *PostgresSuite) TestReadReplica() {
+	ctx := context.Background()
+	startTime := time.Now().Add(time.Hour * -1)
+	connStr := os.Getenv("IOTSTORE_DATABASE_URL")
+
+	// we use the primary as the "replica" here; as it is not in recovery it
+	// reports zero lag so reads should be routed to it
+	db := postgres.NewDB(&postgres.Config{
+		ConnStr:       connStr,
+		ReadConnStr:   connStr,
+		ReplicaMaxLag: time.Second,
+	}, kitlog.NewNopLogger())
+
+	err := db.Start()
+	assert.Nil(s.T(), err)
+
+	err = db.WriteData(ctx, "abc123", []byte("encrypted bytes"), "device-token")
+	assert.Nil(s.T(), err)
+
+	page, err := db.ReadData(ctx, "abc123", 50, startTime, time.Time{}, "")
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), page.Events, 1)
+
+	err = db.Stop()
+	assert.Nil(s.T(), err)
+}
+
+func (s *PostgresSuite) TestColdStorage() {
+	ctx := context.Background()
+	startTime, _ := time.Parse(time.RFC3339, "2018-05-01T00:00:00Z")
+	communityId := "abc123"
+
+	dir, err := ioutil.TempDir("", "coldstore")
+	assert.Nil(s.T(), err)
+	defer os.RemoveAll(dir)
+
+	db := postgres.NewDB(&postgres.Config{
+		ConnStr:        os.Getenv("IOTSTORE_DATABASE_URL"),
+		ColdStorageURL: dir,
+	}, kitlog.NewNopLogger())
+
+	err = db.Start()
+	assert.Nil(s.T(), err)
+	defer db.Stop()
+
+	for _, ts := range []string{"2018-05-01T10:00:00Z", "2018-05-01T11:00:00Z", "2018-05-02T10:00:00Z", "2018-05-03T10:00:00Z"} {
+		db.DB.MustExec("INSERT INTO events (community_id, recorded_at, data, device_token) VALUES ($1, $2, $3, $4)", communityId, ts, []byte("encrypted bytes"), "device-token")
+	}
+
+	// only days ending before the cutoff are archived
+	cutoff, _ := time.Parse(time.RFC3339, "2018-05-02T12:00:00Z")
+
+	count, err := db.ArchiveData(ctx, cutoff)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, count)
+
+	var hot int
+	err = db.DB.Get(&hot, "SELECT COUNT(*) FROM events")
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, hot)
+
+	// reads page transparently across archived and hot events
+	page, err := db.ReadData(ctx, communityId, 3, startTime, time.Time{}, "")
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), page.Events, 3)
+	assert.NotEqual(s.T(), "", page.NextPageCursor)
+	assert.Equal(s.T(), 10, page.Events[0].RecordedAt.Hour())
+
+	page, err = db.ReadData(ctx, communityId, 3, startTime, time.Time{}, page.NextPageCursor)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), page.Events, 1)
+	assert.Equal(s.T(), 3, page.Events[0].RecordedAt.Day())
+
+	// deleting removes archived events too
+	before, _ := time.Parse(time.RFC3339, "2018-05-01T10:30:00Z")
+
+	err = db.DeleteData(before, true)
+	assert.Nil(s.T(), err)
+
+	page, err = db.ReadData(ctx, communityId, 50, startTime, time.Time{}, "")
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), page.Events, 3)
+}
+
+func (s *PostgresSuite) TestColdStorageDottedCommunity() {
+	ctx := context.Background()
+	startTime, _ := time.Parse(time.RFC3339, "2018-05-01T00:00:00Z")
+
+	dir, err := ioutil.TempDir("", "coldstore")
+	assert.Nil(s.T(), err)
+	defer os.RemoveAll(dir)
+
+	db := postgres.NewDB(&postgres.Config{
+		ConnStr:        os.Getenv("IOTSTORE_DATABASE_URL"),
+		ColdStorageURL: dir,
+	}, kitlog.NewNopLogger())
+
+	err = db.Start()
+	assert.Nil(s.T(), err)
+	defer db.Stop()
+
+	for _, communityId := range []string{"a..b", "abc123"} {
+		db.DB.MustExec("INSERT INTO events (community_id, recorded_at, data, device_token) VALUES ($1, $2, $3, $4)", communityId, "2018-05-01T10:00:00Z", []byte("encrypted bytes"), "device-token")
+	}
+
+	cutoff, _ := time.Parse(time.RFC3339, "2018-05-02T12:00:00Z")
+
+	count, err := db.ArchiveData(ctx, cutoff)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, count)
+
+	page, err := db.ReadData(ctx, "a..b", 50, startTime, time.Time{}, "")
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), page.Events, 1)
+}
+
+func (s *PostgresSuite) TestColdStorageCorruptSegment() {
+	ctx := context.Background()
+	startTime, _ := time.Parse(time.RFC3339, "2018-05-01T00:00:00Z")
+	communityId := "abc123"
+
+	dir, err := ioutil.TempDir("", "coldstore")
+	assert.Nil(s.T(), err)
+	defer os.RemoveAll(dir)
+
+	db := postgres.NewDB(&postgres.Config{
+		ConnStr:        os.Getenv("IOTSTORE_DATABASE_URL"),
+		ColdStorageURL: dir,
+	}, kitlog.NewNopLogger())
+
+	err = db.Start()
+	assert.Nil(s.T(), err)
+	defer db.Stop()
+
+	db.DB.MustExec("INSERT INTO events (community_id, recorded_at, data, device_token) VALUES ($1, $2, $3, $4)", communityId, "2018-05-01T10:00:00Z", []byte("encrypted bytes"), "device-token")
+
+	cutoff, _ := time.Parse(time.RFC3339, "2018-05-02T12:00:00Z")
+
+	count, err := db.ArchiveData(ctx, cutoff)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, count)
+
+	var key string
+	err = db.DB.Get(&key, "SELECT key FROM archived_segments")
+	assert.Nil(s.T(), err)
+
+	// replace the segment with a valid segment holding different events
+	b, err := archive.EncodeSegment([]*archive.Record{{ID: 99, CommunityID: communityId, DeviceToken: "other", RecordedAt: startTime, Data: []byte("forged")}})
+	assert.Nil(s.T(), err)
+
+	err = ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(key)), b, 0644)
+	assert.Nil(s.T(), err)
+
+	_, err = db.ReadData(ctx, communityId, 50, startTime, time.Time{}, "")
+	assert.NotNil(s.T(), err)
+}
+
+func (s *PostgresSuite) TestColdStorageExportImport() {
+	ctx := context.Background()
+	startTime, _ := time.Parse(time.RFC3339, "2018-05-01T00:00:00Z")
+	communityId := "abc123"
+
+	dir, err := ioutil.TempDir("", "coldstore")
+	assert.Nil(s.T(), err)
+	defer os.RemoveAll(dir)
+
+	db := postgres.NewDB(&postgres.Config{
+		ConnStr:        os.Getenv("IOTSTORE_DATABASE_URL"),
+		ColdStorageURL: dir,
+	}, kitlog.NewNopLogger())
+
+	err = db.Start()
+	assert.Nil(s.T(), err)
+	defer db.Stop()
+
+	for _, ts := range []string{"2018-05-01T10:00:00Z", "2018-05-02T10:00:00Z", "2018-05-03T10:00:00Z"} {
+		db.DB.MustExec("INSERT INTO events (community_id, recorded_at, data, device_token) VALUES ($1, $2, $3, $4)", communityId, ts, []byte("encrypted bytes"), "device-token")
+	}
+
+	cutoff, _ := time.Parse(time.RFC3339, "2018-05-02T12:00:00Z")
+
+	count, err := db.ArchiveData(ctx, cutoff)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 1, count)
+
+	// exports include archived events
+	exported := []*postgres.Event{}
+
+	err = db.ExportData(ctx, communityId, startTime, time.Time{}, func(e *postgres.Event) error {
+		exported = append(exported, e)
+		return nil
+	})
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), exported, 3)
+	assert.Equal(s.T(), 1, exported[0].RecordedAt.Day())
+
+	// without cold storage the export fails rather than missing events
+	err = s.db.ExportData(ctx, communityId, startTime, time.Time{}, func(e *postgres.Event) error {
+		return nil
+	})
+	assert.NotNil(s.T(), err)
+
+	// archived events are duplicates when importing
+	inserted, err := db.ImportData(ctx, exported, postgres.DedupeByHash)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, inserted)
+
+	inserted, err = db.ImportData(ctx, exported, postgres.DedupeByID)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 0, inserted)
+
+	_, err = s.db.ImportData(ctx, exported, postgres.DedupeByHash)
+	assert.NotNil(s.T(), err)
+}
+
+// deletedEvents returns the value of the counter of events deleted from the
+// given store.
+func deletedEvents(t *testing.T, store string) float64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	assert.Nil(t, err)
+
+	for _, f := range families {
+		if f.GetName() != "decode_datastore_events_deleted_total" {
+			continue
+		}
+
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "store" && l.GetValue() == store {
+					return m.GetCounter().GetValue()
+				}
+			}
+		}
+	}
+
+	return 0
+}
+
+func (s *PostgresSuite) TestDeleteDataMetric() {
+	for _, timescale := range []bool{false, true} {
+		db := postgres.NewDB(&postgres.Config{ConnStr: os.Getenv("IOTSTORE_DATABASE_URL"), Timescale: timescale}, kitlog.NewNopLogger())
+
+		err := db.Start()
+		assert.Nil(s.T(), err)
+
+		for _, ts := range []string{"2018-05-01T10:00:00Z", "2018-05-02T10:00:00Z", "2018-06-01T10:00:00Z"} {
+			db.DB.MustExec("INSERT INTO events (community_id, recorded_at, data, device_token) VALUES ($1, $2, $3, $4)", "abc123", ts, []byte("encrypted bytes"), "device-token")
+		}
+
+		deleted := deletedEvents(s.T(), "events")
+
+		// deleted events are counted whether old partitions are dropped or,
+		// when the database has the timescaledb extension, old chunks
+		before, _ := time.Parse(time.RFC3339, "2018-05-15T00:00:00Z")
+
+		err = db.DeleteData(before, true)
+		assert.Nil(s.T(), err)
+		assert.Equal(s.T(), deleted+2, deletedEvents(s.T(), "events"))
+
+		db.DB.MustExec("DELETE FROM events")
+
+		err = db.Stop()
+		assert.Nil(s.T(), err)
+	}
+}
+
+func (s *PostgresSuite) TestTimescaleFallback() {
+	// our test database does not have the timescaledb extension, so requesting
+	// timescale mode should fall back to the partitioned schema
+	db := postgres.NewDB(&postgres.Config{ConnStr: os.Getenv("IOTSTORE_DATABASE_URL"), Timescale: true}, kitlog.NewNopLogger())
+
+	err := db.Start()
+	assert.Nil(s.T(), err)
+	defer db.Stop()
+
+	err = db.CreatePartitions(time.Now(), 1)
+	assert.Nil(s.T(), err)
+
+	partitions, err := db.Partitions()
+	assert.Nil(s.T(), err)
+	assert.NotEmpty(s.T(), partitions)
+
+	// the events table is still natively partitioned rather than a hypertable
+	var relkind string
+	err = s.db.DB.Get(&relkind, `SELECT relkind FROM pg_class WHERE oid = 'events'::regclass`)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), "p", relkind)
+}
+
+func (s *PostgresSuite) TestStaleDevices() {
+	ctx := context.Background()
+	communityId := "abc123"
+
+	err := s.db.WriteData(ctx, communityId, []byte("encrypted bytes"), "device-1")
+	assert.Nil(s.T(), err)
+	err = s.db.WriteData(ctx, communityId, []byte("encrypted bytes"), "device-2")
+	assert.Nil(s.T(), err)
+	err = s.db.WriteData(ctx, "def456", []byte("encrypted bytes"), "device-3")
+	assert.Nil(s.T(), err)
+
+	s.db.DB.MustExec("UPDATE devices SET last_seen_at = $1 WHERE device_token = $2", time.Now().Add(time.Hour*-2), "device-1")
+	s.db.DB.MustExec("UPDATE devices SET last_seen_at = $1 WHERE device_token = $2", time.Now().Add(time.Hour*-3), "device-3")
+
+	devices, err := s.db.StaleDevices(ctx, communityId, time.Hour)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), devices, 1)
+	assert.Equal(s.T(), "device-1", devices[0].DeviceToken)
+	assert.Equal(s.T(), communityId, devices[0].CommunityID)
+
+	devices, err = s.db.StaleDevices(ctx, "", time.Hour)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), devices, 2)
+	assert.Equal(s.T(), "device-3", devices[0].DeviceToken)
+
+	count, err := s.db.CountStaleDevices(ctx, time.Hour)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), 2, count)
+
+	// writing again should mark the device as alive
+	err = s.db.WriteData(ctx, communityId, []byte("encrypted bytes"), "device-1")
+	assert.Nil(s.T(), err)
+
+	devices, err = s.db.StaleDevices(ctx, communityId, time.Hour)
+	assert.Nil(s.T(), err)
+	assert.Len(s.T(), devices, 0)
+}
+
+func (s *PostgresSuite) TestTableSizes() {
+	ctx := context.Background()
+
+	err := s.db.WriteData(ctx, "abc123", []byte("encrypted bytes"), "device-token")
+	assert.Nil(s.T(), err)
+
+	sizes, err := s.db.TableSizes(ctx)
+	assert.Nil(s.T(), err)
+
+	// the size of the partitioned events table includes its partitions
+	assert.True(s.T(), sizes["events"] > 0)
+	assert.True(s.T(), sizes["devices"] > 0)
+
+	// partitions are not reported separately
+	_, ok := sizes["events_default"]
+	assert.False(s.T(), ok)
+}
+
+func (s *PostgresSuite) TestMigrationVersion() {
+	version, dirty, err := s.db.MigrationVersion(context.Background())
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), postgres.LatestMigration(), version)
+	assert.False(s.T(), dirty)
+}
+
+func (s *PostgresSuite) TestMigrationStatus() {
+	logger := kitlog.NewNopLogger()
+
+	state, err := postgres.MigrationStatus(s.db.DB.DB, logger)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), postgres.LatestMigration(), state.Version)
+	assert.Equal(s.T(), postgres.LatestMigration(), state.Latest)
+	assert.False(s.T(), state.Dirty)
+	assert.Len(s.T(), state.Pending, 0)
+}
+
+func (s *PostgresSuite) TestMigrateTo() {
+	logger := kitlog.NewNopLogger()
+	migrations := postgres.Migrations()
+
+	err := postgres.MigrateTo(s.db.DB.DB, migrations[0].Version, logger)
+	assert.Nil(s.T(), err)
+
+	state, err := postgres.MigrationStatus(s.db.DB.DB, logger)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), migrations[0].Version, state.Version)
+	assert.Equal(s.T(), migrations[1:], state.Pending)
+
+	err = postgres.MigrateUpTo(s.db.DB.DB, migrations[1].Version, logger)
+	assert.Nil(s.T(), err)
+
+	state, err = postgres.MigrationStatus(s.db.DB.DB, logger)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), migrations[1].Version, state.Version)
+
+	// up migrations may not go down
+	err = postgres.MigrateUpTo(s.db.DB.DB, migrations[0].Version, logger)
+	assert.NotNil(s.T(), err)
+
+	err = postgres.MigrateTo(s.db.DB.DB, 1, logger)
+	assert.NotNil(s.T(), err)
+
+	err = postgres.MigrateUp(s.db.DB.DB, logger)
+	assert.Nil(s.T(), err)
+}
+
+func (s *PostgresSuite) TestMigrateForce() {
+	logger := kitlog.NewNopLogger()
+
+	s.db.DB.MustExec(`UPDATE schema_migrations SET dirty = true`)
+
+	state, err := postgres.MigrationStatus(s.db.DB.DB, logger)
+	assert.Nil(s.T(), err)
+	assert.True(s.T(), state.Dirty)
+
+	err = postgres.MigrateUp(s.db.DB.DB, logger)
+	assert.NotNil(s.T(), err)
+
+	err = postgres.MigrateForce(s.db.DB.DB, 1, logger)
+	assert.NotNil(s.T(), err)
+
+	err = postgres.MigrateForce(s.db.DB.DB, int(postgres.LatestMigration()), logger)
+	assert.Nil(s.T(), err)
+
+	state, err = postgres.MigrationStatus(s.db.DB.DB, logger)
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), postgres.LatestMigration(), state.Version)
+	assert.False(s.T(), state.Dirty)
+}
+
+func (s *PostgresSuite) TestNoAutoMigrate() {
+	logger := kitlog.NewNopLogger()
+	connStr := os.Getenv("IOTSTORE_DATABASE_URL")
+
+	err := postgres.MigrateDownAll(s.db.DB.DB, logger)
+	assert.Nil(s.T(), err)
+
+	db := postgres.NewDB(&postgres.Config{ConnStr: connStr, NoAutoMigrate: true}, logger)
+
+	err = db.Start()
+	assert.NotNil(s.T(), err)
+
+	err = postgres.MigrateUp(s.db.DB.DB, logger)
+	assert.Nil(s.T(), err)
+
+	db = postgres.NewDB(&postgres.Config{ConnStr: connStr, NoAutoMigrate: true}, logger)
+
+	err = db.Start()
+	assert.Nil(s.T(), err)
+
+	db.Stop()
+}
+
 func (s *PostgresSuite) TestPing() {
-	err := s.db.Ping()
+	err := s.db.Ping(context.Background())
 	assert.Nil(s.T(), err)
 }
 
@@ -135,6 +1011,17 @@ func (s *PostgresSuite) TestCertificates() {
 	assert.Equal(s.T(), autocert.ErrCacheMiss, err)
 }
 
+func TestMigrations(t *testing.T) {
+	migrations := postgres.Migrations()
+
+	assert.Equal(t, &postgres.Migration{Version: 20180519220506, Name: "add_events_table"}, migrations[0])
+	assert.Equal(t, postgres.LatestMigration(), migrations[len(migrations)-1].Version)
+
+	for i := 1; i < len(migrations); i++ {
+		assert.True(t, migrations[i-1].Version < migrations[i].Version)
+	}
+}
+
 func TestPostgresSuite(t *testing.T) {
 	suite.Run(t, new(PostgresSuite))
 }
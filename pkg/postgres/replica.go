@@ -0,0 +1,182 @@
+package postgres
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	registry "github.com/thingful/retryable-registry-prometheus"
+)
+
+const (
+	// replicaCheckInterval is how often we check the replication lag of the
+	// read replica.
+	replicaCheckInterval = 5 * time.Second
+)
+
+var (
+	// replicaLag is a gauge exposing the most recently measured replication lag
+	// of the read replica.
+	replicaLag = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "replica_lag_sec",
+			Help:      "Replication lag (in seconds) of the read replica",
+		},
+	)
+
+	// replicaInUse is a gauge that is 1 when reads are being sent to the replica,
+	// and 0 when we have fallen back to the primary.
+	replicaInUse = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "replica_in_use",
+			Help:      "Whether reads are currently routed to the read replica",
+		},
+	)
+)
+
+func init() {
+	registry.MustRegister(replicaLag, replicaInUse)
+}
+
+// lagSQL is the query we run against the replica to measure how far behind the
+// primary it is. If the replica is streaming from the primary and has replayed
+// everything it has received we report zero lag, as otherwise the replay
+// timestamp would grow while the primary is idle. If its WAL receiver has
+// disconnected it receives nothing further, so the received and replayed
+// positions stay equal however far behind it falls; in that case we report it
+// as disconnected, along with the time since it last replayed a transaction.
+const lagSQL = `WITH receiver AS (
+	SELECT EXISTS (
+		SELECT 1 FROM pg_stat_wal_receiver WHERE status = 'streaming'
+	) AS streaming
+)
+SELECT
+	pg_is_in_recovery() AND NOT receiver.streaming AS disconnected,
+	CASE
+		WHEN NOT pg_is_in_recovery() THEN 0
+		WHEN receiver.streaming AND pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+		ELSE COALESCE(EXTRACT(EPOCH FROM NOW() - pg_last_xact_replay_timestamp()), 0)
+	END AS lag
+FROM receiver`
+
+// replicaState is the result of lagSQL.
+type replicaState struct {
+	Disconnected bool    `db:"disconnected"`
+	Lag          float64 `db:"lag"`
+}
+
+// startReplica opens the connection pool for the read replica and starts the
+// goroutine that monitors its replication lag.
+func (d *DB) startReplica(connStr string) error {
+	d.logger.Log("msg", "starting postgres replica connection")
+
+	replica, err := Open(connStr)
+	if err != nil {
+		return errors.Wrap(err, "failed to open replica connection")
+	}
+
+	replica.SetMaxOpenConns(d.maxOpenConns)
+	replica.SetConnMaxLifetime(d.connMaxLifetime)
+
+	if d.maxIdleConns > 0 {
+		replica.SetMaxIdleConns(d.maxIdleConns)
+	}
+
+	registry.MustRegister(newStatsCollector(replica.DB, "replica"))
+
+	d.replica = replica
+	d.replicaQuit = make(chan struct{})
+	d.replicaStopped = make(chan struct{})
+
+	// check once before we start serving so we don't route reads to a replica
+	// that is too far behind
+	d.checkReplica()
+
+	go d.monitorReplica()
+
+	return nil
+}
+
+// stopReplica stops the lag monitor and closes the replica connection pool.
+func (d *DB) stopReplica() error {
+	close(d.replicaQuit)
+	<-d.replicaStopped
+
+	return d.replica.Close()
+}
+
+// monitorReplica periodically checks the replica's lag until stopped.
+func (d *DB) monitorReplica() {
+	defer close(d.replicaStopped)
+
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			d.checkReplica()
+		case <-d.replicaQuit:
+			return
+		}
+	}
+}
+
+// checkReplica measures the replica's lag, and decides whether reads should be
+// sent to it. If the lag cannot be measured, the replica is no longer streaming
+// from the primary, or the lag exceeds the configured maximum, reads fall back
+// to the primary. A maximum lag of zero disables fallback.
+func (d *DB) checkReplica() {
+	var state replicaState
+
+	err := d.replica.Get(&state, lagSQL)
+	if err != nil {
+		d.logger.Log("msg", "failed to check replica lag", "err", err)
+	} else {
+		replicaLag.Set(state.Lag)
+	}
+
+	lag := state.Lag
+
+	healthy := d.replicaMaxLag == 0 ||
+		(err == nil && !state.Disconnected && time.Duration(lag*float64(time.Second)) <= d.replicaMaxLag)
+
+	var inUse int32
+	if healthy {
+		inUse = 1
+	}
+
+	if atomic.SwapInt32(&d.replicaHealthy, inUse) != inUse {
+		d.logger.Log("msg", "read routing changed", "useReplica", healthy, "lag", lag, "disconnected", state.Disconnected)
+	}
+
+	replicaInUse.Set(float64(inUse))
+}
+
+// UsingReplica returns true if a read replica is configured and read queries
+// are currently being sent to it, or false if they fall back to the primary.
+func (d *DB) UsingReplica() bool {
+	return d.replica != nil && atomic.LoadInt32(&d.replicaHealthy) == 1
+}
+
+// HasReplica returns true if a read replica is configured.
+func (d *DB) HasReplica() bool {
+	return d.replica != nil
+}
+
+// reader returns the connection pool that read queries should be sent to. This
+// is the replica if one is configured and it is not lagging too far behind the
+// primary, otherwise the primary.
+func (d *DB) reader() *sqlx.DB {
+	if d.replica != nil && atomic.LoadInt32(&d.replicaHealthy) == 1 {
+		return d.replica
+	}
+
+	return d.DB
+}
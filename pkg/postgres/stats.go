@@ -0,0 +1,103 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// statsCollector is an implementation of the prometheus.Collector interface
+// that exposes the connection pool statistics returned by sql.DB.Stats(). The
+// stats are read each time metrics are scraped.
+type statsCollector struct {
+	db *sql.DB
+
+	maxOpen           *prometheus.Desc
+	open              *prometheus.Desc
+	inUse             *prometheus.Desc
+	idle              *prometheus.Desc
+	waitCount         *prometheus.Desc
+	waitDuration      *prometheus.Desc
+	maxIdleClosed     *prometheus.Desc
+	maxLifetimeClosed *prometheus.Desc
+}
+
+// newStatsCollector returns a new collector for the given DB. The pool label is
+// used to distinguish between multiple connection pools.
+func newStatsCollector(db *sql.DB, pool string) *statsCollector {
+	labels := prometheus.Labels{"pool": pool}
+
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(
+			prometheus.BuildFQName("decode", "datastore", name),
+			help,
+			nil,
+			labels,
+		)
+	}
+
+	return &statsCollector{
+		db:                db,
+		maxOpen:           desc("db_max_open_connections", "Maximum number of open connections to the database"),
+		open:              desc("db_open_connections", "The number of established connections both in use and idle"),
+		inUse:             desc("db_in_use_connections", "The number of connections currently in use"),
+		idle:              desc("db_idle_connections", "The number of idle connections"),
+		waitCount:         desc("db_wait_count_total", "The total number of connections waited for"),
+		waitDuration:      desc("db_wait_duration_sec_total", "The total time (in seconds) blocked waiting for a new connection"),
+		maxIdleClosed:     desc("db_max_idle_closed_total", "The total number of connections closed due to the max idle setting"),
+		maxLifetimeClosed: desc("db_max_lifetime_closed_total", "The total number of connections closed due to the max lifetime setting"),
+	}
+}
+
+// Describe is our implementation of the prometheus.Collector interface.
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.maxIdleClosed
+	ch <- c.maxLifetimeClosed
+}
+
+// Collect is our implementation of the prometheus.Collector interface.
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.db.Stats()
+
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.maxIdleClosed, prometheus.CounterValue, float64(stats.MaxIdleClosed))
+	ch <- prometheus.MustNewConstMetric(c.maxLifetimeClosed, prometheus.CounterValue, float64(stats.MaxLifetimeClosed))
+}
+
+// withStatementTimeout returns the given connection string with a
+// statement_timeout runtime parameter added, which causes Postgres to cancel
+// any statement that runs for longer than the timeout. Both URL and key/value
+// style connection strings are supported.
+func withStatementTimeout(connStr string, timeout time.Duration) (string, error) {
+	ms := fmt.Sprintf("%d", int64(timeout/time.Millisecond))
+
+	if strings.HasPrefix(connStr, "postgres://") || strings.HasPrefix(connStr, "postgresql://") {
+		u, err := url.Parse(connStr)
+		if err != nil {
+			return "", err
+		}
+
+		q := u.Query()
+		q.Set("statement_timeout", ms)
+		u.RawQuery = q.Encode()
+
+		return u.String(), nil
+	}
+
+	return fmt.Sprintf("%s statement_timeout=%s", connStr, ms), nil
+}
@@ -0,0 +1,310 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotstore/pkg/reporting"
+)
+
+// hypertable is an internal type used to record that the events table has been
+// converted into a TimescaleDB hypertable, along with the major version of the
+// installed extension, as the signatures of some functions differ between
+// versions.
+type hypertable struct {
+	majorVersion int
+}
+
+// MigrateTimescale optionally converts the events table into a TimescaleDB
+// hypertable. This is run after the regular up migrations, and only takes
+// effect if the timescaledb extension is available on the server; if it is not
+// we log a message and leave the default partitioned schema in place. The
+// conversion is idempotent, so is safe to run each time the application boots.
+// If compressAfter is non-zero we also enable native compression for chunks
+// older than the given age.
+func MigrateTimescale(db *sql.DB, compressAfter time.Duration, logger kitlog.Logger) error {
+	var available bool
+	err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_available_extensions WHERE name = 'timescaledb')`).Scan(&available)
+	if err != nil {
+		return errors.Wrap(err, "failed to check for timescaledb extension")
+	}
+
+	if !available {
+		logger.Log("msg", "timescaledb extension not available, using default schema")
+		return nil
+	}
+
+	_, err = db.Exec(`CREATE EXTENSION IF NOT EXISTS timescaledb`)
+	if err != nil {
+		return errors.Wrap(err, "failed to create timescaledb extension")
+	}
+
+	ht, err := detectHypertable(sqlx.NewDb(db, "postgres"))
+	if err != nil {
+		return err
+	}
+
+	if ht == nil {
+		logger.Log("msg", "converting events table to timescale hypertable")
+
+		tx, err := db.Begin()
+		if err != nil {
+			return errors.Wrap(err, "failed to begin transaction")
+		}
+
+		_, err = tx.Exec(convertToHypertableSQL)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrap(err, "failed to convert events to hypertable")
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return errors.Wrap(err, "failed to commit hypertable conversion")
+		}
+
+		ht, err = detectHypertable(sqlx.NewDb(db, "postgres"))
+		if err != nil {
+			return err
+		}
+	}
+
+	if compressAfter > 0 {
+		return enableCompression(db, ht, compressAfter, logger)
+	}
+
+	return nil
+}
+
+// convertToHypertableSQL is the SQL we execute to convert our declaratively
+// partitioned events table into a hypertable. TimescaleDB cannot convert a
+// partitioned table directly, so we create a new plain table, convert that, and
+// then copy all existing events across before dropping the old partitions.
+const convertToHypertableSQL = `
+ALTER TABLE events RENAME TO events_partitioned;
+ALTER TABLE events_partitioned RENAME CONSTRAINT events_pkey TO events_partitioned_pkey;
+ALTER INDEX events_community_id_idx RENAME TO events_partitioned_community_id_idx;
+ALTER INDEX events_recorded_at_idx RENAME TO events_partitioned_recorded_at_idx;
+ALTER INDEX events_device_token_idx RENAME TO events_partitioned_device_token_idx;
+ALTER SEQUENCE events_id_seq OWNED BY NONE;
+
+CREATE TABLE events (
+  id BIGINT NOT NULL DEFAULT nextval('events_id_seq'),
+  community_id TEXT NOT NULL,
+  recorded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+  data BYTEA,
+  device_token TEXT NOT NULL,
+  PRIMARY KEY (id, recorded_at)
+);
+
+ALTER SEQUENCE events_id_seq OWNED BY events.id;
+
+CREATE INDEX events_community_id_idx ON events (community_id);
+CREATE INDEX events_device_token_idx ON events (device_token);
+
+SELECT create_hypertable('events', 'recorded_at', create_default_indexes => FALSE);
+
+CREATE INDEX events_recorded_at_idx ON events (recorded_at);
+
+INSERT INTO events (id, community_id, recorded_at, data, device_token)
+  SELECT id, community_id, recorded_at, data, device_token
+  FROM events_partitioned;
+
+DROP TABLE events_partitioned CASCADE;
+`
+
+// queryRower is implemented by both sql.DB and sql.Tx, allowing helpers to be
+// used either inside or outside a transaction.
+type queryRower interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// compressionEnabled returns true if native compression has been enabled for
+// the events hypertable.
+func compressionEnabled(db queryRower) (bool, error) {
+	var compressed bool
+	err := db.QueryRow(`SELECT compressed_hypertable_id IS NOT NULL
+		FROM _timescaledb_catalog.hypertable
+		WHERE schema_name = current_schema() AND table_name = 'events'`).Scan(&compressed)
+	if err != nil {
+		return false, errors.Wrap(err, "failed to check if compression is enabled")
+	}
+
+	return compressed, nil
+}
+
+// enableCompression turns on native compression for the events hypertable,
+// segmenting by community so that reads for a single community remain cheap,
+// and adds a background policy compressing chunks older than the given age.
+func enableCompression(db *sql.DB, ht *hypertable, compressAfter time.Duration, logger kitlog.Logger) error {
+	compressed, err := compressionEnabled(db)
+	if err != nil {
+		return err
+	}
+
+	if !compressed {
+		_, err = db.Exec(`ALTER TABLE events SET (
+			timescaledb.compress,
+			timescaledb.compress_segmentby = 'community_id',
+			timescaledb.compress_orderby = 'recorded_at ASC, id ASC'
+		)`)
+		if err != nil {
+			return errors.Wrap(err, "failed to enable compression")
+		}
+	}
+
+	interval := fmt.Sprintf("%d seconds", int64(compressAfter/time.Second))
+
+	var query string
+	if ht.majorVersion >= 2 {
+		query = `SELECT add_compression_policy('events', $1::INTERVAL, if_not_exists => TRUE)`
+	} else {
+		query = `SELECT add_compress_chunks_policy('events', $1::INTERVAL, if_not_exists => TRUE)`
+	}
+
+	_, err = db.Exec(query, interval)
+	if err != nil {
+		return errors.Wrap(err, "failed to add compression policy")
+	}
+
+	logger.Log("msg", "enabled timescale compression", "compressAfter", compressAfter)
+
+	return nil
+}
+
+// detectHypertable returns a non nil hypertable instance if the events table
+// has been converted into a TimescaleDB hypertable, or nil if not.
+func detectHypertable(db *sqlx.DB) (*hypertable, error) {
+	var catalog bool
+	err := db.Get(&catalog, `SELECT to_regclass('_timescaledb_catalog.hypertable') IS NOT NULL`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check for timescale catalog")
+	}
+
+	if !catalog {
+		return nil, nil
+	}
+
+	var exists bool
+	err = db.Get(&exists, `SELECT EXISTS (
+		SELECT 1 FROM _timescaledb_catalog.hypertable
+		WHERE schema_name = current_schema() AND table_name = 'events'
+	)`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check for events hypertable")
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	var version string
+	err = db.Get(&version, `SELECT extversion FROM pg_extension WHERE extname = 'timescaledb'`)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read timescaledb version")
+	}
+
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse timescaledb version")
+	}
+
+	return &hypertable{majorVersion: major}, nil
+}
+
+// decompressBoundarySQL decompresses any chunks straddling the given time,
+// i.e. those remaining after drop_chunks which are not entirely newer than the
+// time. TimescaleDB 1.x does not allow rows to be deleted from a compressed
+// chunk, and the chunk straddling the retention cutoff has normally already
+// been compressed by the compression policy. The policy compresses the chunk
+// again once we are done.
+const decompressBoundarySQL = `SELECT decompress_chunk(c, TRUE) FROM (
+	SELECT show_chunks('events') AS c
+	EXCEPT
+	SELECT show_chunks('events', newer_than => $1::TIMESTAMPTZ)
+) AS boundary`
+
+// dropChunks deletes events older than the given time from a hypertable. Whole
+// chunks before the timestamp are removed using drop_chunks, with any remaining
+// events in the chunk straddling the timestamp deleted individually, after
+// first decompressing the chunk if compression is enabled.
+func (d *DB) dropChunks(before time.Time) error {
+	var query string
+	if d.hypertable.majorVersion >= 2 {
+		query = `SELECT drop_chunks('events', older_than => $1::TIMESTAMPTZ)`
+	} else {
+		query = `SELECT drop_chunks(older_than => $1::TIMESTAMPTZ, table_name => 'events')`
+	}
+
+	if d.isVerbose() {
+		d.logger.Log(
+			"msg", "dropping old chunks",
+			"sql", query,
+			"before", before.Format(time.RFC3339),
+		)
+	}
+
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		reporting.Report(context.Background(), err, map[string]string{"operation": "deleteData"})
+		return errors.Wrap(err, "failed to start transaction")
+	}
+
+	var count int
+	err = tx.Get(&count, `SELECT COUNT(*) FROM events WHERE recorded_at < $1`, before)
+	if err != nil {
+		tx.Rollback()
+		reporting.Report(context.Background(), err, map[string]string{"operation": "deleteData"})
+		return errors.Wrap(err, "failed to count old events")
+	}
+
+	_, err = tx.Exec(query, before)
+	if err != nil {
+		tx.Rollback()
+		reporting.Report(context.Background(), err, map[string]string{"operation": "deleteData"})
+		return errors.Wrap(err, "failed to drop chunks")
+	}
+
+	compressed, err := compressionEnabled(tx)
+	if err != nil {
+		tx.Rollback()
+		reporting.Report(context.Background(), err, map[string]string{"operation": "deleteData"})
+		return err
+	}
+
+	if compressed {
+		_, err = tx.Exec(decompressBoundarySQL, before)
+		if err != nil {
+			tx.Rollback()
+			reporting.Report(context.Background(), err, map[string]string{"operation": "deleteData"})
+			return errors.Wrap(err, "failed to decompress boundary chunk")
+		}
+	}
+
+	_, err = tx.Exec(`DELETE FROM events WHERE recorded_at < $1`, before)
+	if err != nil {
+		tx.Rollback()
+		reporting.Report(context.Background(), err, map[string]string{"operation": "deleteData"})
+		return errors.Wrap(err, "failed to execute delete query")
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		reporting.Report(context.Background(), err, map[string]string{"operation": "deleteData"})
+		return errors.Wrap(err, "failed to commit deletion")
+	}
+
+	eventsDeleted.WithLabelValues("events").Add(float64(count))
+
+	d.logger.Log("msg", "deleted old events", "count", count, "execute", true)
+
+	return nil
+}
@@ -0,0 +1,258 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+
+	"github.com/DECODEproject/iotstore/pkg/reporting"
+)
+
+// Webhook is a subscription to the events written for a community. Each event
+// is POSTed to the URL, signed using the secret.
+type Webhook struct {
+	ID          int64     `db:"id"`
+	CommunityID string    `db:"community_id"`
+	URL         string    `db:"url"`
+	Secret      string    `db:"secret"`
+	CreatedAt   time.Time `db:"created_at"`
+}
+
+// Delivery is a single event waiting to be delivered to a webhook, along with
+// the URL and secret of the webhook. Attempts includes the current attempt.
+type Delivery struct {
+	ID          int64     `db:"id"`
+	WebhookID   int64     `db:"webhook_id"`
+	URL         string    `db:"url"`
+	Secret      string    `db:"secret"`
+	CommunityID string    `db:"community_id"`
+	DeviceToken string    `db:"device_token"`
+	Data        []byte    `db:"data"`
+	RecordedAt  time.Time `db:"recorded_at"`
+	Attempts    int       `db:"attempts"`
+}
+
+// CreateWebhook subscribes the given URL to the events written for a
+// community, returning the new webhook.
+func (d *DB) CreateWebhook(ctx context.Context, communityID, url, secret string) (*Webhook, error) {
+	sql := `INSERT INTO webhooks (community_id, url, secret)
+		VALUES ($1, $2, $3)
+		RETURNING id, community_id, url, secret, created_at`
+
+	var webhook Webhook
+
+	err := d.DB.GetContext(ctx, &webhook, sql, communityID, url, secret)
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "createWebhook"})
+		return nil, errors.Wrap(err, "failed to create webhook")
+	}
+
+	return &webhook, nil
+}
+
+// Webhooks returns the webhooks subscribed to a community, or to every
+// community if communityID is empty.
+func (d *DB) Webhooks(ctx context.Context, communityID string) ([]*Webhook, error) {
+	sql := `SELECT id, community_id, url, secret, created_at
+		FROM webhooks
+		WHERE $1 = '' OR community_id = $1
+		ORDER BY id`
+
+	webhooks := []*Webhook{}
+
+	err := d.DB.SelectContext(ctx, &webhooks, sql, communityID)
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "listWebhooks"})
+		return nil, errors.Wrap(err, "failed to list webhooks")
+	}
+
+	return webhooks, nil
+}
+
+// DeleteWebhook removes a webhook along with any of its pending deliveries.
+func (d *DB) DeleteWebhook(ctx context.Context, id int64) error {
+	result, err := d.DB.ExecContext(ctx, `DELETE FROM webhooks WHERE id = $1`, id)
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "deleteWebhook"})
+		return errors.Wrap(err, "failed to delete webhook")
+	}
+
+	count, err := result.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "failed to count deleted webhooks")
+	}
+
+	if count == 0 {
+		return errors.Errorf("no webhook with id %d", id)
+	}
+
+	return nil
+}
+
+// ClaimDeliveries returns up to limit deliveries that are due, pushing back
+// their next attempt by the lease so that they are not claimed again while
+// being delivered. Should we crash before completing or failing a delivery it
+// is claimed again once the lease expires, so every event is delivered at
+// least once.
+func (d *DB) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*Delivery, error) {
+	sql := `UPDATE webhook_deliveries d
+		SET attempts = d.attempts + 1,
+			next_attempt_at = NOW() + $2 * INTERVAL '1 millisecond'
+		FROM webhooks w
+		WHERE d.webhook_id = w.id
+		AND d.id IN (
+			SELECT id FROM webhook_deliveries
+			WHERE next_attempt_at <= NOW()
+			ORDER BY next_attempt_at, id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING d.id, d.webhook_id, w.url, w.secret, d.community_id,
+			d.device_token, d.data, d.recorded_at, d.attempts`
+
+	deliveries := []*Delivery{}
+
+	err := d.DB.SelectContext(ctx, &deliveries, sql, limit, lease.Nanoseconds()/int64(time.Millisecond))
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "claimDeliveries"})
+		return nil, errors.Wrap(err, "failed to claim webhook deliveries")
+	}
+
+	return deliveries, nil
+}
+
+// CompleteDelivery removes a delivery once the webhook has accepted it.
+func (d *DB) CompleteDelivery(ctx context.Context, id int64) error {
+	_, err := d.DB.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE id = $1`, id)
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "completeDelivery"})
+		return errors.Wrap(err, "failed to complete webhook delivery")
+	}
+
+	return nil
+}
+
+// FailDelivery records why a delivery failed and schedules it to be attempted
+// again after retryIn. If retryIn is zero we give up on the delivery, which is
+// never attempted again. An abandoned delivery keeps its error so it can be
+// inspected, but its copy of the event's device token and data is discarded,
+// and the row itself is removed by DeleteData along with the event.
+func (d *DB) FailDelivery(ctx context.Context, id int64, retryIn time.Duration, reason string) error {
+	sql := `UPDATE webhook_deliveries
+		SET next_attempt_at = NOW() + $3 * INTERVAL '1 millisecond',
+			last_error = $2
+		WHERE id = $1`
+
+	args := []interface{}{id, reason, retryIn.Nanoseconds() / int64(time.Millisecond)}
+
+	if retryIn <= 0 {
+		sql = `UPDATE webhook_deliveries
+			SET next_attempt_at = NULL,
+				last_error = $2,
+				device_token = '',
+				data = NULL
+			WHERE id = $1`
+
+		args = args[:2]
+	}
+
+	_, err := d.DB.ExecContext(ctx, sql, args...)
+	if err != nil {
+		reporting.Report(ctx, err, map[string]string{"operation": "failDelivery"})
+		return errors.Wrap(err, "failed to record webhook delivery failure")
+	}
+
+	return nil
+}
+
+// enqueueDeliveries adds a delivery to the outbox for each of the batch's
+// events written to a community with webhooks. It is called within the
+// transaction writing the events, so a delivery is queued if and only if its
+// event is committed. Deliveries take their recorded_at from the transaction
+// start time, just as the events do.
+func enqueueDeliveries(ctx context.Context, tx *sql.Tx, batch []*writeRequest) error {
+	communityIDs := make([]string, 0, len(batch))
+	for _, req := range batch {
+		communityIDs = append(communityIDs, req.communityID)
+	}
+
+	// check which communities have webhooks first, so that we only send the
+	// data of events which need to be delivered
+	rows, err := tx.QueryContext(ctx, `SELECT DISTINCT community_id FROM webhooks WHERE community_id = ANY($1)`, pq.Array(communityIDs))
+	if err != nil {
+		return errors.Wrap(err, "failed to query webhooks")
+	}
+	defer rows.Close()
+
+	subscribed := map[string]bool{}
+
+	for rows.Next() {
+		var communityID string
+
+		err = rows.Scan(&communityID)
+		if err != nil {
+			return errors.Wrap(err, "failed to scan webhook community")
+		}
+
+		subscribed[communityID] = true
+	}
+
+	err = rows.Err()
+	if err != nil {
+		return errors.Wrap(err, "failed to query webhooks")
+	}
+
+	if len(subscribed) == 0 {
+		return nil
+	}
+
+	communityIDs = communityIDs[:0]
+	deviceTokens := []string{}
+	data := [][]byte{}
+
+	for _, req := range batch {
+		if subscribed[req.communityID] {
+			communityIDs = append(communityIDs, req.communityID)
+			deviceTokens = append(deviceTokens, req.deviceToken)
+			data = append(data, req.data)
+		}
+	}
+
+	sql := `INSERT INTO webhook_deliveries (webhook_id, community_id, device_token, data)
+		SELECT w.id, e.community_id, e.device_token, e.data
+		FROM unnest($1::text[], $2::text[], $3::bytea[]) AS e (community_id, device_token, data)
+		JOIN webhooks w ON w.community_id = e.community_id`
+
+	_, err = tx.ExecContext(ctx, sql, pq.Array(communityIDs), pq.Array(deviceTokens), pq.Array(data))
+	if err != nil {
+		return errors.Wrap(err, "failed to queue webhook deliveries")
+	}
+
+	return nil
+}
+
+// deleteDeliveries removes webhook deliveries, pending or abandoned, for events
+// recorded before the given timestamp, so that copies of the event data kept in
+// the outbox are purged along with the events themselves. If execute is false
+// the deliveries are only counted. It returns the number of deliveries deleted.
+func (d *DB) deleteDeliveries(before time.Time, execute bool) (int, error) {
+	sql := `WITH deleted AS
+		(DELETE FROM webhook_deliveries WHERE recorded_at < $1 RETURNING *)
+		SELECT COUNT(*) FROM deleted`
+
+	if !execute {
+		sql = `SELECT COUNT(*) FROM webhook_deliveries WHERE recorded_at < $1`
+	}
+
+	var count int
+	err := d.DB.Get(&count, sql, before)
+	if err != nil {
+		reporting.Report(context.Background(), err, map[string]string{"operation": "deleteData"})
+		return 0, errors.Wrap(err, "failed to delete old webhook deliveries")
+	}
+
+	return count, nil
+}
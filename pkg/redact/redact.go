@@ -0,0 +1,271 @@
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// None leaves values as they are.
+	None = "none"
+
+	// Truncate keeps only the first few characters of values.
+	Truncate = "truncate"
+
+	// Hash replaces values with a short keyed hash, so that lines referring to
+	// the same value can still be correlated.
+	Hash = "hash"
+
+	// Omit replaces values entirely.
+	Omit = "omit"
+
+	// truncateLength is the number of characters kept by Truncate.
+	truncateLength = 4
+
+	// hashLength is the number of hex characters of the hash kept by Hash.
+	hashLength = 12
+
+	// omitted is the placeholder written in place of omitted values.
+	omitted = "[redacted]"
+
+	// saltLength is the number of bytes in a randomly generated salt.
+	saltLength = 32
+)
+
+// Modes is the list of redaction modes we support.
+var Modes = []string{None, Truncate, Hash, Omit}
+
+// Config is a struct used to pass configuration into NewPolicy.
+type Config struct {
+	// DeviceTokens is the mode applied to device tokens, and to the ACME
+	// challenge tokens in certificate cache keys.
+	DeviceTokens string
+
+	// CommunityIDs is the mode applied to community IDs.
+	CommunityIDs string
+
+	// Payloads is the mode applied to encrypted event payloads.
+	Payloads string
+
+	// Salt is the key used when hashing values. Without a secret salt a short
+	// identifier can be recovered from its hash by trying every possibility,
+	// so if none is given a random salt is generated for the process.
+	Salt string
+}
+
+// randomSalt is the salt used when none is configured. It is generated once
+// per process, so hashes may be correlated within but not across processes.
+var randomSalt = newRandomSalt()
+
+// newRandomSalt returns a new random salt.
+func newRandomSalt() []byte {
+	salt := make([]byte, saltLength)
+	rand.Read(salt)
+	return salt
+}
+
+// Policy redacts sensitive values before they are written to logs, reported
+// to Sentry or attached to trace spans.
+type Policy struct {
+	deviceTokens string
+	communityIDs string
+	payloads     string
+	salt         []byte
+	randomSalt   bool
+}
+
+// NewPolicy returns a new Policy, returning an error if any mode is not known.
+// Empty modes default to None, which leaves values as they are.
+func NewPolicy(config *Config) (*Policy, error) {
+	deviceTokens, err := parseMode(config.DeviceTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	communityIDs, err := parseMode(config.CommunityIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	payloads, err := parseMode(config.Payloads)
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{
+		deviceTokens: deviceTokens,
+		communityIDs: communityIDs,
+		payloads:     payloads,
+		salt:         []byte(config.Salt),
+	}
+
+	if config.Salt == "" && (deviceTokens == Hash || communityIDs == Hash || payloads == Hash) {
+		policy.salt = randomSalt
+		policy.randomSalt = true
+	}
+
+	return policy, nil
+}
+
+// RandomSalt returns true if values are hashed with a random salt as none was
+// configured, in which case hashes differ each time the process is started.
+func (p *Policy) RandomSalt() bool {
+	return p.randomSalt
+}
+
+// parseMode returns the given mode, or an error if it is not one we support.
+func parseMode(mode string) (string, error) {
+	mode = strings.ToLower(mode)
+
+	switch mode {
+	case "":
+		return None, nil
+	case None, Truncate, Hash, Omit:
+		return mode, nil
+	default:
+		return "", errors.Errorf("unknown redaction mode: %s", mode)
+	}
+}
+
+// DeviceToken returns the device token redacted according to the policy.
+func (p *Policy) DeviceToken(token string) string {
+	return p.apply(p.deviceTokens, token)
+}
+
+// CommunityID returns the community ID redacted according to the policy.
+func (p *Policy) CommunityID(communityID string) string {
+	return p.apply(p.communityIDs, communityID)
+}
+
+// Payload returns the encrypted payload redacted according to the policy.
+func (p *Policy) Payload(data []byte) string {
+	return p.apply(p.payloads, string(data))
+}
+
+// Path returns the path of a URL or MQTT topic with any segment following a
+// community or device segment redacted as a community ID or device token
+// respectively, e.g. /v1/communities/:id/events or community/:id/device/:token.
+func (p *Policy) Path(path string) string {
+	segments := strings.Split(path, "/")
+
+	for i := 1; i < len(segments); i++ {
+		switch segments[i-1] {
+		case "community", "communities":
+			segments[i] = p.CommunityID(segments[i])
+		case "device", "devices":
+			segments[i] = p.DeviceToken(segments[i])
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// CertificateKey returns the autocert cache key with the token of any http-01
+// challenge redacted as a device token. Other keys are domain names.
+func (p *Policy) CertificateKey(key string) string {
+	if strings.HasSuffix(key, "+http-01") {
+		return p.DeviceToken(strings.TrimSuffix(key, "+http-01")) + "+http-01"
+	}
+
+	return key
+}
+
+// SegmentKey returns the cold storage segment key with the community ID it
+// starts with redacted.
+func (p *Policy) SegmentKey(key string) string {
+	i := strings.Index(key, "/")
+	if i == -1 {
+		return key
+	}
+
+	return p.CommunityID(key[:i]) + key[i:]
+}
+
+// apply redacts the value according to the given mode. Empty values are left
+// as they are as they reveal nothing.
+func (p *Policy) apply(mode, value string) string {
+	if value == "" {
+		return value
+	}
+
+	switch mode {
+	case Truncate:
+		if len(value) <= truncateLength {
+			return strings.Repeat("*", len(value))
+		}
+		return value[:truncateLength] + "..."
+	case Hash:
+		mac := hmac.New(sha256.New, p.salt)
+		mac.Write([]byte(value))
+		return "h:" + hex.EncodeToString(mac.Sum(nil))[:hashLength]
+	case Omit:
+		return omitted
+	default:
+		return value
+	}
+}
+
+// noRedaction is the policy used until another is set, leaving values as they
+// are.
+var noRedaction = &Policy{deviceTokens: None, communityIDs: None, payloads: None}
+
+// current holds the policy applied by the package level functions.
+var current atomic.Value
+
+// SetPolicy sets the policy applied by the package level functions, with nil
+// restoring the default of no redaction.
+func SetPolicy(p *Policy) {
+	if p == nil {
+		p = noRedaction
+	}
+	current.Store(p)
+}
+
+// CurrentPolicy returns the policy applied by the package level functions.
+func CurrentPolicy() *Policy {
+	if p, ok := current.Load().(*Policy); ok {
+		return p
+	}
+	return noRedaction
+}
+
+// DeviceToken returns the device token redacted according to the current
+// policy.
+func DeviceToken(token string) string {
+	return CurrentPolicy().DeviceToken(token)
+}
+
+// CommunityID returns the community ID redacted according to the current
+// policy.
+func CommunityID(communityID string) string {
+	return CurrentPolicy().CommunityID(communityID)
+}
+
+// Payload returns the encrypted payload redacted according to the current
+// policy.
+func Payload(data []byte) string {
+	return CurrentPolicy().Payload(data)
+}
+
+// Path returns the path redacted according to the current policy.
+func Path(path string) string {
+	return CurrentPolicy().Path(path)
+}
+
+// CertificateKey returns the autocert cache key redacted according to the
+// current policy.
+func CertificateKey(key string) string {
+	return CurrentPolicy().CertificateKey(key)
+}
+
+// SegmentKey returns the cold storage segment key redacted according to the
+// current policy.
+func SegmentKey(key string) string {
+	return CurrentPolicy().SegmentKey(key)
+}
@@ -0,0 +1,51 @@
+package redact
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// secretPlaceholder is written in place of passwords and other secrets.
+const secretPlaceholder = "xxxxx"
+
+var (
+	// secretParams are the names of URL query parameters whose values are
+	// secrets.
+	secretParams = []string{"password", "sslpassword", "secret", "token"}
+
+	// dsnPassword matches the password in a key=value Postgres connection
+	// string, which may be quoted.
+	dsnPassword = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+)
+
+// Secrets returns the value with any password, or other secret, in a URL or
+// Postgres connection string replaced, e.g. so that configuration can be
+// displayed. Unlike the other functions it does not depend on the redaction
+// policy, as secrets are always redacted.
+func Secrets(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return dsnPassword.ReplaceAllString(value, "${1}"+secretPlaceholder)
+	}
+
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), secretPlaceholder)
+		}
+	}
+
+	if u.RawQuery != "" {
+		query := u.Query()
+		for key := range query {
+			for _, param := range secretParams {
+				if strings.EqualFold(key, param) {
+					query.Set(key, secretPlaceholder)
+				}
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	return u.String()
+}
@@ -0,0 +1,148 @@
+package reporting
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxFingerprints is the maximum number of distinct errors we remember in
+// order to suppress duplicates. Once reached, errors not seen within the
+// window are forgotten.
+const maxFingerprints = 1000
+
+// seen records when an error was last reported, and how many duplicates of it
+// have since been suppressed.
+type seen struct {
+	reportedAt time.Time
+	suppressed int
+}
+
+// LimitedReporter wraps a Reporter so that an error seen again within the
+// window of it last being reported is counted rather than reported, and at
+// most limit errors are reported in any window. During an outage of the
+// database every request fails with the same error, which would otherwise
+// flood the reporter.
+type LimitedReporter struct {
+	reporter Reporter
+	limit    int
+	window   time.Duration
+	now      func() time.Time
+
+	mu     sync.Mutex
+	seen   map[string]*seen
+	tokens float64
+	filled time.Time
+}
+
+// NewLimitedReporter returns a new LimitedReporter wrapping the given Reporter.
+func NewLimitedReporter(reporter Reporter, limit int, window time.Duration) *LimitedReporter {
+	return &LimitedReporter{
+		reporter: reporter,
+		limit:    limit,
+		window:   window,
+		now:      time.Now,
+		seen:     map[string]*seen{},
+		tokens:   float64(limit),
+	}
+}
+
+// Report passes the event on to the wrapped Reporter unless it is a duplicate
+// or the limit has been reached. When an error is reported again after the
+// window the number of duplicates suppressed in the meantime is included.
+func (l *LimitedReporter) Report(event *Event) {
+	fingerprint := event.Tags["operation"] + ": " + errors.Cause(event.Err).Error()
+
+	l.mu.Lock()
+
+	now := l.now()
+
+	s, ok := l.seen[fingerprint]
+	if ok && now.Sub(s.reportedAt) < l.window {
+		s.suppressed++
+		l.mu.Unlock()
+
+		reports.WithLabelValues("duplicate").Inc()
+		return
+	}
+
+	if !l.take(now) {
+		l.mu.Unlock()
+
+		reports.WithLabelValues("rate_limited").Inc()
+		return
+	}
+
+	if ok {
+		event.Suppressed = s.suppressed
+	} else {
+		l.forget(now)
+	}
+
+	l.seen[fingerprint] = &seen{reportedAt: now}
+
+	l.mu.Unlock()
+
+	reports.WithLabelValues("reported").Inc()
+	l.reporter.Report(event)
+}
+
+// take returns true if an event may be reported, refilling the bucket of
+// tokens at a rate of limit per window. It must be called with the lock held.
+func (l *LimitedReporter) take(now time.Time) bool {
+	if !l.filled.IsZero() {
+		l.tokens += float64(l.limit) * float64(now.Sub(l.filled)) / float64(l.window)
+		if l.tokens > float64(l.limit) {
+			l.tokens = float64(l.limit)
+		}
+	}
+	l.filled = now
+
+	if l.tokens < 1 {
+		return false
+	}
+
+	l.tokens--
+	return true
+}
+
+// forget removes the errors not seen within the window once we remember too
+// many. It must be called with the lock held.
+func (l *LimitedReporter) forget(now time.Time) {
+	if len(l.seen) < maxFingerprints {
+		return
+	}
+
+	for fingerprint, s := range l.seen {
+		if now.Sub(s.reportedAt) >= l.window {
+			delete(l.seen, fingerprint)
+		}
+	}
+}
+
+// inherit takes the errors remembered and the tokens remaining from the given
+// LimitedReporter, so that replacing one LimitedReporter with another neither
+// reports duplicates again nor resets the limit.
+func (l *LimitedReporter) inherit(previous *LimitedReporter) {
+	previous.mu.Lock()
+	defer previous.mu.Unlock()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for fingerprint, s := range previous.seen {
+		l.seen[fingerprint] = &seen{reportedAt: s.reportedAt, suppressed: s.suppressed}
+	}
+
+	l.tokens = previous.tokens
+	if l.tokens > float64(l.limit) {
+		l.tokens = float64(l.limit)
+	}
+	l.filled = previous.filled
+}
+
+// Flush flushes the wrapped Reporter.
+func (l *LimitedReporter) Flush(timeout time.Duration) bool {
+	return l.reporter.Flush(timeout)
+}
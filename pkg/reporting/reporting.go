@@ -0,0 +1,237 @@
+package reporting
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/DECODEproject/iotcommon/middleware"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	registry "github.com/thingful/retryable-registry-prometheus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// Sentry reports errors to Sentry, configured via the SENTRY_DSN
+	// environment variable.
+	Sentry = "sentry"
+
+	// Log writes reported errors to the log.
+	Log = "log"
+
+	// None discards reported errors.
+	None = "none"
+
+	// DefaultLimit is the number of errors reported per minute if not otherwise
+	// configured.
+	DefaultLimit = 10
+)
+
+var (
+	// Reporters is the list of reporters we support.
+	Reporters = []string{Sentry, Log, None}
+
+	// reports is a counter recording the errors reported, and those suppressed
+	// as duplicates or because too many errors were reported.
+	reports = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "error_reports_total",
+			Help:      "Number of errors reported by result",
+		}, []string{"result"},
+	)
+)
+
+func init() {
+	registry.MustRegister(reports)
+}
+
+// Event is an error to be reported along with its context.
+type Event struct {
+	// Err is the error being reported.
+	Err error
+
+	// Tags are the key value pairs describing the context of the error, which
+	// always include the operation that failed, and the request ID and trace
+	// ID if the error occurred while handling a request.
+	Tags map[string]string
+
+	// Suppressed is the number of duplicates of the error suppressed since it
+	// was last reported.
+	Suppressed int
+}
+
+// Reporter is the interface implemented by anything to which we report
+// errors. Implementations must be safe for concurrent use.
+type Reporter interface {
+	// Report reports the event, which must not block for long.
+	Report(event *Event)
+
+	// Flush waits for reported events to be sent, returning false if they were
+	// not sent within the timeout.
+	Flush(timeout time.Duration) bool
+}
+
+// Config is a struct used to pass configuration into New.
+type Config struct {
+	// Reporter is one of Sentry, Log or None, defaulting to Sentry.
+	Reporter string
+
+	// Limit is the maximum number of errors reported per minute, defaulting to
+	// DefaultLimit.
+	Limit int
+
+	// Release is the version of the binary reported to Sentry.
+	Release string
+
+	// Tags are added to every error reported to Sentry.
+	Tags map[string]string
+}
+
+// New returns a new Reporter of the configured type, wrapped so that
+// duplicate errors are suppressed and at most the configured number of errors
+// are reported each minute. The logger is used by the Log reporter.
+func New(config *Config, logger kitlog.Logger) (Reporter, error) {
+	var reporter Reporter
+
+	switch strings.ToLower(config.Reporter) {
+	case "", Sentry:
+		r, err := NewSentryReporter(config.Release, config.Tags)
+		if err != nil {
+			return nil, err
+		}
+		reporter = r
+	case Log:
+		reporter = NewLogReporter(logger)
+	case None:
+		return NopReporter{}, nil
+	default:
+		return nil, errors.Errorf("unknown error reporter: %s", config.Reporter)
+	}
+
+	limit := config.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+
+	return NewLimitedReporter(reporter, limit, time.Minute), nil
+}
+
+// NopReporter is a Reporter which discards everything reported to it.
+type NopReporter struct{}
+
+// Report discards the event.
+func (NopReporter) Report(*Event) {}
+
+// Flush returns immediately as there is nothing to send.
+func (NopReporter) Flush(time.Duration) bool { return true }
+
+// LogReporter is a Reporter which writes each event to a logger at the error
+// level, useful where there is no Sentry instance to report to.
+type LogReporter struct {
+	logger kitlog.Logger
+}
+
+// NewLogReporter returns a new LogReporter writing to the given logger.
+func NewLogReporter(logger kitlog.Logger) *LogReporter {
+	return &LogReporter{
+		logger: kitlog.With(logger, "module", "reporting"),
+	}
+}
+
+// Report writes the event to the logger.
+func (l *LogReporter) Report(event *Event) {
+	keyvals := []interface{}{"msg", "error reported", "err", event.Err}
+
+	for k, v := range event.Tags {
+		keyvals = append(keyvals, k, v)
+	}
+
+	if event.Suppressed > 0 {
+		keyvals = append(keyvals, "suppressed", event.Suppressed)
+	}
+
+	level.Error(l.logger).Log(keyvals...)
+}
+
+// Flush returns immediately as events are written synchronously.
+func (l *LogReporter) Flush(time.Duration) bool { return true }
+
+// current holds the Reporter used by the package level functions.
+var current atomic.Value
+
+// reporterHolder wraps the reporter so that atomic.Value always stores values
+// of the same concrete type.
+type reporterHolder struct {
+	reporter Reporter
+}
+
+// SetReporter sets the Reporter used by the package level functions, with nil
+// restoring the default of discarding errors.
+func SetReporter(r Reporter) {
+	if r == nil {
+		r = NopReporter{}
+	}
+	current.Store(reporterHolder{r})
+}
+
+// SwapReporter replaces the Reporter used by the package level functions, as
+// when our configuration is reloaded. If both are LimitedReporters the errors
+// remembered and the limit carry over to the new Reporter, so that reloading
+// does not reset the limit. Errors already reported to the previous Reporter
+// are then flushed, waiting up to the timeout.
+func SwapReporter(r Reporter, timeout time.Duration) bool {
+	previous := currentReporter()
+
+	if l, ok := r.(*LimitedReporter); ok {
+		if p, ok := previous.(*LimitedReporter); ok {
+			l.inherit(p)
+		}
+	}
+
+	SetReporter(r)
+
+	return previous.Flush(timeout)
+}
+
+// currentReporter returns the Reporter used by the package level functions.
+func currentReporter() Reporter {
+	if h, ok := current.Load().(reporterHolder); ok {
+		return h.reporter
+	}
+	return NopReporter{}
+}
+
+// Report reports the error to the current Reporter. The tags should include
+// the operation that failed. The request ID and trace ID are taken from the
+// context if present.
+func Report(ctx context.Context, err error, tags map[string]string) {
+	event := &Event{
+		Err:  err,
+		Tags: map[string]string{},
+	}
+
+	for k, v := range tags {
+		event.Tags[k] = v
+	}
+
+	if rid, ok := ctx.Value(middleware.RequestCtxKey).(string); ok && rid != "" {
+		event.Tags["requestId"] = rid
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		event.Tags["traceId"] = sc.TraceID().String()
+	}
+
+	currentReporter().Report(event)
+}
+
+// Flush waits for errors reported to the current Reporter to be sent.
+func Flush(timeout time.Duration) bool {
+	return currentReporter().Flush(timeout)
+}
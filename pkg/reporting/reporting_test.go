@@ -0,0 +1,150 @@
+package reporting_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DECODEproject/iotcommon/middleware"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotstore/pkg/reporting"
+)
+
+type recordingReporter struct {
+	sync.Mutex
+	events  []*reporting.Event
+	flushed bool
+}
+
+func (r *recordingReporter) Report(event *reporting.Event) {
+	r.Lock()
+	defer r.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *recordingReporter) Flush(time.Duration) bool {
+	r.Lock()
+	defer r.Unlock()
+	r.flushed = true
+	return true
+}
+
+func TestLimitedReporterDuplicates(t *testing.T) {
+	recorder := &recordingReporter{}
+	reporter := reporting.NewLimitedReporter(recorder, 100, 100*time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		reporter.Report(&reporting.Event{
+			Err:  errors.Wrap(errors.New("connection refused"), "failed to write"),
+			Tags: map[string]string{"operation": "writeData"},
+		})
+	}
+
+	// the same error from another operation is not a duplicate
+	reporter.Report(&reporting.Event{
+		Err:  errors.New("connection refused"),
+		Tags: map[string]string{"operation": "readData"},
+	})
+
+	assert.Len(t, recorder.events, 2)
+	assert.Equal(t, 0, recorder.events[0].Suppressed)
+
+	time.Sleep(150 * time.Millisecond)
+
+	reporter.Report(&reporting.Event{
+		Err:  errors.New("connection refused"),
+		Tags: map[string]string{"operation": "writeData"},
+	})
+
+	assert.Len(t, recorder.events, 3)
+	assert.Equal(t, 4, recorder.events[2].Suppressed)
+}
+
+func TestLimitedReporterRateLimit(t *testing.T) {
+	recorder := &recordingReporter{}
+	reporter := reporting.NewLimitedReporter(recorder, 3, time.Hour)
+
+	for _, msg := range []string{"one", "two", "three", "four", "five"} {
+		reporter.Report(&reporting.Event{
+			Err:  errors.New(msg),
+			Tags: map[string]string{"operation": "writeData"},
+		})
+	}
+
+	assert.Len(t, recorder.events, 3)
+	assert.Equal(t, "three", recorder.events[2].Err.Error())
+}
+
+func TestSwapReporter(t *testing.T) {
+	first := &recordingReporter{}
+	second := &recordingReporter{}
+
+	reporting.SetReporter(reporting.NewLimitedReporter(first, 2, time.Hour))
+	defer reporting.SetReporter(nil)
+
+	reporting.Report(context.Background(), errors.New("one"), map[string]string{"operation": "writeData"})
+
+	assert.True(t, reporting.SwapReporter(reporting.NewLimitedReporter(second, 2, time.Hour), time.Second))
+	assert.True(t, first.flushed)
+
+	// the error already reported is still a duplicate, and only one of the two
+	// errors allowed remains after reloading
+	for _, msg := range []string{"one", "two", "three"} {
+		reporting.Report(context.Background(), errors.New(msg), map[string]string{"operation": "writeData"})
+	}
+
+	assert.Len(t, first.events, 1)
+	assert.Len(t, second.events, 1)
+	assert.Equal(t, "two", second.events[0].Err.Error())
+}
+
+func TestReport(t *testing.T) {
+	recorder := &recordingReporter{}
+
+	reporting.SetReporter(recorder)
+	defer reporting.SetReporter(nil)
+
+	handler := middleware.RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reporting.Report(r.Context(), errors.New("boom"), map[string]string{"operation": "readData"})
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(middleware.RequestIDHeader, "request-1")
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	reporting.Report(context.Background(), errors.New("bang"), map[string]string{"operation": "writeData"})
+
+	assert.Len(t, recorder.events, 2)
+	assert.Equal(t, map[string]string{"operation": "readData", "requestId": "request-1"}, recorder.events[0].Tags)
+	assert.Equal(t, map[string]string{"operation": "writeData"}, recorder.events[1].Tags)
+}
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+
+	reporter, err := reporting.New(&reporting.Config{Reporter: reporting.Log}, kitlog.NewLogfmtLogger(&buf))
+	assert.Nil(t, err)
+
+	reporter.Report(&reporting.Event{
+		Err:  errors.New("boom"),
+		Tags: map[string]string{"operation": "readData"},
+	})
+
+	assert.Contains(t, buf.String(), "level=error")
+	assert.Contains(t, buf.String(), "err=boom")
+	assert.Contains(t, buf.String(), "operation=readData")
+
+	_, err = reporting.New(&reporting.Config{Reporter: reporting.Sentry}, kitlog.NewNopLogger())
+	assert.Nil(t, err)
+
+	_, err = reporting.New(&reporting.Config{Reporter: "rollbar"}, kitlog.NewNopLogger())
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,53 @@
+package reporting
+
+import (
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/pkg/errors"
+)
+
+// SentryReporter is a Reporter which sends events to Sentry. The DSN is read
+// from the SENTRY_DSN environment variable, and if it is not set events are
+// discarded.
+type SentryReporter struct {
+	client *sentry.Client
+	scope  *sentry.Scope
+}
+
+// NewSentryReporter returns a new SentryReporter, tagging every event with the
+// given release and tags.
+func NewSentryReporter(release string, tags map[string]string) (*SentryReporter, error) {
+	client, err := sentry.NewClient(sentry.ClientOptions{
+		Release: release,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create sentry client")
+	}
+
+	scope := sentry.NewScope()
+	scope.SetTags(tags)
+
+	return &SentryReporter{
+		client: client,
+		scope:  scope,
+	}, nil
+}
+
+// Report sends the event to Sentry in the background. Each event is given its
+// own copy of the scope so that concurrent events don't share tags.
+func (s *SentryReporter) Report(event *Event) {
+	scope := s.scope.Clone()
+	scope.SetTags(event.Tags)
+
+	if event.Suppressed > 0 {
+		scope.SetExtra("suppressed", event.Suppressed)
+	}
+
+	s.client.CaptureException(event.Err, &sentry.EventHint{OriginalException: event.Err}, scope)
+}
+
+// Flush waits for the events reported to be sent to Sentry.
+func (s *SentryReporter) Flush(timeout time.Duration) bool {
+	return s.client.Flush(timeout)
+}
@@ -3,16 +3,24 @@ package rpc
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
 	"time"
 
-	raven "github.com/getsentry/raven-go"
 	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/golang/protobuf/ptypes"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	registry "github.com/thingful/retryable-registry-prometheus"
 	datastore "github.com/thingful/twirp-datastore-go"
 	"github.com/twitchtv/twirp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"github.com/DECODEproject/iotstore/pkg/logger"
 	"github.com/DECODEproject/iotstore/pkg/postgres"
+	"github.com/DECODEproject/iotstore/pkg/redact"
+	"github.com/DECODEproject/iotstore/pkg/reporting"
 )
 
 const (
@@ -25,32 +33,87 @@ const (
 	MaxPageSize = 1000
 )
 
+var (
+	// cancellations is a counter recording requests that were abandoned because
+	// the client went away or the request timed out, which we count separately
+	// from other errors.
+	cancellations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "rpc_cancellations_total",
+			Help:      "Number of RPC requests cancelled or exceeding their deadline",
+		}, []string{"method", "reason"},
+	)
+)
+
+func init() {
+	registry.MustRegister(cancellations)
+}
+
+// Config is a struct used to pass configuration into our Datastore instance.
+type Config struct {
+	// Verbose causes the component to output more verbose log information.
+	Verbose bool
+
+	// WriteTimeout is the maximum time we allow for a WriteData request to be
+	// handled, zero means no timeout.
+	WriteTimeout time.Duration
+
+	// ReadTimeout is the maximum time we allow for a ReadData request to be
+	// handled, zero means no timeout.
+	ReadTimeout time.Duration
+}
+
 // Datastore is our implementation of the generated twirp interface for the
 // encrypted datastore.
 type Datastore struct {
-	DB      *postgres.DB
-	logger  kitlog.Logger
-	verbose bool
+	DB           *postgres.DB
+	logger       kitlog.Logger
+	verbose      int32
+	writeTimeout time.Duration
+	readTimeout  time.Duration
 }
 
 // ensure we adhere to the interface
 var _ datastore.Datastore = &Datastore{}
 
 // NewDatastore returns a newly instantiated Datastore instance. It takes as
-// parameters a DB connection string and a logger. The connection string is
-// passed down to the postgres package where it is used to connect.
-func NewDatastore(db *postgres.DB, verbose bool, logger kitlog.Logger) *Datastore {
+// parameters a DB instance, a Config struct and a logger.
+func NewDatastore(db *postgres.DB, config *Config, logger kitlog.Logger) *Datastore {
 	logger = kitlog.With(logger, "module", "rpc")
 
 	ds := &Datastore{
-		DB:      db,
-		logger:  logger,
-		verbose: verbose,
+		DB:           db,
+		logger:       logger,
+		writeTimeout: config.WriteTimeout,
+		readTimeout:  config.ReadTimeout,
+	}
+
+	if config.Verbose {
+		ds.verbose = 1
 	}
 
 	return ds
 }
 
+// SetVerbose switches verbose logging on or off for the datastore and its DB,
+// and may be called while the datastore is in use.
+func (d *Datastore) SetVerbose(verbose bool) {
+	var v int32
+	if verbose {
+		v = 1
+	}
+	atomic.StoreInt32(&d.verbose, v)
+
+	d.DB.SetVerbose(verbose)
+}
+
+// Verbose returns true if verbose logging is switched on.
+func (d *Datastore) Verbose() bool {
+	return atomic.LoadInt32(&d.verbose) == 1
+}
+
 // Start starts all child components (here just the postgres DB).
 func (d *Datastore) Start() error {
 	d.logger.Log("msg", "starting datastore")
@@ -78,19 +141,22 @@ func (d *Datastore) WriteData(ctx context.Context, req *datastore.WriteRequest)
 		return nil, twirp.RequiredArgumentError("device_token")
 	}
 
-	if d.verbose {
-		d.logger.Log(
-			"communityId", req.CommunityId,
-			"deviceToken", req.DeviceToken,
+	logger := d.requestLogger(ctx, req.CommunityId)
+
+	if d.Verbose() {
+		logger.Log(
+			"deviceToken", redact.DeviceToken(req.DeviceToken),
 			"msg", "WriteData",
-			"encodedPayload", string(req.Data),
+			"encodedPayload", redact.Payload(req.Data),
 		)
 	}
 
-	err := d.DB.WriteData(req.CommunityId, req.Data, req.DeviceToken)
+	ctx, cancel := withTimeout(ctx, d.writeTimeout)
+	defer cancel()
+
+	err := d.DB.WriteData(ctx, req.CommunityId, req.Data, req.DeviceToken)
 	if err != nil {
-		raven.CaptureError(err, map[string]string{"operation": "writeData"})
-		return nil, twirp.InternalErrorWith(errors.Cause(err))
+		return nil, handleError(ctx, logger, err, "writeData", req.CommunityId)
 	}
 
 	return &datastore.WriteResponse{}, nil
@@ -117,20 +183,23 @@ func (d *Datastore) ReadData(ctx context.Context, req *datastore.ReadRequest) (*
 		return nil, err
 	}
 
-	if d.verbose {
-		d.logger.Log(
+	logger := d.requestLogger(ctx, req.CommunityId)
+
+	if d.Verbose() {
+		logger.Log(
 			"msg", "ReadData",
-			"communityId", req.CommunityId,
 			"pageSize", req.PageSize,
 			"startTime", startTime,
 			"endTime", endTime,
 		)
 	}
 
-	page, err := d.DB.ReadData(req.CommunityId, uint64(req.PageSize), startTime, endTime, req.PageCursor)
+	ctx, cancel := withTimeout(ctx, d.readTimeout)
+	defer cancel()
+
+	page, err := d.DB.ReadData(ctx, req.CommunityId, uint64(req.PageSize), startTime, endTime, req.PageCursor)
 	if err != nil {
-		raven.CaptureError(err, map[string]string{"operation": "readData"})
-		return nil, twirp.InternalErrorWith(errors.Cause(err))
+		return nil, handleError(ctx, logger, err, "readData", req.CommunityId)
 	}
 
 	events := []*datastore.EncryptedEvent{}
@@ -138,7 +207,7 @@ func (d *Datastore) ReadData(ctx context.Context, req *datastore.ReadRequest) (*
 	for _, e := range page.Events {
 		event, err := buildEncryptedEvent(e)
 		if err != nil {
-			raven.CaptureError(err, map[string]string{"operation": "readData"})
+			reporting.Report(ctx, err, map[string]string{"operation": "readData"})
 			return nil, twirp.InternalErrorWith(errors.Cause(err))
 		}
 
@@ -153,6 +222,160 @@ func (d *Datastore) ReadData(ctx context.Context, req *datastore.ReadRequest) (*
 	}, nil
 }
 
+// StreamData sends every event matching the search parameters in the incoming
+// datastore.ReadRequest to the given function in order. Rather than returning a
+// single page, the whole window is read from the database a page at a time, so
+// the page size of the request controls only how many events are fetched per
+// query. The read timeout is applied to each page, not to the stream as a whole,
+// as a long window may take arbitrarily long to send to a slow client.
+func (d *Datastore) StreamData(ctx context.Context, req *datastore.ReadRequest, send func(*datastore.EncryptedEvent) error) error {
+	if req.CommunityId == "" {
+		return twirp.RequiredArgumentError("community_id")
+	}
+
+	if req.PageSize == 0 {
+		req.PageSize = MaxPageSize
+	}
+
+	if req.PageSize > MaxPageSize {
+		return twirp.InvalidArgumentError("page_size", fmt.Sprintf("must be between 1 and %v", MaxPageSize))
+	}
+
+	startTime, endTime, err := extractTimes(req)
+	if err != nil {
+		return err
+	}
+
+	logger := d.requestLogger(ctx, req.CommunityId)
+
+	if d.Verbose() {
+		logger.Log(
+			"msg", "StreamData",
+			"pageSize", req.PageSize,
+			"startTime", startTime,
+			"endTime", endTime,
+		)
+	}
+
+	cursor := req.PageCursor
+
+	for {
+		page, err := d.readPage(ctx, logger, req.CommunityId, uint64(req.PageSize), startTime, endTime, cursor)
+		if err != nil {
+			return err
+		}
+
+		for _, e := range page.Events {
+			event, err := buildEncryptedEvent(e)
+			if err != nil {
+				reporting.Report(ctx, err, map[string]string{"operation": "streamData"})
+				return twirp.InternalErrorWith(errors.Cause(err))
+			}
+
+			err = send(event)
+			if err != nil {
+				return err
+			}
+		}
+
+		if page.NextPageCursor == "" {
+			return nil
+		}
+
+		cursor = page.NextPageCursor
+	}
+}
+
+// readPage reads a single page of events for StreamData, applying the read
+// timeout to just this query.
+func (d *Datastore) readPage(ctx context.Context, logger kitlog.Logger, communityID string, pageSize uint64, startTime, endTime time.Time, cursor string) (*postgres.Page, error) {
+	ctx, cancel := withTimeout(ctx, d.readTimeout)
+	defer cancel()
+
+	page, err := d.DB.ReadData(ctx, communityID, pageSize, startTime, endTime, cursor)
+	if err != nil {
+		return nil, handleError(ctx, logger, err, "streamData", communityID)
+	}
+
+	return page, nil
+}
+
+// StaleDevices returns a list of devices for the given community from which we
+// have not received any events for longer than the given threshold. This
+// method is not part of the generated twirp interface, rather it is exposed by
+// the server as a simple JSON endpoint so that the registration service is able
+// to notify owners of devices that have gone silent.
+func (d *Datastore) StaleDevices(ctx context.Context, communityID string, threshold time.Duration) ([]*postgres.Device, error) {
+	if communityID == "" {
+		return nil, twirp.RequiredArgumentError("community_id")
+	}
+
+	if threshold <= 0 {
+		return nil, twirp.InvalidArgumentError("threshold", "must be a positive duration")
+	}
+
+	logger := d.requestLogger(ctx, communityID)
+
+	if d.Verbose() {
+		logger.Log(
+			"msg", "StaleDevices",
+			"threshold", threshold,
+		)
+	}
+
+	ctx, cancel := withTimeout(ctx, d.readTimeout)
+	defer cancel()
+
+	devices, err := d.DB.StaleDevices(ctx, communityID, threshold)
+	if err != nil {
+		return nil, handleError(ctx, logger, err, "staleDevices", communityID)
+	}
+
+	return devices, nil
+}
+
+// requestLogger returns a logger that adds the request ID and the community to
+// every line logged while handling a request. The community is also recorded
+// on the span tracing the request. In both cases it is redacted according to
+// the redaction policy.
+func (d *Datastore) requestLogger(ctx context.Context, communityID string) kitlog.Logger {
+	communityID = redact.CommunityID(communityID)
+
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("iotstore.community_id", communityID))
+
+	return kitlog.With(logger.FromContext(ctx, d.logger), "communityId", communityID)
+}
+
+// withTimeout returns a context with the given timeout applied if the timeout
+// is non-zero, otherwise the context is returned unchanged.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// handleError converts an error returned from the storage layer into a twirp
+// error. If the request context was cancelled or its deadline was exceeded we
+// return the corresponding twirp error and count it in our cancellations
+// metric; any other error is logged, reported to Sentry tagged with the
+// redacted community, and returned as an internal error.
+func handleError(ctx context.Context, logger kitlog.Logger, err error, operation, communityID string) error {
+	switch ctx.Err() {
+	case context.Canceled:
+		cancellations.WithLabelValues(operation, "canceled").Inc()
+		return twirp.NewError(twirp.Canceled, "request was cancelled")
+	case context.DeadlineExceeded:
+		cancellations.WithLabelValues(operation, "deadline_exceeded").Inc()
+		return twirp.NewError(twirp.DeadlineExceeded, "request deadline exceeded")
+	}
+
+	level.Error(logger).Log("msg", "request failed", "operation", operation, "err", err)
+
+	reporting.Report(ctx, err, map[string]string{"operation": operation, "communityId": redact.CommunityID(communityID)})
+	return twirp.InternalErrorWith(errors.Cause(err))
+}
+
 // buildEncryptedEvent is a helper function that converts our internal event
 // type read from the database into an external datastore.EncryptedEvent.
 func buildEncryptedEvent(e *postgres.Event) (*datastore.EncryptedEvent, error) {
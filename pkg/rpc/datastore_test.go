@@ -37,8 +37,8 @@ func (s *DatastoreSuite) SetupTest() {
 		s.T().Fatalf("Failed to close DB: %v", err)
 	}
 
-	db := postgres.NewDB(connStr, true, logger)
-	s.ds = rpc.NewDatastore(db, true, logger)
+	db := postgres.NewDB(&postgres.Config{ConnStr: connStr, Verbose: true}, logger)
+	s.ds = rpc.NewDatastore(db, &rpc.Config{Verbose: true}, logger)
 
 	err = s.ds.Start()
 	if err != nil {
@@ -162,6 +162,30 @@ func (s *DatastoreSuite) TestReadDataInvalid() {
 	}
 }
 
+func (s *DatastoreSuite) TestCancellation() {
+	startTime, _ := ptypes.TimestampProto(time.Now().Add(time.Hour * -1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.ds.WriteData(ctx, &datastore.WriteRequest{
+		CommunityId: "abc123",
+		Data:        []byte("hello world"),
+		DeviceToken: "device-token",
+	})
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), "twirp error canceled: request was cancelled", err.Error())
+
+	ds := rpc.NewDatastore(s.ds.DB, &rpc.Config{ReadTimeout: time.Nanosecond}, kitlog.NewNopLogger())
+
+	_, err = ds.ReadData(context.Background(), &datastore.ReadRequest{
+		CommunityId: "abc123",
+		StartTime:   startTime,
+	})
+	assert.NotNil(s.T(), err)
+	assert.Equal(s.T(), "twirp error deadline_exceeded: request deadline exceeded", err.Error())
+}
+
 func (s *DatastoreSuite) TestPagination() {
 	startTime, _ := time.Parse(time.RFC3339, "2018-05-01T08:00:00Z")
 	endTime, _ := time.Parse(time.RFC3339, "2018-05-01T08:03:00Z")
@@ -244,6 +268,45 @@ func (s *DatastoreSuite) TestPagination() {
 	assert.Equal(s.T(), "", resp.NextPageCursor)
 }
 
+func (s *DatastoreSuite) TestStreamData() {
+	startTime, err := ptypes.TimestampProto(time.Now().Add(time.Hour * -1))
+	assert.Nil(s.T(), err)
+
+	for _, data := range []string{"first", "second", "third", "fourth", "fifth"} {
+		_, err = s.ds.WriteData(context.Background(), &datastore.WriteRequest{
+			CommunityId: "abc123",
+			Data:        []byte(data),
+			DeviceToken: "device-token",
+		})
+		assert.Nil(s.T(), err)
+	}
+
+	events := []string{}
+
+	// a page size smaller than the window forces several reads
+	err = s.ds.StreamData(context.Background(), &datastore.ReadRequest{
+		CommunityId: "abc123",
+		StartTime:   startTime,
+		PageSize:    2,
+	}, func(e *datastore.EncryptedEvent) error {
+		events = append(events, string(e.Data))
+		return nil
+	})
+
+	assert.Nil(s.T(), err)
+	assert.Equal(s.T(), []string{"first", "second", "third", "fourth", "fifth"}, events)
+
+	err = s.ds.StreamData(context.Background(), &datastore.ReadRequest{
+		CommunityId: "abc123",
+		StartTime:   startTime,
+		PageSize:    2,
+	}, func(e *datastore.EncryptedEvent) error {
+		return context.Canceled
+	})
+
+	assert.Equal(s.T(), context.Canceled, err)
+}
+
 func TestDatastoreSuite(t *testing.T) {
 	suite.Run(t, new(DatastoreSuite))
 }
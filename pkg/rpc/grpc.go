@@ -0,0 +1,265 @@
+package rpc
+
+import (
+	"context"
+	"strings"
+
+	datastore "github.com/thingful/twirp-datastore-go"
+	"github.com/twitchtv/twirp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ReadDataStreamServer is the server side of a ReadDataStream call, via which
+// events are sent to the client.
+type ReadDataStreamServer interface {
+	Send(*datastore.EncryptedEvent) error
+	grpc.ServerStream
+}
+
+// DatastoreStreamServer is the server API for the DatastoreStream service
+// defined in datastore_stream.proto, a gRPC only extension to the Datastore
+// service allowing a client to receive a whole window of events as a stream
+// rather than paging through them.
+type DatastoreStreamServer interface {
+	ReadDataStream(*datastore.ReadRequest, ReadDataStreamServer) error
+}
+
+// ensure we adhere to the interface
+var _ DatastoreStreamServer = &Datastore{}
+
+// ReadDataStream streams every event matching the request to the client.
+func (d *Datastore) ReadDataStream(req *datastore.ReadRequest, stream ReadDataStreamServer) error {
+	return d.StreamData(stream.Context(), req, stream.Send)
+}
+
+// NewGRPCServer returns a gRPC server exposing the Datastore service, along
+// with the DatastoreStream extension, backed by the given Datastore instance.
+// Requests are handled by the same methods as the twirp server, so validation
+// and timeouts are identical whichever transport a client uses, with twirp
+// errors converted to the equivalent gRPC status. The given twirp server hooks
+// are run for every request, so gRPC requests share the twirp server's metrics
+// and spans. Any additional server options (e.g. TLS credentials) are passed
+// through to grpc.NewServer.
+func NewGRPCServer(ds *Datastore, hooks *twirp.ServerHooks, opts ...grpc.ServerOption) *grpc.Server {
+	i := &interceptor{hooks: hooks}
+
+	opts = append(
+		opts,
+		grpc.UnaryInterceptor(i.unary),
+		grpc.StreamInterceptor(i.stream),
+	)
+
+	srv := grpc.NewServer(opts...)
+
+	srv.RegisterService(&datastoreServiceDesc, ds)
+	srv.RegisterService(&datastoreStreamServiceDesc, ds)
+
+	return srv
+}
+
+// interceptor runs twirp server hooks around gRPC requests and converts any
+// returned twirp error into a gRPC status.
+type interceptor struct {
+	hooks *twirp.ServerHooks
+}
+
+// unary intercepts unary requests.
+func (i *interceptor) unary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	service, method := splitMethod(info.FullMethod)
+
+	ctx, err := StartRequest(ctx, i.hooks, service, method)
+	if err != nil {
+		EndRequest(ctx, i.hooks, err)
+		return nil, toStatusError(err)
+	}
+
+	resp, err := handler(ctx, req)
+	EndRequest(ctx, i.hooks, err)
+
+	return resp, toStatusError(err)
+}
+
+// stream intercepts streaming requests, for which the hooks see the time taken
+// to send the whole stream.
+func (i *interceptor) stream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	service, method := splitMethod(info.FullMethod)
+
+	ctx, err := StartRequest(ss.Context(), i.hooks, service, method)
+	if err != nil {
+		EndRequest(ctx, i.hooks, err)
+		return toStatusError(err)
+	}
+
+	err = handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	EndRequest(ctx, i.hooks, err)
+
+	return toStatusError(err)
+}
+
+// serverStream overrides the context of a grpc.ServerStream with the one
+// returned by the hooks.
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// splitMethod splits a full gRPC method name, e.g.
+// /decode.iot.datastore.Datastore/ReadData, into the service and method names
+// twirp would report, e.g. Datastore and ReadData.
+func splitMethod(fullMethod string) (string, string) {
+	parts := strings.SplitN(strings.TrimPrefix(fullMethod, "/"), "/", 2)
+	if len(parts) != 2 {
+		return "", fullMethod
+	}
+
+	return strings.TrimPrefix(parts[0], packageName+"."), parts[1]
+}
+
+// toStatusError converts a twirp error into a gRPC status error with the
+// equivalent code. Other errors are returned unchanged, leaving gRPC to handle
+// them as it normally would.
+func toStatusError(err error) error {
+	twerr, ok := err.(twirp.Error)
+	if !ok {
+		return err
+	}
+
+	return status.Error(grpcCode(twerr.Code()), twerr.Msg())
+}
+
+// grpcCode maps a twirp error code to a gRPC status code. Twirp's codes are
+// derived from gRPC's so the mapping is one to one, other than twirp's
+// bad_route which has no gRPC equivalent.
+func grpcCode(code twirp.ErrorCode) codes.Code {
+	switch code {
+	case twirp.NoError:
+		return codes.OK
+	case twirp.Canceled:
+		return codes.Canceled
+	case twirp.InvalidArgument:
+		return codes.InvalidArgument
+	case twirp.DeadlineExceeded:
+		return codes.DeadlineExceeded
+	case twirp.NotFound:
+		return codes.NotFound
+	case twirp.BadRoute:
+		return codes.Unimplemented
+	case twirp.AlreadyExists:
+		return codes.AlreadyExists
+	case twirp.PermissionDenied:
+		return codes.PermissionDenied
+	case twirp.Unauthenticated:
+		return codes.Unauthenticated
+	case twirp.ResourceExhausted:
+		return codes.ResourceExhausted
+	case twirp.FailedPrecondition:
+		return codes.FailedPrecondition
+	case twirp.Aborted:
+		return codes.Aborted
+	case twirp.OutOfRange:
+		return codes.OutOfRange
+	case twirp.Unimplemented:
+		return codes.Unimplemented
+	case twirp.Internal:
+		return codes.Internal
+	case twirp.Unavailable:
+		return codes.Unavailable
+	case twirp.DataLoss:
+		return codes.DataLoss
+	default:
+		return codes.Unknown
+	}
+}
+
+// The service descriptors and handlers below are what protoc-gen-go would
+// generate with the grpc plugin enabled. We maintain them by hand as the
+// generated datastore package only contains the twirp bindings.
+
+var datastoreServiceDesc = grpc.ServiceDesc{
+	ServiceName: "decode.iot.datastore.Datastore",
+	HandlerType: (*datastore.Datastore)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "WriteData",
+			Handler:    datastoreWriteDataHandler,
+		},
+		{
+			MethodName: "ReadData",
+			Handler:    datastoreReadDataHandler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "datastore.proto",
+}
+
+func datastoreWriteDataHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(datastore.WriteRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(datastore.Datastore).WriteData(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/decode.iot.datastore.Datastore/WriteData",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(datastore.Datastore).WriteData(ctx, req.(*datastore.WriteRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func datastoreReadDataHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(datastore.ReadRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(datastore.Datastore).ReadData(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/decode.iot.datastore.Datastore/ReadData",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(datastore.Datastore).ReadData(ctx, req.(*datastore.ReadRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var datastoreStreamServiceDesc = grpc.ServiceDesc{
+	ServiceName: "decode.iot.datastore.DatastoreStream",
+	HandlerType: (*DatastoreStreamServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ReadDataStream",
+			Handler:       datastoreStreamReadDataStreamHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "datastore_stream.proto",
+}
+
+func datastoreStreamReadDataStreamHandler(srv interface{}, stream grpc.ServerStream) error {
+	in := new(datastore.ReadRequest)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(DatastoreStreamServer).ReadDataStream(in, &readDataStreamServer{stream})
+}
+
+type readDataStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *readDataStreamServer) Send(m *datastore.EncryptedEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
@@ -0,0 +1,133 @@
+package rpc_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/assert"
+	datastore "github.com/thingful/twirp-datastore-go"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/DECODEproject/iotstore/pkg/postgres"
+	"github.com/DECODEproject/iotstore/pkg/rpc"
+)
+
+// dialGRPC starts a gRPC server for the given datastore on a random local port
+// and returns a client connection to it, along with a function that closes
+// both.
+func dialGRPC(t *testing.T, ds *rpc.Datastore) (*grpc.ClientConn, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	srv := rpc.NewGRPCServer(ds, nil)
+	go srv.Serve(lis)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Failed to dial gRPC server: %v", err)
+	}
+
+	return conn, func() {
+		conn.Close()
+		srv.Stop()
+	}
+}
+
+func readDataStream(ctx context.Context, conn *grpc.ClientConn, req *datastore.ReadRequest) ([]*datastore.EncryptedEvent, error) {
+	stream, err := conn.NewStream(
+		ctx,
+		&grpc.StreamDesc{ServerStreams: true},
+		"/decode.iot.datastore.DatastoreStream/ReadDataStream",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stream.SendMsg(req)
+	if err != nil {
+		return nil, err
+	}
+
+	err = stream.CloseSend()
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*datastore.EncryptedEvent{}
+
+	for {
+		event := &datastore.EncryptedEvent{}
+		err = stream.RecvMsg(event)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+}
+
+func TestGRPCValidation(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	// validation happens before the DB is used, so it need not be started
+	db := postgres.NewDB(&postgres.Config{}, logger)
+	ds := rpc.NewDatastore(db, &rpc.Config{}, logger)
+
+	conn, closer := dialGRPC(t, ds)
+	defer closer()
+
+	ctx := context.Background()
+
+	testcases := []struct {
+		label  string
+		method string
+		req    interface{}
+	}{
+		{
+			label:  "write missing community",
+			method: "/decode.iot.datastore.Datastore/WriteData",
+			req:    &datastore.WriteRequest{DeviceToken: "abc123"},
+		},
+		{
+			label:  "write missing device token",
+			method: "/decode.iot.datastore.Datastore/WriteData",
+			req:    &datastore.WriteRequest{CommunityId: "abc123"},
+		},
+		{
+			label:  "read missing start time",
+			method: "/decode.iot.datastore.Datastore/ReadData",
+			req:    &datastore.ReadRequest{CommunityId: "abc123"},
+		},
+		{
+			label:  "read invalid page size",
+			method: "/decode.iot.datastore.Datastore/ReadData",
+			req:    &datastore.ReadRequest{CommunityId: "abc123", StartTime: ptypes.TimestampNow(), PageSize: 1001},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			err := conn.Invoke(ctx, tc.method, tc.req, &datastore.ReadResponse{})
+			assert.NotNil(t, err)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		})
+	}
+
+	_, err := readDataStream(ctx, conn, &datastore.ReadRequest{StartTime: ptypes.TimestampNow()})
+	assert.NotNil(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "community_id is required", status.Convert(err).Message())
+
+	err = conn.Invoke(ctx, "/decode.iot.datastore.Datastore/Unknown", &datastore.WriteRequest{}, &datastore.WriteResponse{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
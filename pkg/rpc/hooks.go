@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/twitchtv/twirp"
+	"github.com/twitchtv/twirp/ctxsetters"
+)
+
+// packageName is the protobuf package of the Datastore service, as reported to
+// twirp server hooks.
+const packageName = "decode.iot.datastore"
+
+// StartRequest runs the RequestReceived and RequestRouted twirp server hooks
+// for a request to the given service method that arrived over some transport
+// other than twirp, e.g. gRPC. This means such requests are counted and traced
+// by the same hooks as twirp requests. The returned context must be passed to
+// EndRequest once the request has been handled. If a hook returns an error
+// the request should be rejected, but EndRequest must still be called.
+func StartRequest(ctx context.Context, hooks *twirp.ServerHooks, service, method string) (context.Context, error) {
+	ctx = ctxsetters.WithPackageName(ctx, packageName)
+	ctx = ctxsetters.WithServiceName(ctx, service)
+
+	if hooks == nil {
+		return ctxsetters.WithMethodName(ctx, method), nil
+	}
+
+	var err error
+
+	if hooks.RequestReceived != nil {
+		ctx, err = hooks.RequestReceived(ctx)
+		if err != nil {
+			return ctx, err
+		}
+	}
+
+	ctx = ctxsetters.WithMethodName(ctx, method)
+
+	if hooks.RequestRouted != nil {
+		ctx, err = hooks.RequestRouted(ctx)
+	}
+
+	return ctx, err
+}
+
+// EndRequest runs the remaining twirp server hooks for a request started with
+// StartRequest, recording the status code twirp would have responded with for
+// the given error.
+func EndRequest(ctx context.Context, hooks *twirp.ServerHooks, err error) {
+	if hooks == nil {
+		return
+	}
+
+	if err == nil {
+		if hooks.ResponsePrepared != nil {
+			ctx = hooks.ResponsePrepared(ctx)
+		}
+
+		ctx = ctxsetters.WithStatusCode(ctx, http.StatusOK)
+	} else {
+		twerr, ok := err.(twirp.Error)
+		if !ok {
+			twerr = twirp.InternalErrorWith(err)
+		}
+
+		ctx = ctxsetters.WithStatusCode(ctx, twirp.ServerHTTPStatusFromErrorCode(twerr.Code()))
+
+		if hooks.Error != nil {
+			ctx = hooks.Error(ctx, twerr)
+		}
+	}
+
+	if hooks.ResponseSent != nil {
+		hooks.ResponseSent(ctx)
+	}
+}
@@ -0,0 +1,89 @@
+package rpc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotstore/pkg/rpc"
+)
+
+// recordingHooks returns twirp server hooks which record the service, method,
+// status code and error code they see.
+func recordingHooks(calls *[]string) *twirp.ServerHooks {
+	return &twirp.ServerHooks{
+		RequestReceived: func(ctx context.Context) (context.Context, error) {
+			service, _ := twirp.ServiceName(ctx)
+			*calls = append(*calls, "received "+service)
+			return ctx, nil
+		},
+		RequestRouted: func(ctx context.Context) (context.Context, error) {
+			method, _ := twirp.MethodName(ctx)
+			*calls = append(*calls, "routed "+method)
+			return ctx, nil
+		},
+		ResponsePrepared: func(ctx context.Context) context.Context {
+			*calls = append(*calls, "prepared")
+			return ctx
+		},
+		Error: func(ctx context.Context, err twirp.Error) context.Context {
+			*calls = append(*calls, "error "+string(err.Code()))
+			return ctx
+		},
+		ResponseSent: func(ctx context.Context) {
+			status, _ := twirp.StatusCode(ctx)
+			*calls = append(*calls, "sent "+status)
+		},
+	}
+}
+
+func TestRequestHooks(t *testing.T) {
+	testcases := []struct {
+		label    string
+		err      error
+		expected []string
+	}{
+		{
+			label:    "success",
+			expected: []string{"received Datastore", "routed ReadData", "prepared", "sent 200"},
+		},
+		{
+			label:    "twirp error",
+			err:      twirp.InvalidArgumentError("community_id", "required"),
+			expected: []string{"received Datastore", "routed ReadData", "error invalid_argument", "sent 400"},
+		},
+		{
+			label:    "other error",
+			err:      errors.New("boom"),
+			expected: []string{"received Datastore", "routed ReadData", "error internal", "sent 500"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			calls := []string{}
+			hooks := recordingHooks(&calls)
+
+			ctx, err := rpc.StartRequest(context.Background(), hooks, "Datastore", "ReadData")
+			assert.Nil(t, err)
+
+			rpc.EndRequest(ctx, hooks, tc.err)
+
+			assert.Equal(t, tc.expected, calls)
+		})
+	}
+}
+
+func TestRequestHooksNil(t *testing.T) {
+	ctx, err := rpc.StartRequest(context.Background(), nil, "Datastore", "ReadData")
+	assert.Nil(t, err)
+
+	method, ok := twirp.MethodName(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "ReadData", method)
+
+	rpc.EndRequest(ctx, nil, nil)
+}
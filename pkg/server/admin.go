@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/pprof"
+	"reflect"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	goji "goji.io"
+	pat "goji.io/pat"
+
+	"github.com/DECODEproject/iotstore/pkg/postgres"
+	"github.com/DECODEproject/iotstore/pkg/redact"
+	"github.com/DECODEproject/iotstore/pkg/rpc"
+)
+
+// Verboser is implemented by components whose verbose logging can be switched
+// on and off while running.
+type Verboser interface {
+	Verbose() bool
+	SetVerbose(verbose bool)
+}
+
+// verboseSetting is the request and response body of the verbose endpoint.
+type verboseSetting struct {
+	Verbose bool `json:"verbose"`
+}
+
+// newAdminHandler returns the http.Handler served on the admin listener,
+// which exposes our metrics and health checks, the Go profiler, our
+// configuration, runtime controls and the list of stale devices. It must only
+// be reachable by operators.
+func newAdminHandler(config *Config, db *postgres.DB, ds *rpc.Datastore, health *Health, settings func() *Settings, staleDeviceThreshold func() time.Duration, logger kitlog.Logger) http.Handler {
+	mux := goji.NewMux()
+
+	mux.Handle(pat.Get("/metrics"), promhttp.Handler())
+	mux.Handle(pat.Get("/pulse"), PulseHandler(db))
+	mux.Handle(pat.Get("/healthz"), health.LivenessHandler())
+	mux.Handle(pat.Get("/readyz"), health.ReadinessHandler())
+	mux.Handle(pat.Get("/status"), health.StatusHandler())
+	mux.Handle(pat.Get("/config"), ConfigHandler(config, settings))
+	mux.Handle(pat.Get("/verbose"), VerboseHandler(ds, logger))
+	mux.Handle(pat.Put("/verbose"), VerboseHandler(ds, logger))
+	mux.Handle(pat.Get("/devices/stale"), staleDevicesHandler(ds, staleDeviceThreshold))
+
+	mux.HandleFunc(pat.Get("/debug/pprof/cmdline"), pprof.Cmdline)
+	mux.HandleFunc(pat.Get("/debug/pprof/profile"), pprof.Profile)
+	mux.HandleFunc(pat.New("/debug/pprof/symbol"), pprof.Symbol)
+	mux.HandleFunc(pat.Get("/debug/pprof/trace"), pprof.Trace)
+	mux.HandleFunc(pat.Get("/debug/pprof/*"), pprof.Index)
+
+	return mux
+}
+
+// ConfigHandler returns an http.Handler which writes the effective
+// configuration of the server as JSON, keyed by the name of each field of
+// Config, with the current Settings replacing those the server started with.
+// Passwords and other secrets in URLs and connection strings are redacted.
+func ConfigHandler(config *Config, current func() *Settings) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		settings := configSettings(config)
+		for k, v := range configSettings(current()) {
+			settings[k] = v
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(settings)
+	})
+}
+
+// configSettings returns the fields of the given struct as a map, with
+// durations written in their human readable form and secrets redacted.
+// Reflection is used so that fields added to Config or Settings are included
+// without further work.
+func configSettings(config interface{}) map[string]interface{} {
+	settings := map[string]interface{}{}
+
+	v := reflect.Indirect(reflect.ValueOf(config))
+	t := v.Type()
+
+	for i := 0; i < v.NumField(); i++ {
+		name := t.Field(i).Name
+
+		switch value := v.Field(i).Interface().(type) {
+		case time.Duration:
+			settings[name] = value.String()
+		case string:
+			settings[name] = redact.Secrets(value)
+		case []string:
+			redacted := make([]string, len(value))
+			for j, s := range value {
+				redacted[j] = redact.Secrets(s)
+			}
+			settings[name] = redacted
+		default:
+			settings[name] = value
+		}
+	}
+
+	return settings
+}
+
+// VerboseHandler returns an http.Handler which writes whether verbose logging
+// is switched on as JSON, e.g. {"verbose":true}. A PUT request with a body of
+// the same form switches verbose logging on or off.
+func VerboseHandler(v Verboser, logger kitlog.Logger) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			var setting verboseSetting
+
+			err := json.NewDecoder(r.Body).Decode(&setting)
+			if err != nil {
+				http.Error(w, "invalid request body", http.StatusBadRequest)
+				return
+			}
+
+			v.SetVerbose(setting.Verbose)
+
+			logger.Log("msg", "set verbose logging", "verbose", setting.Verbose)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(verboseSetting{Verbose: v.Verbose()})
+	})
+}
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+
+	"github.com/DECODEproject/iotstore/pkg/postgres"
+)
+
+const (
+	// archiveInterval is how often we move old events into cold storage.
+	archiveInterval = time.Hour
+)
+
+// maintainArchive periodically moves events older than the current age out of
+// the events table and into cold storage, doing nothing while the age is zero.
+// It runs until the done channel is closed.
+func maintainArchive(db *postgres.DB, after func() time.Duration, done <-chan struct{}, logger kitlog.Logger) {
+	ticker := time.NewTicker(archiveInterval)
+	defer ticker.Stop()
+
+	for {
+		if age := after(); age > 0 {
+			_, err := db.ArchiveData(context.Background(), time.Now().Add(-age))
+			if err != nil {
+				logger.Log("msg", "failed to archive events", "err", err)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
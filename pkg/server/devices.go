@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	registry "github.com/thingful/retryable-registry-prometheus"
+	"github.com/twitchtv/twirp"
+
+	"github.com/DECODEproject/iotstore/pkg/postgres"
+	"github.com/DECODEproject/iotstore/pkg/rpc"
+)
+
+const (
+	// DefaultStaleDeviceThreshold is the default duration after which a device
+	// from which we have received no events is considered to be stale.
+	DefaultStaleDeviceThreshold = time.Hour
+
+	// deviceMonitorInterval is how often we sample the number of stale devices
+	// in order to update our prometheus gauge.
+	deviceMonitorInterval = time.Minute
+)
+
+var (
+	// staleDevices is a Gauge used to expose the number of devices that have not
+	// sent any data for longer than the configured threshold.
+	staleDevices = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "stale_devices",
+			Help:      "Number of devices silent for longer than the configured threshold",
+		},
+	)
+)
+
+func init() {
+	registry.MustRegister(staleDevices)
+}
+
+// staleDevice is the JSON representation of a single stale device returned
+// from the stale devices endpoint.
+type staleDevice struct {
+	DeviceToken string    `json:"deviceToken"`
+	LastSeenAt  time.Time `json:"lastSeenAt"`
+}
+
+// staleDevicesResponse is the JSON response returned from the stale devices
+// endpoint.
+type staleDevicesResponse struct {
+	CommunityID string         `json:"communityId"`
+	Threshold   string         `json:"threshold"`
+	Devices     []*staleDevice `json:"devices"`
+}
+
+// StaleDevicesHandler is a function that closes over our Datastore instance
+// returning an http.Handler that returns as JSON the list of devices for a
+// community (passed via the community_id query parameter) that have been silent
+// for longer than some threshold. The threshold may be passed as a duration
+// string via the threshold parameter, else we use the given default. As the
+// response includes device tokens it is only served on the admin listener.
+func StaleDevicesHandler(ds *rpc.Datastore, defaultThreshold time.Duration) http.Handler {
+	return staleDevicesHandler(ds, func() time.Duration { return defaultThreshold })
+}
+
+// staleDevicesHandler is StaleDevicesHandler with the default threshold read
+// for each request, so that it may be changed while we are running.
+func staleDevicesHandler(ds *rpc.Datastore, defaultThreshold func() time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		threshold := defaultThreshold()
+
+		if t := r.URL.Query().Get("threshold"); t != "" {
+			parsed, err := time.ParseDuration(t)
+			if err != nil {
+				writeError(w, twirp.InvalidArgumentError("threshold", "must be a valid duration, e.g. 2h"))
+				return
+			}
+			threshold = parsed
+		}
+
+		communityID := r.URL.Query().Get("community_id")
+
+		devices, err := ds.StaleDevices(r.Context(), communityID, threshold)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		resp := &staleDevicesResponse{
+			CommunityID: communityID,
+			Threshold:   threshold.String(),
+			Devices:     []*staleDevice{},
+		}
+
+		for _, d := range devices {
+			resp.Devices = append(resp.Devices, &staleDevice{
+				DeviceToken: d.DeviceToken,
+				LastSeenAt:  d.LastSeenAt,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// monitorDevices periodically counts the number of devices silent for longer
+// than the current threshold, exposing the result via our prometheus gauge. It
+// runs until the done channel is closed.
+func monitorDevices(db *postgres.DB, threshold func() time.Duration, done <-chan struct{}, logger kitlog.Logger) {
+	ticker := time.NewTicker(deviceMonitorInterval)
+	defer ticker.Stop()
+
+	for {
+		count, err := db.CountStaleDevices(context.Background(), threshold())
+		if err != nil {
+			logger.Log("msg", "failed to count stale devices", "err", err)
+		} else {
+			staleDevices.Set(float64(count))
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
+
+// writeError writes the given error to the response in the same JSON format
+// used by twirp, so that clients see consistent error responses. Non twirp
+// errors are returned as internal errors.
+func writeError(w http.ResponseWriter, err error) {
+	twerr, ok := err.(twirp.Error)
+	if !ok {
+		twerr = twirp.InternalErrorWith(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(twirp.ServerHTTPStatusFromErrorCode(twerr.Code()))
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": twerr.Code(),
+		"msg":  twerr.Msg(),
+		"meta": twerr.MetaMap(),
+	})
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/DECODEproject/iotstore/pkg/version"
+)
+
+// healthCheckTimeout is the maximum time we wait for the database to respond
+// when checking whether we are ready.
+const healthCheckTimeout = 2 * time.Second
+
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+	statusDown        = "down"
+	statusUnknown     = "unknown"
+	statusPending     = "pending"
+	statusDirty       = "dirty"
+	statusDegraded    = "degraded"
+	statusStarting    = "starting"
+	statusStopping    = "stopping"
+)
+
+// the states of the server tracked by Health
+const (
+	stateStarting int32 = iota
+	stateReady
+	stateStopping
+)
+
+// Checker is the part of the database used to check our health.
+type Checker interface {
+	Ping(ctx context.Context) error
+	MigrationVersion(ctx context.Context) (uint, bool, error)
+	HasReplica() bool
+	UsingReplica() bool
+}
+
+// Connector is implemented by components which maintain a connection to some
+// other service, such as the MQTT subscriber.
+type Connector interface {
+	Connected() bool
+}
+
+// Component describes the health of a single dependency in the response from
+// the status endpoint.
+type Component struct {
+	Status          string `json:"status"`
+	Latency         string `json:"latency,omitempty"`
+	Error           string `json:"error,omitempty"`
+	Version         *uint  `json:"version,omitempty"`
+	ExpectedVersion *uint  `json:"expectedVersion,omitempty"`
+	Dirty           *bool  `json:"dirty,omitempty"`
+}
+
+// Status is the response from the status endpoint.
+type Status struct {
+	Status     string                `json:"status"`
+	Ready      bool                  `json:"ready"`
+	Version    string                `json:"version"`
+	BuildDate  string                `json:"buildDate"`
+	Uptime     string                `json:"uptime"`
+	Components map[string]*Component `json:"components"`
+}
+
+// Health tracks whether we are ready to receive traffic, and exposes handlers
+// for liveness and readiness probes, along with a more detailed status
+// endpoint. We are ready once the server has started, for as long as the
+// database is reachable with the schema at the version this binary expects,
+// and until the server starts shutting down.
+type Health struct {
+	db              Checker
+	expectedVersion uint
+	started         time.Time
+
+	// state is one of stateStarting, stateReady or stateStopping
+	state int32
+
+	mu   sync.Mutex
+	mqtt Connector
+}
+
+// NewHealth returns a new Health instance checking the given database, which
+// is expected to have been migrated to expectedVersion.
+func NewHealth(db Checker, expectedVersion uint) *Health {
+	return &Health{
+		db:              db,
+		expectedVersion: expectedVersion,
+		started:         time.Now(),
+	}
+}
+
+// SetReady marks the server as having started.
+func (h *Health) SetReady() {
+	atomic.StoreInt32(&h.state, stateReady)
+}
+
+// SetStopping marks the server as shutting down, failing our readiness checks
+// so that load balancers stop sending us traffic.
+func (h *Health) SetStopping() {
+	atomic.StoreInt32(&h.state, stateStopping)
+}
+
+// isReady returns true if the server has started and is not shutting down.
+func (h *Health) isReady() bool {
+	return atomic.LoadInt32(&h.state) == stateReady
+}
+
+// SetMQTT sets the MQTT subscriber whose connection is included in the status.
+func (h *Health) SetMQTT(c Connector) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.mqtt = c
+}
+
+// LivenessHandler returns an http.Handler which always responds 200 OK, as if
+// we are able to respond at all the process is alive.
+func (h *Health) LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "ok")
+	})
+}
+
+// ReadinessHandler returns an http.Handler which responds 200 OK if we are
+// ready to receive traffic, or 503 Service Unavailable with the reason if not.
+func (h *Health) ReadinessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := h.check(r.Context())
+		if !status.Ready {
+			http.Error(w, notReadyReason(status), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintf(w, "ok")
+	})
+}
+
+// StatusHandler returns an http.Handler which writes the status of each of our
+// components as JSON. The response status is 503 Service Unavailable if we
+// are not ready.
+func (h *Health) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := h.check(r.Context())
+
+		w.Header().Set("Content-Type", "application/json")
+
+		if !status.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(status)
+	})
+}
+
+// check checks each of our components, returning our overall status.
+func (h *Health) check(ctx context.Context) *Status {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+
+	status := &Status{
+		Version:    version.Version,
+		BuildDate:  version.BuildDate,
+		Uptime:     time.Since(h.started).Round(time.Second).String(),
+		Components: map[string]*Component{},
+	}
+
+	server := &Component{Status: statusOK}
+	switch atomic.LoadInt32(&h.state) {
+	case stateStarting:
+		server.Status = statusStarting
+	case stateStopping:
+		server.Status = statusStopping
+	}
+	status.Components["server"] = server
+
+	database := &Component{Status: statusOK}
+	start := time.Now()
+	err := h.db.Ping(ctx)
+	database.Latency = time.Since(start).String()
+	if err != nil {
+		database.Status = statusDown
+		database.Error = err.Error()
+	}
+	status.Components["database"] = database
+
+	migrations := &Component{
+		Status:          statusUnknown,
+		ExpectedVersion: &h.expectedVersion,
+	}
+	if err == nil {
+		current, dirty, err := h.db.MigrationVersion(ctx)
+		if err != nil {
+			migrations.Error = err.Error()
+		} else {
+			migrations.Version = &current
+			migrations.Dirty = &dirty
+
+			switch {
+			case dirty:
+				migrations.Status = statusDirty
+			case current != h.expectedVersion:
+				migrations.Status = statusPending
+			default:
+				migrations.Status = statusOK
+			}
+		}
+	}
+	status.Components["migrations"] = migrations
+
+	// the replica and mqtt connection don't affect readiness, as we fall back
+	// to the primary for reads, and the subscriber reconnects in the background
+	if h.db.HasReplica() {
+		replica := &Component{Status: statusOK}
+		if !h.db.UsingReplica() {
+			replica.Status = statusDegraded
+			replica.Error = "reading from primary"
+		}
+		status.Components["replica"] = replica
+	}
+
+	h.mu.Lock()
+	if h.mqtt != nil {
+		mqtt := &Component{Status: statusOK}
+		if !h.mqtt.Connected() {
+			mqtt.Status = statusDown
+		}
+		status.Components["mqtt"] = mqtt
+	}
+	h.mu.Unlock()
+
+	status.Ready = server.Status == statusOK &&
+		database.Status == statusOK &&
+		migrations.Status == statusOK
+
+	if status.Ready {
+		status.Status = statusOK
+	} else {
+		status.Status = statusUnavailable
+	}
+
+	return status
+}
+
+// notReadyReason returns a short description of why we are not ready.
+func notReadyReason(status *Status) string {
+	for _, name := range []string{"server", "database", "migrations"} {
+		if c := status.Components[name]; c.Status != statusOK {
+			return fmt.Sprintf("%s %s", name, c.Status)
+		}
+	}
+	return statusUnavailable
+}
@@ -0,0 +1,164 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotstore/pkg/server"
+)
+
+type mockChecker struct {
+	pingErr      error
+	version      uint
+	dirty        bool
+	hasReplica   bool
+	usingReplica bool
+}
+
+func (m *mockChecker) Ping(ctx context.Context) error {
+	return m.pingErr
+}
+
+func (m *mockChecker) MigrationVersion(ctx context.Context) (uint, bool, error) {
+	return m.version, m.dirty, nil
+}
+
+func (m *mockChecker) HasReplica() bool {
+	return m.hasReplica
+}
+
+func (m *mockChecker) UsingReplica() bool {
+	return m.usingReplica
+}
+
+type mockConnector bool
+
+func (m mockConnector) Connected() bool {
+	return bool(m)
+}
+
+func TestLivenessHandler(t *testing.T) {
+	health := server.NewHealth(&mockChecker{pingErr: errors.New("connection refused")}, 2)
+
+	rr := httptest.NewRecorder()
+	health.LivenessHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "ok", rr.Body.String())
+}
+
+func TestReadinessHandler(t *testing.T) {
+	testcases := []struct {
+		label          string
+		checker        *mockChecker
+		ready          bool
+		stopping       bool
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			label:          "ready",
+			checker:        &mockChecker{version: 2},
+			ready:          true,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+		},
+		{
+			label:          "starting",
+			checker:        &mockChecker{version: 2},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "server starting\n",
+		},
+		{
+			label:          "stopping",
+			checker:        &mockChecker{version: 2},
+			ready:          true,
+			stopping:       true,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "server stopping\n",
+		},
+		{
+			label:          "database down",
+			checker:        &mockChecker{pingErr: errors.New("connection refused")},
+			ready:          true,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "database down\n",
+		},
+		{
+			label:          "migrations pending",
+			checker:        &mockChecker{version: 1},
+			ready:          true,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "migrations pending\n",
+		},
+		{
+			label:          "migrations dirty",
+			checker:        &mockChecker{version: 2, dirty: true},
+			ready:          true,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   "migrations dirty\n",
+		},
+		{
+			label:          "replica not in use",
+			checker:        &mockChecker{version: 2, hasReplica: true},
+			ready:          true,
+			expectedStatus: http.StatusOK,
+			expectedBody:   "ok",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			health := server.NewHealth(tc.checker, 2)
+			if tc.ready {
+				health.SetReady()
+			}
+			if tc.stopping {
+				health.SetStopping()
+			}
+
+			rr := httptest.NewRecorder()
+			health.ReadinessHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			assert.Equal(t, tc.expectedBody, rr.Body.String())
+		})
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	health := server.NewHealth(&mockChecker{version: 2, hasReplica: true}, 2)
+	health.SetMQTT(mockConnector(false))
+	health.SetReady()
+
+	rr := httptest.NewRecorder()
+	health.StatusHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var status server.Status
+	err := json.Unmarshal(rr.Body.Bytes(), &status)
+	assert.Nil(t, err)
+
+	assert.Equal(t, "ok", status.Status)
+	assert.True(t, status.Ready)
+	assert.Equal(t, "ok", status.Components["database"].Status)
+	assert.NotEmpty(t, status.Components["database"].Latency)
+	assert.Equal(t, uint(2), *status.Components["migrations"].Version)
+	assert.Equal(t, "degraded", status.Components["replica"].Status)
+	assert.Equal(t, "down", status.Components["mqtt"].Status)
+
+	health.SetStopping()
+
+	rr = httptest.NewRecorder()
+	health.StatusHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/status", nil))
+
+	assert.Equal(t, http.StatusServiceUnavailable, rr.Code)
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/DECODEproject/iotstore/pkg/rpc"
+	"github.com/DECODEproject/iotstore/pkg/version"
+)
+
+// OpenAPIHandler returns an http.Handler that serves the OpenAPI 3 document
+// describing the REST API.
+func OpenAPIHandler() http.Handler {
+	doc, err := json.Marshal(openAPIDocument())
+	if err != nil {
+		// the document is built from static values so this cannot happen
+		panic(err)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	})
+}
+
+// openAPIDocument returns the OpenAPI 3 document describing the REST API. The
+// request and response schemas are generated from the types used to encode
+// them, so the document cannot drift from what the handlers actually accept
+// and return.
+func openAPIDocument() map[string]interface{} {
+	communityID := map[string]interface{}{
+		"name":        "id",
+		"in":          "path",
+		"required":    true,
+		"description": "The community to which the events belong",
+		"schema":      map[string]interface{}{"type": "string"},
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":       "DECODE IoT datastore",
+			"description": "Stores and returns encrypted events produced by IoT devices. Event data is encrypted for the community by the device, and is opaque to the datastore.",
+			"version":     version.Version,
+		},
+		"paths": map[string]interface{}{
+			"/v1/communities/{id}/events": map[string]interface{}{
+				"post": map[string]interface{}{
+					"operationId": "writeEvent",
+					"summary":     "Write an encrypted event for a community",
+					"parameters":  []interface{}{communityID},
+					"requestBody": map[string]interface{}{
+						"required": true,
+						"content":  jsonContent("WriteEventRequest"),
+					},
+					"responses": map[string]interface{}{
+						"204":     map[string]interface{}{"description": "The event was written"},
+						"default": errorResponse(),
+					},
+				},
+				"get": map[string]interface{}{
+					"operationId": "readEvents",
+					"summary":     "Read a page of encrypted events for a community",
+					"description": "Events are returned in the order they were recorded. If more events are available a link to the next page is returned in the Link header, as well as its cursor in the response body.",
+					"parameters": []interface{}{
+						communityID,
+						queryParameter("start", "Start of the time window as an RFC 3339 timestamp", true, dateTime()),
+						queryParameter("end", "End of the time window as an RFC 3339 timestamp, defaults to now", false, dateTime()),
+						queryParameter("pageSize", "Maximum number of events to return", false, map[string]interface{}{
+							"type":    "integer",
+							"minimum": 1,
+							"maximum": rpc.MaxPageSize,
+							"default": rpc.DefaultPageSize,
+						}),
+						queryParameter("cursor", "Cursor of the page to return, as returned by the previous page", false, map[string]interface{}{"type": "string"}),
+					},
+					"responses": map[string]interface{}{
+						"200": map[string]interface{}{
+							"description": "A page of events",
+							"headers": map[string]interface{}{
+								"Link": map[string]interface{}{
+									"description": "RFC 5988 links to the first and, if any, next page",
+									"schema":      map[string]interface{}{"type": "string"},
+								},
+							},
+							"content": jsonContent("ReadEventsResponse"),
+						},
+						"default": errorResponse(),
+					},
+				},
+			},
+		},
+		"components": map[string]interface{}{
+			"schemas": map[string]interface{}{
+				"WriteEventRequest":  schemaFor(reflect.TypeOf(writeEventRequest{})),
+				"ReadEventsResponse": schemaFor(reflect.TypeOf(readEventsResponse{})),
+				"Event":              schemaFor(reflect.TypeOf(event{})),
+				"Error": map[string]interface{}{
+					"type": "object",
+					"properties": map[string]interface{}{
+						"code": map[string]interface{}{"type": "string"},
+						"msg":  map[string]interface{}{"type": "string"},
+						"meta": map[string]interface{}{
+							"type":                 "object",
+							"additionalProperties": map[string]interface{}{"type": "string"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func jsonContent(schema string) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{
+			"schema": map[string]interface{}{"$ref": "#/components/schemas/" + schema},
+		},
+	}
+}
+
+func errorResponse() map[string]interface{} {
+	return map[string]interface{}{
+		"description": "An error in the same format returned by the twirp API",
+		"content":     jsonContent("Error"),
+	}
+}
+
+func queryParameter(name, description string, required bool, schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        name,
+		"in":          "query",
+		"required":    required,
+		"description": description,
+		"schema":      schema,
+	}
+}
+
+func dateTime() map[string]interface{} {
+	return map[string]interface{}{"type": "string", "format": "date-time"}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaFor returns the JSON schema for values of the given type as encoded by
+// encoding/json. Only the kinds of value used by the REST API are supported.
+// Structs other than the top level type are referenced by name, under which
+// they must be registered in the document's components.
+func schemaFor(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return dateTime()
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8:
+		return map[string]interface{}{"type": "string", "format": "byte"}
+	case t.Kind() == reflect.Slice:
+		items := schemaFor(t.Elem())
+		if elem := t.Elem(); elem.Kind() == reflect.Ptr && elem.Elem().Kind() == reflect.Struct && elem.Elem() != timeType {
+			items = map[string]interface{}{"$ref": "#/components/schemas/" + strings.Title(elem.Elem().Name())}
+		}
+		return map[string]interface{}{"type": "array", "items": items}
+	case t.Kind() == reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Struct:
+		properties := map[string]interface{}{}
+		required := []string{}
+
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+
+			tag := strings.Split(f.Tag.Get("json"), ",")
+			if tag[0] == "" || tag[0] == "-" {
+				continue
+			}
+
+			properties[tag[0]] = schemaFor(f.Type)
+
+			if len(tag) == 1 || tag[1] != "omitempty" {
+				required = append(required, tag[0])
+			}
+		}
+
+		return map[string]interface{}{
+			"type":       "object",
+			"properties": properties,
+			"required":   required,
+		}
+	default:
+		return map[string]interface{}{}
+	}
+}
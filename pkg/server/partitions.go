@@ -0,0 +1,41 @@
+package server
+
+import (
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+
+	"github.com/DECODEproject/iotstore/pkg/postgres"
+)
+
+const (
+	// DefaultPartitionsAhead is the default number of monthly partitions of the
+	// events table we create in advance of the current month.
+	DefaultPartitionsAhead = 3
+
+	// partitionInterval is how often we check that partitions have been created
+	// ahead of time.
+	partitionInterval = time.Hour
+)
+
+// maintainPartitions periodically ensures that partitions of the events table
+// exist for the current month plus the configured number of months ahead, so
+// that there is always somewhere for incoming events to be written. It runs
+// until the done channel is closed.
+func maintainPartitions(db *postgres.DB, ahead int, done <-chan struct{}, logger kitlog.Logger) {
+	ticker := time.NewTicker(partitionInterval)
+	defer ticker.Stop()
+
+	for {
+		err := db.CreatePartitions(time.Now(), ahead)
+		if err != nil {
+			logger.Log("msg", "failed to create partitions", "err", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Settings are the parts of our configuration which may be changed while the
+// server is running by calling Reload, rather than requiring a restart. Field
+// names match those of Config.
+type Settings struct {
+	Verbose              bool
+	StaleDeviceThreshold time.Duration
+	ArchiveAfter         time.Duration
+	CoAPCertFile         string
+	CoAPKeyFile          string
+}
+
+// Settings returns the settings currently in use.
+func (s *Server) Settings() *Settings {
+	settings := *s.settings.Load().(*Settings)
+
+	// verbose may also be switched via the admin listener
+	settings.Verbose = s.ds.Verbose()
+
+	return &settings
+}
+
+// Reload applies the given settings. If the CoAP DTLS certificate cannot be
+// loaded an error is returned, with the other settings applied and the
+// previous certificate still in use.
+func (s *Server) Reload(settings *Settings) error {
+	previous := s.Settings()
+	applied := *settings
+
+	s.ds.SetVerbose(settings.Verbose)
+
+	s.mu.Lock()
+	c := s.coap
+	s.mu.Unlock()
+
+	var err error
+
+	if c != nil && s.config.CoAPCertFile != "" {
+		err = c.ReloadCertificate(settings.CoAPCertFile, settings.CoAPKeyFile)
+		if err != nil {
+			err = errors.Wrap(err, "failed to reload coap certificate")
+
+			applied.CoAPCertFile = previous.CoAPCertFile
+			applied.CoAPKeyFile = previous.CoAPKeyFile
+		}
+	}
+
+	s.settings.Store(&applied)
+
+	s.logger.Log(
+		"msg", "reloaded settings",
+		"verbose", settings.Verbose,
+		"staleDeviceThreshold", settings.StaleDeviceThreshold,
+		"archiveAfter", settings.ArchiveAfter,
+	)
+
+	return err
+}
+
+// staleDeviceThreshold returns the current default threshold after which a
+// silent device is considered stale.
+func (s *Server) staleDeviceThreshold() time.Duration {
+	return s.Settings().StaleDeviceThreshold
+}
+
+// archiveAfter returns the current age after which events are archived.
+func (s *Server) archiveAfter() time.Duration {
+	return s.Settings().ArchiveAfter
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	tspb "github.com/golang/protobuf/ptypes/timestamp"
+	datastore "github.com/thingful/twirp-datastore-go"
+	"github.com/twitchtv/twirp"
+	pat "goji.io/pat"
+
+	"github.com/DECODEproject/iotstore/pkg/rpc"
+)
+
+// eventsPath is the path of the REST resource representing a community's
+// events, with the community id supplied as the :id parameter.
+const eventsPath = "/v1/communities/:id/events"
+
+// writeEventRequest is the JSON body accepted when creating an event via the
+// REST API. Data is the encrypted payload encoded as standard base64, which is
+// how encoding/json represents a byte slice.
+type writeEventRequest struct {
+	DeviceToken string `json:"deviceToken"`
+	Data        []byte `json:"data"`
+}
+
+// event is the JSON representation of a single encrypted event returned from
+// the REST API.
+type event struct {
+	EventTime time.Time `json:"eventTime"`
+	Data      []byte    `json:"data"`
+}
+
+// readEventsResponse is the JSON response returned when reading events via the
+// REST API. The next page is also advertised via a Link header.
+type readEventsResponse struct {
+	CommunityID    string   `json:"communityId"`
+	PageSize       uint32   `json:"pageSize"`
+	NextPageCursor string   `json:"nextPageCursor,omitempty"`
+	Events         []*event `json:"events"`
+}
+
+// WriteEventHandler is a function that closes over our Datastore instance
+// returning an http.Handler that writes a single event for the community
+// identified in the path. It is a resource style equivalent of the twirp
+// WriteData method, returning 204 No Content on success. The given twirp server
+// hooks are run as if the request had been made via twirp.
+func WriteEventHandler(ds *rpc.Datastore, hooks *twirp.ServerHooks) http.Handler {
+	return withHooks(hooks, "WriteData", func(w http.ResponseWriter, r *http.Request) error {
+		var body writeEventRequest
+
+		err := json.NewDecoder(r.Body).Decode(&body)
+		if err != nil {
+			return twirp.InvalidArgumentError("body", "must be a JSON object containing deviceToken and data")
+		}
+
+		_, err = ds.WriteData(r.Context(), &datastore.WriteRequest{
+			CommunityId: pat.Param(r, "id"),
+			DeviceToken: body.DeviceToken,
+			Data:        body.Data,
+		})
+		if err != nil {
+			return err
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+		return nil
+	})
+}
+
+// ReadEventsHandler is a function that closes over our Datastore instance
+// returning an http.Handler that returns a page of events for the community
+// identified in the path. It is a resource style equivalent of the twirp
+// ReadData method, taking the start and end of the window as RFC 3339
+// timestamps via the start and end query parameters, along with optional
+// pageSize and cursor parameters. Links to the first and next pages are
+// returned in an RFC 5988 Link header. The given twirp server hooks are run as
+// if the request had been made via twirp.
+func ReadEventsHandler(ds *rpc.Datastore, hooks *twirp.ServerHooks) http.Handler {
+	return withHooks(hooks, "ReadData", func(w http.ResponseWriter, r *http.Request) error {
+		query := r.URL.Query()
+
+		req := &datastore.ReadRequest{
+			CommunityId: pat.Param(r, "id"),
+			PageCursor:  query.Get("cursor"),
+		}
+
+		start := query.Get("start")
+		if start == "" {
+			return twirp.RequiredArgumentError("start")
+		}
+
+		var err error
+
+		req.StartTime, err = parseTimestamp(start)
+		if err != nil {
+			return twirp.InvalidArgumentError("start", "must be an RFC 3339 timestamp")
+		}
+
+		if end := query.Get("end"); end != "" {
+			req.EndTime, err = parseTimestamp(end)
+			if err != nil {
+				return twirp.InvalidArgumentError("end", "must be an RFC 3339 timestamp")
+			}
+		}
+
+		if pageSize := query.Get("pageSize"); pageSize != "" {
+			size, err := strconv.ParseUint(pageSize, 10, 32)
+			if err != nil || size == 0 {
+				return twirp.InvalidArgumentError("pageSize", fmt.Sprintf("must be between 1 and %v", rpc.MaxPageSize))
+			}
+			req.PageSize = uint32(size)
+		}
+
+		resp, err := ds.ReadData(r.Context(), req)
+		if err != nil {
+			return err
+		}
+
+		body := &readEventsResponse{
+			CommunityID:    resp.CommunityId,
+			PageSize:       resp.PageSize,
+			NextPageCursor: resp.NextPageCursor,
+			Events:         []*event{},
+		}
+
+		for _, e := range resp.Events {
+			eventTime, err := ptypes.Timestamp(e.EventTime)
+			if err != nil {
+				return twirp.InternalErrorWith(err)
+			}
+
+			body.Events = append(body.Events, &event{
+				EventTime: eventTime,
+				Data:      e.Data,
+			})
+		}
+
+		links := fmt.Sprintf(`<%s>; rel="first"`, pageURL(r.URL, ""))
+		if resp.NextPageCursor != "" {
+			links += fmt.Sprintf(`, <%s>; rel="next"`, pageURL(r.URL, resp.NextPageCursor))
+		}
+
+		w.Header().Set("Link", links)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(body)
+
+		return nil
+	})
+}
+
+// withHooks returns an http.Handler which runs the twirp server hooks for the
+// given Datastore method around h, so that REST requests are counted and traced
+// alongside twirp and gRPC requests. Any error returned by h is written to the
+// client.
+func withHooks(hooks *twirp.ServerHooks, method string, h func(http.ResponseWriter, *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, err := rpc.StartRequest(r.Context(), hooks, "Datastore", method)
+		if err == nil {
+			err = h(w, r.WithContext(ctx))
+		}
+
+		if err != nil {
+			writeError(w, err)
+		}
+
+		rpc.EndRequest(ctx, hooks, err)
+	})
+}
+
+// parseTimestamp parses an RFC 3339 timestamp into a protobuf Timestamp.
+func parseTimestamp(s string) (*tspb.Timestamp, error) {
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil, err
+	}
+
+	return ptypes.TimestampProto(t)
+}
+
+// pageURL returns the path and query of the given request URL with the cursor
+// parameter replaced, or removed if cursor is empty.
+func pageURL(u *url.URL, cursor string) string {
+	query := u.Query()
+
+	if cursor == "" {
+		query.Del("cursor")
+	} else {
+		query.Set("cursor", cursor)
+	}
+
+	page := url.URL{Path: u.Path, RawQuery: query.Encode()}
+
+	return page.String()
+}
@@ -0,0 +1,234 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/twitchtv/twirp"
+	goji "goji.io"
+	pat "goji.io/pat"
+
+	"github.com/DECODEproject/iotstore/pkg/postgres"
+	"github.com/DECODEproject/iotstore/pkg/rpc"
+	"github.com/DECODEproject/iotstore/pkg/server"
+)
+
+func restMux(ds *rpc.Datastore) http.Handler {
+	mux := goji.NewMux()
+	mux.Handle(pat.Post("/v1/communities/:id/events"), server.WriteEventHandler(ds, nil))
+	mux.Handle(pat.Get("/v1/communities/:id/events"), server.ReadEventsHandler(ds, nil))
+	return mux
+}
+
+func TestRESTValidation(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	// validation happens before the DB is used, so it need not be started
+	db := postgres.NewDB(&postgres.Config{}, logger)
+	ds := rpc.NewDatastore(db, &rpc.Config{}, logger)
+
+	mux := restMux(ds)
+
+	testcases := []struct {
+		label  string
+		method string
+		url    string
+		body   string
+	}{
+		{
+			label:  "malformed body",
+			method: http.MethodPost,
+			url:    "/v1/communities/abc123/events",
+			body:   "not json",
+		},
+		{
+			label:  "missing device token",
+			method: http.MethodPost,
+			url:    "/v1/communities/abc123/events",
+			body:   `{"data":"aGVsbG8="}`,
+		},
+		{
+			label:  "missing start",
+			method: http.MethodGet,
+			url:    "/v1/communities/abc123/events",
+		},
+		{
+			label:  "invalid start",
+			method: http.MethodGet,
+			url:    "/v1/communities/abc123/events?start=yesterday",
+		},
+		{
+			label:  "end before start",
+			method: http.MethodGet,
+			url:    "/v1/communities/abc123/events?start=2019-01-02T00:00:00Z&end=2019-01-01T00:00:00Z",
+		},
+		{
+			label:  "invalid page size",
+			method: http.MethodGet,
+			url:    "/v1/communities/abc123/events?start=2019-01-01T00:00:00Z&pageSize=0",
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			req, err := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			assert.Nil(t, err)
+
+			rr := httptest.NewRecorder()
+			mux.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+
+			var resp struct {
+				Code string `json:"code"`
+			}
+			err = json.NewDecoder(rr.Body).Decode(&resp)
+			assert.Nil(t, err)
+			assert.Equal(t, "invalid_argument", resp.Code)
+		})
+	}
+}
+
+func TestRESTHooks(t *testing.T) {
+	logger := kitlog.NewNopLogger()
+
+	db := postgres.NewDB(&postgres.Config{}, logger)
+	ds := rpc.NewDatastore(db, &rpc.Config{}, logger)
+
+	var method, status, code string
+
+	hooks := &twirp.ServerHooks{
+		RequestRouted: func(ctx context.Context) (context.Context, error) {
+			method, _ = twirp.MethodName(ctx)
+			return ctx, nil
+		},
+		Error: func(ctx context.Context, err twirp.Error) context.Context {
+			code = string(err.Code())
+			return ctx
+		},
+		ResponseSent: func(ctx context.Context) {
+			status, _ = twirp.StatusCode(ctx)
+		},
+	}
+
+	mux := goji.NewMux()
+	mux.Handle(pat.Get("/v1/communities/:id/events"), server.ReadEventsHandler(ds, hooks))
+
+	req, err := http.NewRequest(http.MethodGet, "/v1/communities/abc123/events", nil)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "ReadData", method)
+	assert.Equal(t, "invalid_argument", code)
+	assert.Equal(t, "400", status)
+}
+
+func TestRESTRoundTrip(t *testing.T) {
+	connStr := os.Getenv("IOTSTORE_DATABASE_URL")
+	logger := kitlog.NewNopLogger()
+
+	db := postgres.NewDB(&postgres.Config{ConnStr: connStr, Verbose: true}, logger)
+	ds := rpc.NewDatastore(db, &rpc.Config{Verbose: true}, logger)
+	err := ds.Start()
+	assert.Nil(t, err)
+	defer ds.Stop()
+
+	db.DB.MustExec("DELETE FROM events WHERE community_id = 'rest-community'")
+
+	mux := restMux(ds)
+
+	for _, data := range []string{"Zmlyc3Q=", "c2Vjb25k", "dGhpcmQ="} {
+		req, err := http.NewRequest(http.MethodPost, "/v1/communities/rest-community/events", strings.NewReader(`{"deviceToken":"device-1","data":"`+data+`"}`))
+		assert.Nil(t, err)
+
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		assert.Equal(t, http.StatusNoContent, rr.Code)
+	}
+
+	start := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+
+	var resp struct {
+		Events []struct {
+			Data []byte `json:"data"`
+		} `json:"events"`
+		NextPageCursor string `json:"nextPageCursor"`
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "/v1/communities/rest-community/events?pageSize=2&start="+start, nil)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	err = json.NewDecoder(rr.Body).Decode(&resp)
+	assert.Nil(t, err)
+	assert.Len(t, resp.Events, 2)
+	assert.Equal(t, "first", string(resp.Events[0].Data))
+	assert.NotEqual(t, "", resp.NextPageCursor)
+
+	links := rr.Header().Get("Link")
+	assert.Contains(t, links, `rel="first"`)
+	assert.Contains(t, links, `rel="next"`)
+
+	// follow the next link
+	next := links[strings.Index(links, ", <")+3 : strings.LastIndex(links, ">")]
+
+	req, err = http.NewRequest(http.MethodGet, next, nil)
+	assert.Nil(t, err)
+
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	resp.NextPageCursor = ""
+	err = json.NewDecoder(rr.Body).Decode(&resp)
+	assert.Nil(t, err)
+	assert.Len(t, resp.Events, 1)
+	assert.Equal(t, "third", string(resp.Events[0].Data))
+	assert.Equal(t, "", resp.NextPageCursor)
+	assert.NotContains(t, rr.Header().Get("Link"), `rel="next"`)
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/openapi.json", nil)
+	assert.Nil(t, err)
+
+	rr := httptest.NewRecorder()
+	server.OpenAPIHandler().ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var doc struct {
+		OpenAPI    string                            `json:"openapi"`
+		Paths      map[string]map[string]interface{} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]map[string]interface{} `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+
+	err = json.NewDecoder(rr.Body).Decode(&doc)
+	assert.Nil(t, err)
+	assert.Equal(t, "3.0.0", doc.OpenAPI)
+	assert.Contains(t, doc.Paths["/v1/communities/{id}/events"], "get")
+	assert.Contains(t, doc.Paths["/v1/communities/{id}/events"], "post")
+
+	assert.Equal(t, "byte", doc.Components.Schemas["WriteEventRequest"].Properties["data"]["format"])
+	assert.Equal(t, "date-time", doc.Components.Schemas["Event"].Properties["eventTime"]["format"])
+	assert.Equal(t, "array", doc.Components.Schemas["ReadEventsResponse"].Properties["events"]["type"])
+}
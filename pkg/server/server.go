@@ -2,27 +2,48 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/DECODEproject/iotcommon/middleware"
 	kitlog "github.com/go-kit/kit/log"
 	twrpprom "github.com/joneskoo/twirp-serverhook-prometheus"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	registry "github.com/thingful/retryable-registry-prometheus"
 	datastore "github.com/thingful/twirp-datastore-go"
+	"github.com/twitchtv/twirp"
 	goji "goji.io"
 	pat "goji.io/pat"
 	"golang.org/x/crypto/acme/autocert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
+	"github.com/DECODEproject/iotstore/pkg/coap"
+	iotlogger "github.com/DECODEproject/iotstore/pkg/logger"
+	"github.com/DECODEproject/iotstore/pkg/mqtt"
 	"github.com/DECODEproject/iotstore/pkg/postgres"
 	"github.com/DECODEproject/iotstore/pkg/rpc"
+	"github.com/DECODEproject/iotstore/pkg/tracing"
 	"github.com/DECODEproject/iotstore/pkg/version"
+	"github.com/DECODEproject/iotstore/pkg/webhook"
+)
+
+const (
+	// DefaultShutdownGracePeriod is the default maximum time we wait for in
+	// flight requests to complete when shutting down, chosen to fit within the
+	// default grace period Kubernetes allows before killing a pod.
+	DefaultShutdownGracePeriod = 20 * time.Second
 )
 
 var (
@@ -43,20 +64,78 @@ func init() {
 
 // Config is a struct used to pass in configuration from the calling task
 type Config struct {
-	Addr    string
-	ConnStr string
-	Verbose bool
-	Domains []string
+	Addr                 string
+	GRPCAddr             string
+	ConnStr              string
+	ReadConnStr          string
+	ReplicaMaxLag        time.Duration
+	Verbose              bool
+	Domains              []string
+	StaleDeviceThreshold time.Duration
+	PartitionsAhead      int
+	Timescale            bool
+	CompressAfter        time.Duration
+	WriteBufferInterval  time.Duration
+	WriteBufferSize      int
+	MaxOpenConns         int
+	MaxIdleConns         int
+	ConnMaxLifetime      time.Duration
+	StatementTimeout     time.Duration
+	WriteTimeout         time.Duration
+	ReadTimeout          time.Duration
+	ColdStorageURL       string
+	ArchiveAfter         time.Duration
+	MQTTBrokerURL        string
+	MQTTTopic            string
+	MQTTClientID         string
+	CoAPAddr             string
+	CoAPCertFile         string
+	CoAPKeyFile          string
+	WebhookMaxAttempts   int
+	TraceExporter        string
+	OTLPEndpoint         string
+	TraceSampleRatio     float64
+	AccessLog            bool
+	MaxCommunityLabels   int
+	ShutdownDelay        time.Duration
+	ShutdownGracePeriod  time.Duration
+	AdminAddr            string
+	NoAutoMigrate        bool
 }
 
 // Server is our top level type, contains all other components, is responsible
 // for starting and stopping them in the correct order.
 type Server struct {
 	srv    *http.Server
+	admin  *http.Server
+	grpc   *grpc.Server
+	mqtt   *mqtt.Subscriber
+	coap   *coap.Server
+	hooks  *webhook.Dispatcher
+	tracer *tracing.Tracer
 	db     *postgres.DB
 	ds     *rpc.Datastore
+	health *Health
 	logger kitlog.Logger
 	config *Config
+	done   chan struct{}
+
+	// rpcHooks are the twirp server hooks, which are also run for requests
+	// made over gRPC
+	rpcHooks *twirp.ServerHooks
+
+	// settings holds the *Settings which may be changed by Reload, mu guards
+	// coap which Reload may read while we are starting
+	settings atomic.Value
+	mu       sync.Mutex
+
+	// started is set to 1 once all our components have started, stopping is
+	// set to 1 once we start shutting down, stopOnce ensures we only shut down
+	// once with stopErr recording the result
+	started  int32
+	stopping int32
+	stopOnce sync.Once
+	stopErr  error
 }
 
 // PulseHandler is a function that closes over our DB instance returning an
@@ -65,7 +144,7 @@ type Server struct {
 // the DB connection failed.
 func PulseHandler(db *postgres.DB) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		err := db.Ping()
+		err := db.Ping(r.Context())
 		if err != nil {
 			http.Error(w, "failed to connect to DB", http.StatusInternalServerError)
 			return
@@ -76,13 +155,45 @@ func PulseHandler(db *postgres.DB) http.Handler {
 
 // NewServer returns a new simple HTTP server.
 func NewServer(config *Config, logger kitlog.Logger) *Server {
-	db := postgres.NewDB(config.ConnStr, config.Verbose, logger)
+	db := postgres.NewDB(
+		&postgres.Config{
+			ConnStr:             config.ConnStr,
+			Verbose:             config.Verbose,
+			Timescale:           config.Timescale,
+			CompressAfter:       config.CompressAfter,
+			WriteBufferInterval: config.WriteBufferInterval,
+			WriteBufferSize:     config.WriteBufferSize,
+			MaxOpenConns:        config.MaxOpenConns,
+			MaxIdleConns:        config.MaxIdleConns,
+			ConnMaxLifetime:     config.ConnMaxLifetime,
+			StatementTimeout:    config.StatementTimeout,
+			ReadConnStr:         config.ReadConnStr,
+			ReplicaMaxLag:       config.ReplicaMaxLag,
+			ColdStorageURL:      config.ColdStorageURL,
+			MaxCommunityLabels:  config.MaxCommunityLabels,
+			NoAutoMigrate:       config.NoAutoMigrate,
+		},
+		logger,
+	)
 
-	ds := rpc.NewDatastore(db, config.Verbose, logger)
-	hooks := twrpprom.NewServerHooks(registry.DefaultRegisterer)
+	ds := rpc.NewDatastore(
+		db,
+		&rpc.Config{
+			Verbose:      config.Verbose,
+			WriteTimeout: config.WriteTimeout,
+			ReadTimeout:  config.ReadTimeout,
+		},
+		logger,
+	)
+	hooks := twirp.ChainHooks(
+		twrpprom.NewServerHooks(registry.DefaultRegisterer),
+		tracing.ServerHooks(),
+	)
 
 	twirpHandler := datastore.NewDatastoreServer(ds, hooks)
 
+	health := NewHealth(db, postgres.LatestMigration())
+
 	// set our dummy metric with the version info
 	buildInfo.WithLabelValues(version.BinaryName, version.Version, version.BuildDate).Set(1)
 
@@ -90,42 +201,191 @@ func NewServer(config *Config, logger kitlog.Logger) *Server {
 
 	// set up the handlers
 	mux.Handle(pat.Post(datastore.DatastorePathPrefix+"*"), twirpHandler)
+	mux.Handle(pat.Post(eventsPath), WriteEventHandler(ds, hooks))
+	mux.Handle(pat.Get(eventsPath), ReadEventsHandler(ds, hooks))
+	mux.Handle(pat.Get("/openapi.json"), OpenAPIHandler())
 	mux.Handle(pat.Get("/pulse"), PulseHandler(ds.DB))
-	mux.Handle(pat.Get("/metrics"), promhttp.Handler())
+	mux.Handle(pat.Get("/healthz"), health.LivenessHandler())
+	mux.Handle(pat.Get("/readyz"), health.ReadinessHandler())
+	mux.Handle(pat.Get("/status"), health.StatusHandler())
+	s := &Server{
+		db:       db,
+		ds:       ds,
+		health:   health,
+		logger:   kitlog.With(logger, "module", "server"),
+		config:   config,
+		done:     make(chan struct{}),
+		rpcHooks: hooks,
+	}
+
+	s.settings.Store(&Settings{
+		StaleDeviceThreshold: config.StaleDeviceThreshold,
+		ArchiveAfter:         config.ArchiveAfter,
+		CoAPCertFile:         config.CoAPCertFile,
+		CoAPKeyFile:          config.CoAPKeyFile,
+	})
+
+	// if we have an admin listener metrics are only served there, stale devices
+	// are only ever served there as the response includes device tokens
+	if config.AdminAddr != "" {
+		s.admin = &http.Server{
+			Addr:    config.AdminAddr,
+			Handler: newAdminHandler(config, db, ds, health, s.Settings, s.staleDeviceThreshold, kitlog.With(logger, "module", "admin")),
+		}
+	} else {
+		mux.Handle(pat.Get("/metrics"), promhttp.Handler())
+	}
 
 	// add our middleware
 	mux.Use(middleware.RequestIDMiddleware)
 
+	// add our tracing middleware, which reads the request ID
+	mux.Use(tracing.Middleware)
+
+	// add our access log middleware, which reads the request and trace IDs
+	if config.AccessLog {
+		mux.Use(iotlogger.AccessLog(logger))
+	}
+
 	// add our metrics tracking middleware
 	metricsMiddleware := middleware.MetricsMiddleware("decode", "datastore", registry.DefaultRegisterer)
 	mux.Use(metricsMiddleware)
 
 	// create our http.Server instance
-	srv := &http.Server{
+	s.srv = &http.Server{
 		Addr:    config.Addr,
 		Handler: mux,
 	}
 
 	// return the instantiated server
-	return &Server{
-		srv:    srv,
-		db:     db,
-		ds:     ds,
-		logger: kitlog.With(logger, "module", "server"),
-		config: config,
-	}
+	return s
 }
 
-// Start starts the server running. We also create a channel listening for
-// interrupt signals before gracefully shutting down.
+// Start starts the server running, blocking until we receive an interrupt or
+// terminate signal, Stop is called, or one of our listeners fails, at which
+// point we shut down gracefully. An error is returned if we fail to start, if
+// a listener fails, or if we fail to shut down cleanly. If we fail to start
+// any components already started are stopped before returning.
 func (s *Server) Start() error {
+	if s.config.TraceExporter != "" {
+		tracer, err := tracing.NewTracer(
+			&tracing.Config{
+				Exporter:    s.config.TraceExporter,
+				Endpoint:    s.config.OTLPEndpoint,
+				ServiceName: version.BinaryName,
+				SampleRatio: s.config.TraceSampleRatio,
+			},
+			s.logger,
+		)
+		if err != nil {
+			s.Stop()
+			return err
+		}
+
+		s.tracer = tracer
+		s.tracer.Start()
+	}
+
 	err := s.ds.Start()
 	if err != nil {
+		s.Stop()
 		return err
 	}
 
+	var m *autocert.Manager
+
+	if isTLSEnabled(s.config) {
+		m = &autocert.Manager{
+			Cache:      s.db,
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(s.config.Domains...),
+		}
+	}
+
+	if s.config.MQTTBrokerURL != "" {
+		s.mqtt, err = mqtt.NewSubscriber(
+			&mqtt.Config{
+				BrokerURL: s.config.MQTTBrokerURL,
+				Topic:     s.config.MQTTTopic,
+				ClientID:  s.config.MQTTClientID,
+			},
+			s.ds,
+			s.logger,
+		)
+		if err != nil {
+			s.Stop()
+			return err
+		}
+
+		s.mqtt.Start()
+		s.health.SetMQTT(s.mqtt)
+	}
+
+	if s.config.CoAPAddr != "" {
+		c, err := coap.NewServer(
+			&coap.Config{
+				Addr:     s.config.CoAPAddr,
+				CertFile: s.config.CoAPCertFile,
+				KeyFile:  s.config.CoAPKeyFile,
+			},
+			s.ds,
+			s.logger,
+		)
+		if err != nil {
+			s.Stop()
+			return err
+		}
+
+		err = c.Start()
+		if err != nil {
+			s.Stop()
+			return err
+		}
+
+		s.mu.Lock()
+		s.coap = c
+		s.mu.Unlock()
+	}
+
+	s.hooks = webhook.NewDispatcher(
+		&webhook.Config{
+			MaxAttempts: s.config.WebhookMaxAttempts,
+		},
+		s.db,
+		s.logger,
+	)
+	s.hooks.Start()
+
+	go monitorDevices(s.db, s.staleDeviceThreshold, s.done, s.logger)
+	go monitorTables(s.db, s.done, s.logger)
+	go maintainPartitions(s.db, s.config.PartitionsAhead, s.done, s.logger)
+
+	if s.config.ColdStorageURL != "" {
+		go maintainArchive(s.db, s.archiveAfter, s.done, s.logger)
+	}
+
+	// from here on a failure is in serving rather than starting
+	atomic.StoreInt32(&s.started, 1)
+
 	stopChan := make(chan os.Signal, 1)
-	signal.Notify(stopChan, os.Interrupt)
+	signal.Notify(stopChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stopChan)
+
+	// bind every listener before serving any of them, so that we never report
+	// that we are ready while failing to listen
+	l, err := s.listen(m)
+	if err != nil {
+		s.Stop()
+		return err
+	}
+
+	// errs receives any error from our listeners, buffered so that they can
+	// exit if we are already shutting down
+	errs := make(chan error, 3)
+
+	if l.grpc != nil {
+		s.startGRPC(l.grpc, m, errs)
+	}
 
 	go func() {
 		s.logger.Log(
@@ -136,43 +396,233 @@ func (s *Server) Start() error {
 			"tlsEnabled", isTLSEnabled(s.config),
 		)
 
-		if isTLSEnabled(s.config) {
-			m := &autocert.Manager{
-				Cache:      s.db,
-				Prompt:     autocert.AcceptTOS,
-				HostPolicy: autocert.HostWhitelist(s.config.Domains...),
-			}
+		err := s.srv.Serve(l.http)
+		if err != http.ErrServerClosed {
+			errs <- errors.Wrap(err, "failed to serve http")
+		}
+	}()
 
-			s.srv.TLSConfig = m.TLSConfig()
+	if l.admin != nil {
+		go func() {
+			s.logger.Log("listenAddr", s.admin.Addr, "msg", "starting admin server")
 
-			if err := s.srv.ListenAndServeTLS("", ""); err != nil {
-				s.logger.Log("err", err)
-				os.Exit(1)
-			}
-		} else {
-			if err := s.srv.ListenAndServe(); err != nil {
-				s.logger.Log("err", err)
-				os.Exit(1)
+			err := s.admin.Serve(l.admin)
+			if err != http.ErrServerClosed {
+				errs <- errors.Wrap(err, "failed to serve admin http")
 			}
-		}
-	}()
+		}()
+	}
+
+	// every listener is bound and the datastore started, having checked the
+	// database is reachable and migrated, so we are ready for traffic
+	s.health.SetReady()
 
-	<-stopChan
-	return s.Stop()
+	select {
+	case sig := <-stopChan:
+		s.logger.Log("msg", "received signal", "signal", sig)
+		return s.Stop()
+	case err := <-errs:
+		s.logger.Log("msg", "listener failed", "err", err)
+		s.Stop()
+		return err
+	case <-s.done:
+		// Stop was called directly, wait for it to complete
+		return s.Stop()
+	}
 }
 
-// Stop stops the server running.
+// Stop shuts the server down gracefully. We first fail our readiness checks,
+// waiting for the configured delay so that load balancers stop sending us
+// traffic, then stop accepting requests and wait up to the grace period for
+// those in flight to complete. Only then do we stop our background tasks,
+// flush any buffered writes and close the database. It is safe to call Stop
+// more than once, with every call waiting for the shut down to complete.
 func (s *Server) Stop() error {
+	s.stopOnce.Do(func() {
+		s.stopErr = s.stop()
+	})
+
+	return s.stopErr
+}
+
+// Started returns true if the server started all its components, so that any
+// error returned by Start came from serving requests or shutting down rather
+// than from starting.
+func (s *Server) Started() bool {
+	return atomic.LoadInt32(&s.started) == 1
+}
+
+// Stopped returns true if the server has started shutting down.
+func (s *Server) Stopped() bool {
+	return atomic.LoadInt32(&s.stopping) == 1
+}
+
+// stop does the work of Stop, returning the first error encountered.
+func (s *Server) stop() error {
+	atomic.StoreInt32(&s.stopping, 1)
+
 	s.logger.Log("msg", "stopping")
-	ctx, cancelFn := context.WithTimeout(context.Background(), 5*time.Second)
+
+	// there is no need to wait for load balancers if we never became ready
+	wasReady := s.health.isReady()
+
+	s.health.SetStopping()
+
+	if wasReady && s.config.ShutdownDelay > 0 {
+		s.logger.Log("msg", "waiting before draining requests", "delay", s.config.ShutdownDelay)
+		time.Sleep(s.config.ShutdownDelay)
+	}
+
+	gracePeriod := s.config.ShutdownGracePeriod
+	if gracePeriod <= 0 {
+		gracePeriod = DefaultShutdownGracePeriod
+	}
+
+	ctx, cancelFn := context.WithTimeout(context.Background(), gracePeriod)
 	defer cancelFn()
 
-	err := s.ds.Stop()
+	var result error
+
+	err := s.srv.Shutdown(ctx)
 	if err != nil {
-		return err
+		s.srv.Close()
+		result = errors.Wrap(err, "failed to drain http requests")
+	}
+
+	if s.grpc != nil {
+		s.stopGRPC(ctx)
+	}
+
+	if s.mqtt != nil {
+		s.mqtt.Stop()
+	}
+
+	if s.coap != nil {
+		s.coap.Stop()
+	}
+
+	// the admin listener stays up while draining so that we can be observed
+	if s.admin != nil {
+		err = s.admin.Shutdown(ctx)
+		if err != nil {
+			s.admin.Close()
+		}
+	}
+
+	close(s.done)
+
+	if s.hooks != nil {
+		s.hooks.Stop()
+	}
+
+	// with no requests in flight, flush any buffered writes and close the db
+	err = s.ds.Stop()
+	if err != nil && result == nil {
+		result = errors.Wrap(err, "failed to stop datastore")
+	}
+
+	if s.tracer != nil {
+		s.tracer.Stop()
+	}
+
+	s.logger.Log("msg", "stopped")
+
+	return result
+}
+
+// startGRPC starts a gRPC server exposing the datastore on its own listener.
+// If TLS is enabled the gRPC server uses the same LetsEncrypt certificates as
+// the HTTP server. If the server fails the error is sent to errs.
+func (s *Server) startGRPC(lis net.Listener, m *autocert.Manager, errs chan<- error) {
+	opts := []grpc.ServerOption{}
+	if m != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(m.TLSConfig())))
+	}
+
+	s.grpc = rpc.NewGRPCServer(s.ds, s.rpcHooks, opts...)
+
+	go func() {
+		s.logger.Log(
+			"listenAddr", s.config.GRPCAddr,
+			"msg", "starting gRPC server",
+			"tlsEnabled", m != nil,
+		)
+
+		if err := s.grpc.Serve(lis); err != nil {
+			errs <- errors.Wrap(err, "failed to serve gRPC")
+		}
+	}()
+}
+
+// stopGRPC stops the gRPC server, waiting for in flight RPCs to complete
+// until the context is done, at which point any remaining are cancelled.
+func (s *Server) stopGRPC(ctx context.Context) {
+	stopped := make(chan struct{})
+
+	go func() {
+		s.grpc.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.grpc.Stop()
+	}
+}
+
+// listeners holds the bound listeners on which we serve HTTP, gRPC and the
+// admin endpoints. The gRPC and admin listeners are nil unless configured.
+type listeners struct {
+	http  net.Listener
+	grpc  net.Listener
+	admin net.Listener
+}
+
+// listen binds each of our listeners, wrapping the HTTP listener with TLS if
+// the given autocert manager is not nil. If any listener cannot be bound, those
+// already bound are closed and an error returned.
+func (s *Server) listen(m *autocert.Manager) (*listeners, error) {
+	l := &listeners{}
+
+	var err error
+
+	l.http, err = net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to listen for http connections")
+	}
+
+	if m != nil {
+		s.srv.TLSConfig = m.TLSConfig()
+		l.http = tls.NewListener(l.http, s.srv.TLSConfig)
+	}
+
+	if s.config.GRPCAddr != "" {
+		l.grpc, err = net.Listen("tcp", s.config.GRPCAddr)
+		if err != nil {
+			l.close()
+			return nil, errors.Wrap(err, "failed to listen for gRPC connections")
+		}
+	}
+
+	if s.admin != nil {
+		l.admin, err = net.Listen("tcp", s.admin.Addr)
+		if err != nil {
+			l.close()
+			return nil, errors.Wrap(err, "failed to listen for admin http connections")
+		}
 	}
 
-	return s.srv.Shutdown(ctx)
+	return l, nil
+}
+
+// close closes every bound listener.
+func (l *listeners) close() {
+	for _, lis := range []net.Listener{l.http, l.grpc, l.admin} {
+		if lis != nil {
+			lis.Close()
+		}
+	}
 }
 
 // isTLSEnabled returns true if the passed in configuration object contains both
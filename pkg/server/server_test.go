@@ -1,15 +1,19 @@
 package server_test
 
 import (
+	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	kitlog "github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/DECODEproject/iotstore/pkg/postgres"
+	"github.com/DECODEproject/iotstore/pkg/rpc"
 	"github.com/DECODEproject/iotstore/pkg/server"
 )
 
@@ -17,7 +21,7 @@ func TestPulseHandler(t *testing.T) {
 	connStr := os.Getenv("IOTSTORE_DATABASE_URL")
 	logger := kitlog.NewNopLogger()
 
-	db := postgres.NewDB(connStr, true, logger)
+	db := postgres.NewDB(&postgres.Config{ConnStr: connStr, Verbose: true}, logger)
 	err := db.Start()
 	assert.Nil(t, err)
 
@@ -31,3 +35,175 @@ func TestPulseHandler(t *testing.T) {
 
 	assert.Equal(t, http.StatusOK, rr.Code)
 }
+
+func TestStaleDevicesHandler(t *testing.T) {
+	connStr := os.Getenv("IOTSTORE_DATABASE_URL")
+	logger := kitlog.NewNopLogger()
+
+	db := postgres.NewDB(&postgres.Config{ConnStr: connStr, Verbose: true}, logger)
+	ds := rpc.NewDatastore(db, &rpc.Config{Verbose: true}, logger)
+	err := ds.Start()
+	assert.Nil(t, err)
+	defer ds.Stop()
+
+	db.DB.MustExec("DELETE FROM devices")
+	db.DB.MustExec("INSERT INTO devices (community_id, device_token, last_seen_at) VALUES ($1, $2, $3)", "abc123", "device-1", time.Now().Add(time.Hour*-2))
+
+	testcases := []struct {
+		label          string
+		url            string
+		expectedStatus int
+		expectedCount  int
+	}{
+		{
+			label:          "default threshold",
+			url:            "/devices/stale?community_id=abc123",
+			expectedStatus: http.StatusOK,
+			expectedCount:  1,
+		},
+		{
+			label:          "custom threshold",
+			url:            "/devices/stale?community_id=abc123&threshold=3h",
+			expectedStatus: http.StatusOK,
+			expectedCount:  0,
+		},
+		{
+			label:          "missing community_id",
+			url:            "/devices/stale",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			label:          "invalid threshold",
+			url:            "/devices/stale?community_id=abc123&threshold=foo",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	handler := server.StaleDevicesHandler(ds, time.Hour)
+
+	for _, tc := range testcases {
+		t.Run(tc.label, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, tc.url, nil)
+			assert.Nil(t, err)
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+
+			if tc.expectedStatus == http.StatusOK {
+				var resp struct {
+					Devices []interface{} `json:"devices"`
+				}
+				err = json.NewDecoder(rr.Body).Decode(&resp)
+				assert.Nil(t, err)
+				assert.Len(t, resp.Devices, tc.expectedCount)
+			}
+		})
+	}
+}
+
+func TestServerStop(t *testing.T) {
+	connStr := os.Getenv("IOTSTORE_DATABASE_URL")
+
+	s := server.NewServer(
+		&server.Config{
+			Addr:                "127.0.0.1:18080",
+			ConnStr:             connStr,
+			ShutdownGracePeriod: time.Second,
+		},
+		kitlog.NewNopLogger(),
+	)
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Start()
+	}()
+
+	ready := false
+	for i := 0; i < 50 && !ready; i++ {
+		resp, err := http.Get("http://127.0.0.1:18080/readyz")
+		if err == nil {
+			ready = resp.StatusCode == http.StatusOK
+			resp.Body.Close()
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.True(t, ready)
+
+	err := s.Stop()
+	assert.Nil(t, err)
+	assert.True(t, s.Stopped())
+
+	select {
+	case err := <-errs:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+
+	_, err = http.Get("http://127.0.0.1:18080/readyz")
+	assert.NotNil(t, err)
+}
+
+func TestServerListenError(t *testing.T) {
+	connStr := os.Getenv("IOTSTORE_DATABASE_URL")
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer lis.Close()
+
+	s := server.NewServer(
+		&server.Config{
+			Addr:    lis.Addr().String(),
+			ConnStr: connStr,
+		},
+		kitlog.NewNopLogger(),
+	)
+
+	// the address is in use so we return an error rather than exiting, and as
+	// our components started we should not be retried
+	err = s.Start()
+	assert.NotNil(t, err)
+	assert.True(t, s.Started())
+	assert.True(t, s.Stopped())
+}
+
+func TestServerStartError(t *testing.T) {
+	s := server.NewServer(
+		&server.Config{
+			Addr:          "127.0.0.1:0",
+			TraceExporter: "zipkin",
+		},
+		kitlog.NewNopLogger(),
+	)
+
+	// we fail before starting anything, so may be retried
+	err := s.Start()
+	assert.NotNil(t, err)
+	assert.False(t, s.Started())
+	assert.True(t, s.Stopped())
+}
+
+func TestServerReload(t *testing.T) {
+	s := server.NewServer(
+		&server.Config{
+			Addr:                 "127.0.0.1:0",
+			StaleDeviceThreshold: time.Hour,
+			ArchiveAfter:         24 * time.Hour,
+		},
+		kitlog.NewNopLogger(),
+	)
+
+	assert.Equal(t, &server.Settings{StaleDeviceThreshold: time.Hour, ArchiveAfter: 24 * time.Hour}, s.Settings())
+
+	settings := &server.Settings{
+		Verbose:              true,
+		StaleDeviceThreshold: 2 * time.Hour,
+		ArchiveAfter:         48 * time.Hour,
+	}
+
+	err := s.Reload(settings)
+	assert.Nil(t, err)
+	assert.Equal(t, settings, s.Settings())
+}
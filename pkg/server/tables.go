@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	registry "github.com/thingful/retryable-registry-prometheus"
+
+	"github.com/DECODEproject/iotstore/pkg/postgres"
+)
+
+// tableMonitorInterval is how often we sample the size of each table in order
+// to update our prometheus gauge. Sizes change slowly, and reading them means
+// statting every file of every table, so this needn't be frequent.
+const tableMonitorInterval = 5 * time.Minute
+
+var (
+	// tableSize is a GaugeVec used to expose the size on disk of each table,
+	// including its indexes.
+	tableSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "table_size_bytes",
+			Help:      "Total size on disk of each table including indexes",
+		}, []string{"table"},
+	)
+)
+
+func init() {
+	registry.MustRegister(tableSize)
+}
+
+// monitorTables periodically samples the size of each table, exposing the
+// result via our prometheus gauge. It runs until the done channel is closed.
+func monitorTables(db *postgres.DB, done <-chan struct{}, logger kitlog.Logger) {
+	ticker := time.NewTicker(tableMonitorInterval)
+	defer ticker.Stop()
+
+	for {
+		sizes, err := db.TableSizes(context.Background())
+		if err != nil {
+			logger.Log("msg", "failed to read table sizes", "err", err)
+		} else {
+			// reset so that dropped tables are no longer reported
+			tableSize.Reset()
+
+			for table, size := range sizes {
+				tableSize.WithLabelValues(table).Set(float64(size))
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package tasks
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/DECODEproject/iotstore/pkg/logger"
+	"github.com/DECODEproject/iotstore/pkg/redact"
+	"github.com/DECODEproject/iotstore/pkg/reporting"
+	"github.com/DECODEproject/iotstore/pkg/version"
+)
+
+// logLevel is the minimum level of the lines written by every logger we
+// create, which may be changed by reloading the configuration.
+var logLevel = &logger.LevelVar{}
+
+// loadConfig binds the flags of the command being run to viper, then reads
+// the config file if one was given. Flags are bound here rather than when they
+// are defined as several commands define flags with the same name, and only
+// the last binding of a name would be used. Values are taken from flags given
+// on the command line, then the environment, then the config file, and lastly
+// the default value of the flag.
+func loadConfig(cmd *cobra.Command) error {
+	err := viper.BindPFlags(cmd.Flags())
+	if err != nil {
+		return errors.Wrap(err, "failed to bind flags")
+	}
+
+	path := viper.GetString("config")
+	if path == "" {
+		return nil
+	}
+
+	viper.SetConfigFile(path)
+
+	err = viper.ReadInConfig()
+	if err != nil {
+		return errors.Wrap(err, "failed to read config file")
+	}
+
+	return nil
+}
+
+// configure applies the settings shared by all commands: the log level, the
+// redaction policy and the error reporter. It is called again whenever the
+// configuration is reloaded.
+func configure() error {
+	err := logLevel.Set(viper.GetString("log-level"))
+	if err != nil {
+		return err
+	}
+
+	policy, err := redact.NewPolicy(
+		&redact.Config{
+			DeviceTokens: viper.GetString("redact-device-tokens"),
+			CommunityIDs: viper.GetString("redact-community-ids"),
+			Payloads:     viper.GetString("redact-payloads"),
+			Salt:         viper.GetString("redact-salt"),
+		},
+	)
+	if err != nil {
+		return err
+	}
+
+	// reported errors are logged to stderr as export may write to stdout
+	errLogger, err := newLogger(os.Stderr)
+	if err != nil {
+		return err
+	}
+
+	if policy.RandomSalt() {
+		level.Warn(errLogger).Log("msg", "no redaction salt given, hashing redacted values with a random salt so hashes will differ between runs; set --redact-salt to keep them stable")
+	}
+
+	reporter, err := reporting.New(
+		&reporting.Config{
+			Reporter: viper.GetString("error-reporter"),
+			Limit:    viper.GetInt("error-report-limit"),
+			Release:  version.Version,
+			Tags:     map[string]string{"component": "datastore"},
+		},
+		errLogger,
+	)
+	if err != nil {
+		return err
+	}
+
+	redact.SetPolicy(policy)
+
+	if !reporting.SwapReporter(reporter, flushTimeout) {
+		level.Warn(errLogger).Log("msg", "failed to flush errors reported before reloading")
+	}
+
+	return nil
+}
+
+// reloadConfig reads the config file again, if one was given, and applies the
+// shared settings. Nothing is applied if the file cannot be read or contains
+// invalid settings.
+func reloadConfig() error {
+	if viper.ConfigFileUsed() != "" {
+		err := viper.ReadInConfig()
+		if err != nil {
+			return errors.Wrap(err, "failed to read config file")
+		}
+	}
+
+	return configure()
+}
+
+// watchConfig calls reload whenever we receive SIGHUP, or the config file
+// changes, until the done channel is closed. As with viper's own watcher we
+// watch the directory containing the file, so that editors which replace the
+// file and Kubernetes ConfigMaps, which swap a symlink, are both noticed.
+// Reloads happen in this goroutine so that viper is never read concurrently.
+func watchConfig(done <-chan struct{}, logger kitlog.Logger, reload func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var events chan fsnotify.Event
+
+	configFile := viper.ConfigFileUsed()
+	if configFile != "" {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			logger.Log("msg", "failed to watch config file", "err", err)
+		} else {
+			defer watcher.Close()
+
+			configFile = filepath.Clean(configFile)
+
+			err = watcher.Add(filepath.Dir(configFile))
+			if err != nil {
+				logger.Log("msg", "failed to watch config file", "err", err)
+			} else {
+				events = watcher.Events
+			}
+		}
+	}
+
+	realConfigFile, _ := filepath.EvalSymlinks(configFile)
+
+	for {
+		select {
+		case <-hup:
+			logger.Log("msg", "received SIGHUP, reloading configuration")
+			reload()
+		case event := <-events:
+			currentConfigFile, _ := filepath.EvalSymlinks(configFile)
+
+			written := filepath.Clean(event.Name) == configFile && event.Op&(fsnotify.Write|fsnotify.Create) != 0
+			swapped := currentConfigFile != "" && currentConfigFile != realConfigFile
+
+			if written || swapped {
+				realConfigFile = currentConfigFile
+
+				logger.Log("msg", "config file changed, reloading configuration", "path", configFile)
+				reload()
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// databaseURL returns the URL of the database given by the --database-url
+// flag, the environment or the config file.
+func databaseURL() (string, error) {
+	connStr := viper.GetString("database-url")
+	if connStr == "" {
+		return "", errors.New("Must provide database url")
+	}
+
+	return connStr, nil
+}
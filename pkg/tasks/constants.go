@@ -1,7 +0,0 @@
-package tasks
-
-const (
-	// ConnStrKey is the environment variable which must hold the database URL to
-	// which we want to connect.
-	ConnStrKey = "IOTSTORE_DATABASE_URL"
-)
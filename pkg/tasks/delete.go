@@ -1,12 +1,13 @@
 package tasks
 
 import (
+	"os"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 
-	"github.com/DECODEproject/iotstore/pkg/logger"
 	"github.com/DECODEproject/iotstore/pkg/postgres"
 )
 
@@ -16,6 +17,7 @@ func init() {
 	deleteCmd.Flags().StringP("before", "b", "", "timestamp expressed as a RFC3339/ISO8601 string")
 	deleteCmd.MarkFlagRequired("before")
 	deleteCmd.Flags().BoolP("execute", "e", false, "boolean flag that if set executes the deletion")
+	deleteCmd.Flags().String("cold-storage-url", "", "cold storage location from which archived events should also be deleted")
 }
 
 var deleteCmd = &cobra.Command{
@@ -26,17 +28,17 @@ var deleteCmd = &cobra.Command{
 The datastore currently permanently stores all incoming events into a
 PostgreSQL database, so this helper command is intended to allow the operator
 of the system to remove data that has been previously stored in order to free
-up space. It is the callers responsiblity to ensure data is adequately backed
-up as this command will irrevocably delete records from PostgreSQL.`,
+up space. Monthly partitions of the events table that lie entirely before the
+given timestamp are dropped as a whole, with only the remaining events being
+deleted individually. Events that have been archived to cold storage are also
+deleted if the cold storage location is given, and queued or abandoned webhook
+deliveries of old events are removed too. It is the callers responsiblity
+to ensure data is adequately backed up as this command will irrevocably delete
+records from PostgreSQL.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		connStr, err := GetFromEnv(ConnStrKey)
+		connStr, err := databaseURL()
 		if err != nil {
-			return errors.Wrap(err, "failed to get environment variable")
-		}
-
-		verbose, err := cmd.Flags().GetBool("verbose")
-		if err != nil {
-			return errors.Wrap(err, "failed to read verbose flag")
+			return err
 		}
 
 		execute, err := cmd.Flags().GetBool("execute")
@@ -54,9 +56,19 @@ up as this command will irrevocably delete records from PostgreSQL.`,
 			return err
 		}
 
-		logger := logger.NewLogger()
+		logger, err := newLogger(os.Stdout)
+		if err != nil {
+			return err
+		}
 
-		db := postgres.NewDB(connStr, verbose, logger)
+		db := postgres.NewDB(
+			&postgres.Config{
+				ConnStr:        connStr,
+				Verbose:        viper.GetBool("verbose"),
+				ColdStorageURL: viper.GetString("cold-storage-url"),
+			},
+			logger,
+		)
 
 		err = db.Start()
 		if err != nil {
@@ -0,0 +1,307 @@
+package tasks
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/DECODEproject/iotstore/pkg/archive"
+	"github.com/DECODEproject/iotstore/pkg/postgres"
+	"github.com/DECODEproject/iotstore/pkg/redact"
+	"github.com/DECODEproject/iotstore/pkg/version"
+)
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().String("community-id", "", "the community whose events should be exported")
+	exportCmd.MarkFlagRequired("community-id")
+	exportCmd.Flags().String("start", "", "export events recorded at or after this RFC3339/ISO8601 timestamp")
+	exportCmd.Flags().String("end", "", "export events recorded before this RFC3339/ISO8601 timestamp")
+	exportCmd.Flags().StringP("format", "f", archive.NDJSON, fmt.Sprintf("archive format, one of: %s", strings.Join(archive.Formats, ", ")))
+	exportCmd.Flags().StringP("output", "o", "-", "file to which the archive is written, or - for stdout")
+	exportCmd.Flags().String("manifest", "", "file to which the manifest is written (defaults to <output>.manifest.json, required when writing to stdout)")
+	exportCmd.Flags().String("cold-storage-url", "", "cold storage location from which archived events are also exported")
+	exportCmd.Flags().String("read-database-url", "", "optional URL of a Postgres streaming replica from which events are read")
+	exportCmd.Flags().Duration("replica-max-lag", 30*time.Second, "replication lag beyond which, or if the replica stops streaming from the primary, events are read from the primary, zero disables fallback")
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export a community's events to a portable archive",
+	Long: fmt.Sprintf(`This task exports the encrypted events of a single community to an archive
+file, allowing the data to be handed to its owners or moved to another
+datastore.
+
+Events are written with their IDs, timestamps and device tokens, in the order
+in which they were recorded. The archive may be written as newline delimited
+JSON, as length delimited protocol buffer messages (see archive.proto), or as
+CSV. Alongside the archive we write a JSON manifest containing the number of
+exported events and the SHA-256 of the archive, to the file given by
+--manifest or by default <output>.manifest.json. When writing the archive to
+stdout --manifest must be given.
+
+Events which have been archived to cold storage are included in the export, so
+if the community has any archived events the cold storage location must be
+given with --cold-storage-url, otherwise the export fails rather than silently
+leaving them out.
+
+Events are read from the replica given by --read-database-url if set, so a
+large export does not load the primary. The export never changes the schema,
+so it fails if the database has not been migrated to the version it requires.
+
+The archive and manifest are written to temporary files which are renamed once
+the export has succeeded, so a failed export never leaves a truncated archive
+behind.
+
+For example:
+
+    $ %s export --community-id abc123 --start 2019-01-01T00:00:00Z -o abc123.ndjson`, version.BinaryName),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		connStr, err := databaseURL()
+		if err != nil {
+			return err
+		}
+
+		communityID, err := cmd.Flags().GetString("community-id")
+		if err != nil {
+			return errors.Wrap(err, "failed to read required \"community-id\" parameter")
+		}
+
+		startTime, err := parseTimeFlag(cmd, "start")
+		if err != nil {
+			return err
+		}
+
+		endTime, err := parseTimeFlag(cmd, "end")
+		if err != nil {
+			return err
+		}
+
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			return errors.Wrap(err, "failed to read format flag")
+		}
+
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return errors.Wrap(err, "failed to read output flag")
+		}
+
+		manifestPath, err := cmd.Flags().GetString("manifest")
+		if err != nil {
+			return errors.Wrap(err, "failed to read manifest flag")
+		}
+
+		if manifestPath == "" {
+			if output == "-" {
+				return errors.New("Must provide --manifest when writing to stdout")
+			}
+			manifestPath = output + ".manifest.json"
+		}
+
+		// logs go to stderr so they can't corrupt an archive written to stdout
+		logger, err := newLogger(os.Stderr)
+		if err != nil {
+			return err
+		}
+
+		var (
+			out     io.Writer = os.Stdout
+			outFile *atomicFile
+		)
+
+		if output != "-" {
+			outFile, err = createAtomic(output)
+			if err != nil {
+				return errors.Wrap(err, "failed to create output file")
+			}
+			defer outFile.Abort()
+			out = outFile
+		}
+
+		hash := sha256.New()
+		buf := bufio.NewWriter(io.MultiWriter(out, hash))
+
+		w, err := archive.NewWriter(format, buf)
+		if err != nil {
+			return err
+		}
+
+		db := postgres.NewDB(
+			&postgres.Config{
+				ConnStr:        connStr,
+				ReadConnStr:    viper.GetString("read-database-url"),
+				ReplicaMaxLag:  viper.GetDuration("replica-max-lag"),
+				ColdStorageURL: viper.GetString("cold-storage-url"),
+				NoAutoMigrate:  true,
+			},
+			logger,
+		)
+
+		err = db.Start()
+		if err != nil {
+			return err
+		}
+		defer db.Stop()
+
+		var count int64
+
+		err = db.ExportData(context.Background(), communityID, startTime, endTime, func(e *postgres.Event) error {
+			count++
+			return w.Write(&archive.Record{
+				ID:          e.ID,
+				CommunityID: e.CommunityID,
+				DeviceToken: e.DeviceToken,
+				RecordedAt:  e.RecordedAt,
+				Data:        e.Data,
+			})
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to export events")
+		}
+
+		err = w.Close()
+		if err != nil {
+			return errors.Wrap(err, "failed to close archive")
+		}
+
+		err = buf.Flush()
+		if err != nil {
+			return errors.Wrap(err, "failed to write archive")
+		}
+
+		manifest := &archive.Manifest{
+			CommunityID: communityID,
+			Format:      format,
+			StartTime:   startTime,
+			EndTime:     endTime,
+			Count:       count,
+			SHA256:      hex.EncodeToString(hash.Sum(nil)),
+			CreatedAt:   time.Now().UTC(),
+		}
+
+		manifestFile, err := createAtomic(manifestPath)
+		if err != nil {
+			return errors.Wrap(err, "failed to create manifest file")
+		}
+		defer manifestFile.Abort()
+
+		enc := json.NewEncoder(manifestFile)
+		enc.SetIndent("", "  ")
+
+		err = enc.Encode(manifest)
+		if err != nil {
+			return errors.Wrap(err, "failed to write manifest")
+		}
+
+		if outFile != nil {
+			err = outFile.Commit()
+			if err != nil {
+				return errors.Wrap(err, "failed to write output file")
+			}
+		}
+
+		err = manifestFile.Commit()
+		if err != nil {
+			return errors.Wrap(err, "failed to write manifest file")
+		}
+
+		logger.Log("msg", "exported events", "communityID", redact.CommunityID(communityID), "count", count, "sha256", manifest.SHA256)
+
+		return nil
+	},
+}
+
+// parseTimeFlag reads the named flag from the command and parses it as an
+// RFC3339 timestamp, returning the zero time if the flag was not given.
+func parseTimeFlag(cmd *cobra.Command, name string) (time.Time, error) {
+	val, err := cmd.Flags().GetString(name)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "failed to read %s flag", name)
+	}
+
+	if val == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "failed to parse %s flag", name)
+	}
+
+	return t, nil
+}
+
+// atomicFile is a temporary file which replaces the file at path once
+// committed, so that readers never see a partially written file.
+type atomicFile struct {
+	*os.File
+	path string
+	done bool
+}
+
+// createAtomic creates a temporary file in the same directory as path, which
+// is renamed to path by Commit.
+func createAtomic(path string) (*atomicFile, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+
+	return &atomicFile{File: f, path: path}, nil
+}
+
+// Commit syncs and closes the temporary file, then renames it to the path.
+func (f *atomicFile) Commit() error {
+	if f.done {
+		return nil
+	}
+	f.done = true
+
+	err := f.Sync()
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	err = os.Rename(f.Name(), f.path)
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
+
+// Abort closes and removes the temporary file, unless it has already been
+// committed.
+func (f *atomicFile) Abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+
+	f.Close()
+	os.Remove(f.Name())
+}
@@ -0,0 +1,339 @@
+package tasks
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/DECODEproject/iotstore/pkg/archive"
+	"github.com/DECODEproject/iotstore/pkg/postgres"
+	"github.com/DECODEproject/iotstore/pkg/version"
+)
+
+const (
+	// defaultImportBatchSize is the default number of events we insert per
+	// transaction when importing.
+	defaultImportBatchSize = 500
+)
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+
+	importCmd.Flags().StringP("input", "i", "", "archive file to import, or - for stdin")
+	importCmd.MarkFlagRequired("input")
+	importCmd.Flags().StringP("format", "f", archive.NDJSON, fmt.Sprintf("archive format, one of: %s", strings.Join(archive.Formats, ", ")))
+	importCmd.Flags().String("dedupe", postgres.DedupeByHash, fmt.Sprintf("how duplicate events are detected, one of: %s, %s", postgres.DedupeByHash, postgres.DedupeByID))
+	importCmd.Flags().String("checkpoint", "", "file used to record progress so an interrupted import can be resumed (defaults to <input>.checkpoint, not supported for stdin)")
+	importCmd.Flags().Int("batch-size", defaultImportBatchSize, "number of events inserted per transaction")
+	importCmd.Flags().String("cold-storage-url", "", "cold storage location holding archived events, which are checked for duplicates")
+}
+
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import events from a portable archive",
+	Long: fmt.Sprintf(`This task loads an archive created by the export command back into the
+datastore, allowing communities to be moved between datastores or restored
+from a backup. Events keep the recorded_at timestamps they were originally
+recorded with.
+
+Duplicate events are skipped, so an archive may safely be imported more than
+once. With --dedupe=hash (the default) imported events are given new IDs, and
+an event is a duplicate if an event with the same community, device token,
+timestamp and data already exists. With --dedupe=id events keep their original
+IDs, and an event is a duplicate if an event with the same ID exists; this is
+intended for restoring a backup into the datastore it was exported from.
+Events archived to cold storage are checked for duplicates too, so if any
+imported events fall within archived segments the cold storage location must
+be given with --cold-storage-url. The import never changes the schema, so it
+fails if the database has not been migrated to the version it requires.
+
+Events are inserted in batches, and after each batch is committed the number
+of processed events is written to a checkpoint file, along with the SHA-256 of
+the archive. If an import is interrupted, running the same command again
+resumes from the last checkpoint, provided the archive is unchanged. The
+checkpoint file is removed once the import completes. As an archive read from
+stdin cannot be verified it cannot be resumed.
+
+For example:
+
+    $ %s import -i abc123.ndjson`, version.BinaryName),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		connStr, err := databaseURL()
+		if err != nil {
+			return err
+		}
+
+		input, err := cmd.Flags().GetString("input")
+		if err != nil {
+			return errors.Wrap(err, "failed to read required \"input\" parameter")
+		}
+
+		format, err := cmd.Flags().GetString("format")
+		if err != nil {
+			return errors.Wrap(err, "failed to read format flag")
+		}
+
+		dedupe, err := cmd.Flags().GetString("dedupe")
+		if err != nil {
+			return errors.Wrap(err, "failed to read dedupe flag")
+		}
+
+		if dedupe != postgres.DedupeByHash && dedupe != postgres.DedupeByID {
+			return fmt.Errorf("unsupported dedupe mode: %s", dedupe)
+		}
+
+		checkpointPath, err := cmd.Flags().GetString("checkpoint")
+		if err != nil {
+			return errors.Wrap(err, "failed to read checkpoint flag")
+		}
+
+		if input == "-" {
+			if checkpointPath != "" {
+				return errors.New("Checkpoints are not supported when importing from stdin")
+			}
+		} else if checkpointPath == "" {
+			checkpointPath = input + ".checkpoint"
+		}
+
+		batchSize, err := cmd.Flags().GetInt("batch-size")
+		if err != nil {
+			return errors.Wrap(err, "failed to read batch-size flag")
+		}
+
+		if batchSize <= 0 {
+			batchSize = defaultImportBatchSize
+		}
+
+		logger, err := newLogger(os.Stdout)
+		if err != nil {
+			return err
+		}
+
+		var (
+			in  io.Reader = os.Stdin
+			sum string
+		)
+
+		if input != "-" {
+			f, err := os.Open(input)
+			if err != nil {
+				return errors.Wrap(err, "failed to open input file")
+			}
+			defer f.Close()
+			in = f
+
+			// hash the archive so that we only resume a checkpoint written for
+			// this exact archive
+			hash := sha256.New()
+
+			_, err = io.Copy(hash, f)
+			if err != nil {
+				return errors.Wrap(err, "failed to read input file")
+			}
+
+			_, err = f.Seek(0, io.SeekStart)
+			if err != nil {
+				return errors.Wrap(err, "failed to read input file")
+			}
+
+			sum = hex.EncodeToString(hash.Sum(nil))
+		}
+
+		r, err := archive.NewReader(format, in)
+		if err != nil {
+			return err
+		}
+
+		cp, err := readCheckpoint(checkpointPath)
+		if err != nil {
+			return err
+		}
+
+		if cp.Processed > 0 && cp.SHA256 != sum {
+			return fmt.Errorf("checkpoint %s was written while importing a different archive, remove it to import this archive from the start", checkpointPath)
+		}
+
+		cp.SHA256 = sum
+
+		if cp.Processed > 0 {
+			logger.Log("msg", "resuming import from checkpoint", "checkpoint", checkpointPath, "processed", cp.Processed)
+		}
+
+		db := postgres.NewDB(
+			&postgres.Config{
+				ConnStr:        connStr,
+				ColdStorageURL: viper.GetString("cold-storage-url"),
+				NoAutoMigrate:  true,
+			},
+			logger,
+		)
+
+		err = db.Start()
+		if err != nil {
+			return err
+		}
+		defer db.Stop()
+
+		// cancel the in-flight batch on interrupt; everything up to the last
+		// checkpoint has been committed
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		interrupt := make(chan os.Signal, 1)
+		signal.Notify(interrupt, os.Interrupt)
+		defer signal.Stop(interrupt)
+
+		go func() {
+			select {
+			case <-interrupt:
+				logger.Log("msg", "interrupted, stopping import")
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		var (
+			position int64
+			batch    []*postgres.Event
+		)
+
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+
+			inserted, err := db.ImportData(ctx, batch, dedupe)
+			if err != nil {
+				return errors.Wrap(err, "failed to import events")
+			}
+
+			cp.Processed += int64(len(batch))
+			cp.Inserted += int64(inserted)
+			cp.Skipped += int64(len(batch) - inserted)
+			batch = nil
+
+			err = writeCheckpoint(checkpointPath, cp)
+			if err != nil {
+				return err
+			}
+
+			logger.Log("msg", "import progress", "processed", cp.Processed, "inserted", cp.Inserted, "skipped", cp.Skipped)
+
+			return nil
+		}
+
+		for {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return errors.Wrapf(err, "failed to read archive at record %d", position+1)
+			}
+
+			position++
+
+			// skip records committed before the import was interrupted
+			if position <= cp.Processed {
+				continue
+			}
+
+			batch = append(batch, &postgres.Event{
+				ID:          record.ID,
+				CommunityID: record.CommunityID,
+				DeviceToken: record.DeviceToken,
+				RecordedAt:  record.RecordedAt,
+				Data:        record.Data,
+			})
+
+			if len(batch) >= batchSize {
+				err = flush()
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		err = flush()
+		if err != nil {
+			return err
+		}
+
+		if checkpointPath != "" {
+			err = os.Remove(checkpointPath)
+			if err != nil && !os.IsNotExist(err) {
+				return errors.Wrap(err, "failed to remove checkpoint file")
+			}
+		}
+
+		logger.Log("msg", "import complete", "processed", cp.Processed, "inserted", cp.Inserted, "skipped", cp.Skipped)
+
+		return nil
+	},
+}
+
+// checkpoint records the progress of an import, so that it may be resumed. The
+// SHA-256 of the archive is recorded so that we never resume against an archive
+// which has been changed or replaced, which would silently skip records.
+type checkpoint struct {
+	SHA256    string `json:"sha256"`
+	Processed int64  `json:"processed"`
+	Inserted  int64  `json:"inserted"`
+	Skipped   int64  `json:"skipped"`
+}
+
+// readCheckpoint reads the checkpoint at the given path, returning an empty
+// checkpoint if the path is empty or the file does not exist.
+func readCheckpoint(path string) (*checkpoint, error) {
+	cp := &checkpoint{}
+
+	if path == "" {
+		return cp, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cp, nil
+		}
+		return nil, errors.Wrap(err, "failed to read checkpoint file")
+	}
+
+	err = json.Unmarshal(b, cp)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse checkpoint file")
+	}
+
+	return cp, nil
+}
+
+// writeCheckpoint atomically replaces the checkpoint at the given path. It is a
+// no-op if the path is empty.
+func writeCheckpoint(path string, cp *checkpoint) error {
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode checkpoint")
+	}
+
+	err = ioutil.WriteFile(path+".tmp", b, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to write checkpoint file")
+	}
+
+	return errors.Wrap(os.Rename(path+".tmp", path), "failed to write checkpoint file")
+}
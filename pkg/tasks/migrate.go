@@ -2,10 +2,13 @@ package tasks
 
 import (
 	"fmt"
+	"os"
+	"strconv"
+	"time"
 
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
-	"github.com/DECODEproject/iotstore/pkg/logger"
 	"github.com/DECODEproject/iotstore/pkg/postgres"
 	"github.com/DECODEproject/iotstore/pkg/version"
 )
@@ -14,10 +17,17 @@ func init() {
 	rootCmd.AddCommand(migrateCmd)
 	migrateCmd.AddCommand(migrateNewCmd)
 	migrateCmd.AddCommand(migrateDownCmd)
+	migrateCmd.AddCommand(migrateUpCmd)
+	migrateCmd.AddCommand(migrateGotoCmd)
+	migrateCmd.AddCommand(migrateForceCmd)
+	migrateCmd.AddCommand(migrateStatusCmd)
+	migrateCmd.AddCommand(migrateTimescaleCmd)
 
 	migrateNewCmd.Flags().String("dir", "pkg/migrations/sql", "The directory into which new migrations should be created")
+	migrateUpCmd.Flags().Uint("to", 0, "Version of the migration up to which we should migrate, zero runs all up migrations")
 	migrateDownCmd.Flags().IntP("steps", "s", 1, "Number of down migrations to run")
 	migrateDownCmd.Flags().Bool("all", false, "Boolean flag that if true runs all down migrations")
+	migrateTimescaleCmd.Flags().Duration("compress-after", 7*24*time.Hour, "Age after which TimescaleDB chunks are compressed, zero to disable")
 }
 
 var migrateCmd = &cobra.Command{
@@ -25,9 +35,11 @@ var migrateCmd = &cobra.Command{
 	Short: "Manage Postgres migrations",
 	Long: `This task provides subcommands for working with migrations for Postgres.
 
-Up migrations are run automatically when the application boots, but here we
-also offer commands to create properly named migration files, and a command
-to run down migrations.`,
+Up migrations are run automatically when the server boots unless it is given
+the --no-auto-migrate flag, but here we also offer commands to create properly
+named migration files, to show the current state of the schema, to run up or
+down migrations, to convert the events table into a TimescaleDB hypertable,
+and to recover from a migration which failed part way through.`,
 }
 
 var migrateNewCmd = &cobra.Command{
@@ -48,7 +60,10 @@ For example:
 			return err
 		}
 
-		logger := logger.NewLogger()
+		logger, err := newLogger(os.Stdout)
+		if err != nil {
+			return err
+		}
 
 		return postgres.NewMigration(dir, args[0], logger)
 	},
@@ -60,9 +75,13 @@ var migrateDownCmd = &cobra.Command{
 	Long: `This command can be used to rollback migrations executed against postgres. It
 takes as parameters: the number of steps to rollback (default 1), or a
 boolean flag (--all) indicating we should rollback all migrations. The
-default is to simply rollback one migration.`,
+default is to simply rollback one migration.
+
+If the events table has been converted into a TimescaleDB hypertable by the
+server's --timescale flag, migrations before the one partitioning the events
+table cannot be rolled back, as the conversion is not part of the migrations.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		connStr, err := GetFromEnv(ConnStrKey)
+		connStr, err := databaseURL()
 		if err != nil {
 			return err
 		}
@@ -77,7 +96,10 @@ default is to simply rollback one migration.`,
 			return err
 		}
 
-		logger := logger.NewLogger()
+		logger, err := newLogger(os.Stdout)
+		if err != nil {
+			return err
+		}
 
 		db, err := postgres.Open(connStr)
 		if err != nil {
@@ -91,3 +113,190 @@ default is to simply rollback one migration.`,
 		return postgres.MigrateDown(db.DB, steps, logger)
 	},
 }
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Run up migrations against Postgres",
+	Long: `This command runs the up migrations compiled into the binary against postgres,
+as the server does when it boots. By default all up migrations are run, or if
+--to is given, only those up to and including the migration with that
+version.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		connStr, err := databaseURL()
+		if err != nil {
+			return err
+		}
+
+		to, err := cmd.Flags().GetUint("to")
+		if err != nil {
+			return err
+		}
+
+		logger, err := newLogger(os.Stdout)
+		if err != nil {
+			return err
+		}
+
+		db, err := postgres.Open(connStr)
+		if err != nil {
+			return err
+		}
+
+		if to == 0 {
+			return postgres.MigrateUp(db.DB, logger)
+		}
+
+		return postgres.MigrateUpTo(db.DB, to, logger)
+	},
+}
+
+var migrateGotoCmd = &cobra.Command{
+	Use:   "goto VERSION",
+	Short: "Migrate Postgres to a specific version",
+	Long: fmt.Sprintf(`This command runs the up or down migrations required to bring the schema to
+the given version, which must be that of one of the migrations compiled into
+the binary (see the status subcommand).
+
+For example:
+
+    $ %s migrate goto 20180519220506`, version.BinaryName),
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		connStr, err := databaseURL()
+		if err != nil {
+			return err
+		}
+
+		to, err := strconv.ParseUint(args[0], 10, 64)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse version")
+		}
+
+		logger, err := newLogger(os.Stdout)
+		if err != nil {
+			return err
+		}
+
+		db, err := postgres.Open(connStr)
+		if err != nil {
+			return err
+		}
+
+		return postgres.MigrateTo(db.DB, uint(to), logger)
+	},
+}
+
+var migrateForceCmd = &cobra.Command{
+	Use:   "force VERSION",
+	Short: "Set the migration version of Postgres without running migrations",
+	Long: fmt.Sprintf(`If a migration fails part way through the schema is left dirty, and no
+further migrations can be run until it has been repaired. Once the changes
+made by the failed migration have been completed or undone by hand, this
+command records the schema as being at the given version and clears the
+dirty flag, without running any migrations. The version must be that of one
+of the migrations compiled into the binary, or -1 if no migrations have been
+applied.
+
+For example:
+
+    $ %s migrate force 20180519220506`, version.BinaryName),
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		connStr, err := databaseURL()
+		if err != nil {
+			return err
+		}
+
+		to, err := strconv.Atoi(args[0])
+		if err != nil {
+			return errors.Wrap(err, "failed to parse version")
+		}
+
+		logger, err := newLogger(os.Stdout)
+		if err != nil {
+			return err
+		}
+
+		db, err := postgres.Open(connStr)
+		if err != nil {
+			return err
+		}
+
+		return postgres.MigrateForce(db.DB, to, logger)
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the migration status of Postgres",
+	Long: `This command writes the current version of the schema, whether the most
+recent migration failed leaving the schema dirty, and the list of migrations
+compiled into the binary which have not yet been applied.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		connStr, err := databaseURL()
+		if err != nil {
+			return err
+		}
+
+		// log lines are written to stderr so as not to mix with the status
+		logger, err := newLogger(os.Stderr)
+		if err != nil {
+			return err
+		}
+
+		db, err := postgres.Open(connStr)
+		if err != nil {
+			return err
+		}
+
+		state, err := postgres.MigrationStatus(db.DB, logger)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("version: %d\n", state.Version)
+		fmt.Printf("dirty: %t\n", state.Dirty)
+		fmt.Printf("latest: %d\n", state.Latest)
+		fmt.Printf("pending: %d\n", len(state.Pending))
+
+		for _, migration := range state.Pending {
+			fmt.Printf("  %d %s\n", migration.Version, migration.Name)
+		}
+
+		return nil
+	},
+}
+
+var migrateTimescaleCmd = &cobra.Command{
+	Use:   "timescale",
+	Short: "Convert the events table into a TimescaleDB hypertable",
+	Long: `This command converts the events table into a TimescaleDB hypertable if the
+extension is available on the server, enabling compression of chunks older
+than --compress-after, as the server does when it boots with the --timescale
+flag. It is intended for servers run with --no-auto-migrate, which do not
+make the conversion themselves. The conversion is idempotent, so may safely be
+run more than once.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		connStr, err := databaseURL()
+		if err != nil {
+			return err
+		}
+
+		compressAfter, err := cmd.Flags().GetDuration("compress-after")
+		if err != nil {
+			return err
+		}
+
+		logger, err := newLogger(os.Stdout)
+		if err != nil {
+			return err
+		}
+
+		db, err := postgres.Open(connStr)
+		if err != nil {
+			return err
+		}
+
+		return postgres.MigrateTimescale(db.DB, compressAfter, logger)
+	},
+}
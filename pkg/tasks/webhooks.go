@@ -0,0 +1,184 @@
+package tasks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+
+	"github.com/DECODEproject/iotstore/pkg/postgres"
+	"github.com/DECODEproject/iotstore/pkg/version"
+	"github.com/DECODEproject/iotstore/pkg/webhook"
+)
+
+func init() {
+	rootCmd.AddCommand(webhooksCmd)
+	webhooksCmd.AddCommand(webhooksAddCmd)
+	webhooksCmd.AddCommand(webhooksListCmd)
+	webhooksCmd.AddCommand(webhooksRemoveCmd)
+
+	webhooksAddCmd.Flags().String("community-id", "", "the community whose events are delivered to the webhook")
+	webhooksAddCmd.MarkFlagRequired("community-id")
+	webhooksAddCmd.Flags().String("secret", "", "secret used to sign deliveries, a random secret is generated if not given")
+	webhooksListCmd.Flags().String("community-id", "", "only list the webhooks of this community")
+}
+
+var webhooksCmd = &cobra.Command{
+	Use:   "webhooks",
+	Short: "Manage webhooks notified of new events",
+	Long: fmt.Sprintf(`This task provides subcommands for managing the webhooks to which events are
+delivered as they are written.
+
+Each event written to a community is POSTed to each of its webhooks as JSON
+containing the community ID, device token, recorded at timestamp and base64
+encoded data of the event. The body is signed with the webhook's secret, the
+%s header containing sha256= followed by the hex encoded HMAC-SHA256
+of the body. Deliveries are retried until the webhook responds with a 2xx
+status, so may be received more than once, in which case the %s
+header is the same for each. Imported events are not delivered.`, webhook.SignatureHeader, webhook.DeliveryHeader),
+}
+
+var webhooksAddCmd = &cobra.Command{
+	Use:   "add <url>",
+	Short: "Add a webhook",
+	Long: fmt.Sprintf(`This command subscribes a URL to the events written to a community, printing
+the ID and secret of the new webhook.
+
+For example:
+
+    $ %s webhooks add --community-id abc123 https://example.com/hook`, version.BinaryName),
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		communityID, err := cmd.Flags().GetString("community-id")
+		if err != nil {
+			return errors.Wrap(err, "failed to read required \"community-id\" parameter")
+		}
+
+		secret, err := cmd.Flags().GetString("secret")
+		if err != nil {
+			return errors.Wrap(err, "failed to read secret flag")
+		}
+
+		u, err := url.Parse(args[0])
+		if err != nil {
+			return errors.Wrap(err, "failed to parse webhook url")
+		}
+
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return errors.New("webhook url must be http or https")
+		}
+
+		if secret == "" {
+			b := make([]byte, 32)
+
+			_, err = rand.Read(b)
+			if err != nil {
+				return errors.Wrap(err, "failed to generate secret")
+			}
+
+			secret = hex.EncodeToString(b)
+		}
+
+		db, err := startDB()
+		if err != nil {
+			return err
+		}
+		defer db.Stop()
+
+		hook, err := db.CreateWebhook(context.Background(), communityID, u.String(), secret)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("id: %d\nsecret: %s\n", hook.ID, hook.Secret)
+
+		return nil
+	},
+}
+
+var webhooksListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List webhooks",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		communityID, err := cmd.Flags().GetString("community-id")
+		if err != nil {
+			return errors.Wrap(err, "failed to read community-id flag")
+		}
+
+		db, err := startDB()
+		if err != nil {
+			return err
+		}
+		defer db.Stop()
+
+		hooks, err := db.Webhooks(context.Background(), communityID)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tCOMMUNITY\tURL\tCREATED")
+
+		for _, hook := range hooks {
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", hook.ID, hook.CommunityID, hook.URL, hook.CreatedAt.UTC().Format(time.RFC3339))
+		}
+
+		return w.Flush()
+	},
+}
+
+var webhooksRemoveCmd = &cobra.Command{
+	Use:   "remove <id>",
+	Short: "Remove a webhook along with its pending deliveries",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse webhook id")
+		}
+
+		db, err := startDB()
+		if err != nil {
+			return err
+		}
+		defer db.Stop()
+
+		return db.DeleteWebhook(context.Background(), id)
+	},
+}
+
+// startDB connects to the database given by the environment, logging to stderr
+// so that the output of the command is not interleaved with log messages.
+func startDB() (*postgres.DB, error) {
+	connStr, err := databaseURL()
+	if err != nil {
+		return nil, err
+	}
+
+	logger, err := newLogger(os.Stderr)
+	if err != nil {
+		return nil, err
+	}
+
+	db := postgres.NewDB(
+		&postgres.Config{
+			ConnStr: connStr,
+		},
+		logger,
+	)
+
+	err = db.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/DECODEproject/iotcommon/middleware"
+	"github.com/twitchtv/twirp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/DECODEproject/iotstore/pkg/redact"
+)
+
+type contextKey int
+
+// rpcSpanKey is the context key under which the span started for an RPC is
+// stored, so that only that span is ended once the response is sent.
+const rpcSpanKey contextKey = iota
+
+// statusWriter wraps http.ResponseWriter to capture the status code.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Middleware is a net.http middleware that wraps each request in a server
+// span, continuing the caller's trace if the request carries a traceparent
+// header. It must be installed after middleware.RequestIDMiddleware so that
+// the request ID can be attached to the span.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := tracer().Start(ctx, "HTTP "+r.Method, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		if !span.IsRecording() {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		span.SetAttributes(
+			attribute.String("http.method", r.Method),
+			attribute.String("http.target", redact.Path(r.URL.Path)),
+		)
+
+		if rid, ok := r.Context().Value(middleware.RequestCtxKey).(string); ok {
+			span.SetAttributes(attribute.String("request.id", rid))
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.status_code", sw.status))
+		if sw.status >= http.StatusInternalServerError {
+			setError(span, httpError(sw.status))
+		}
+	})
+}
+
+// httpError is the error recorded against a span when a request fails with a
+// 5xx status.
+type httpError int
+
+func (e httpError) Error() string {
+	return http.StatusText(int(e))
+}
+
+// ServerHooks returns twirp hooks that wrap each routed RPC in a span, a child
+// of the HTTP request's span.
+func ServerHooks() *twirp.ServerHooks {
+	return &twirp.ServerHooks{
+		RequestRouted: func(ctx context.Context) (context.Context, error) {
+			service, _ := twirp.ServiceName(ctx)
+			method, _ := twirp.MethodName(ctx)
+
+			ctx, span := tracer().Start(ctx, service+"/"+method, trace.WithAttributes(
+				attribute.String("rpc.system", "twirp"),
+				attribute.String("rpc.service", service),
+				attribute.String("rpc.method", method),
+			))
+
+			return context.WithValue(ctx, rpcSpanKey, span), nil
+		},
+		Error: func(ctx context.Context, err twirp.Error) context.Context {
+			span := trace.SpanFromContext(ctx)
+			span.SetAttributes(attribute.String("rpc.twirp.error_code", string(err.Code())))
+			setError(span, err)
+			return ctx
+		},
+		ResponseSent: func(ctx context.Context) {
+			if span, ok := ctx.Value(rpcSpanKey).(trace.Span); ok {
+				span.End()
+			}
+		},
+	}
+}
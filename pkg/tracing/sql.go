@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// WrapDriver returns a database/sql driver that records a span for each query
+// or statement executed with a context containing a span, as its child. The
+// wrapped driver's connections must support the context aware query, exec and
+// transaction interfaces, as lib/pq's do.
+func WrapDriver(d driver.Driver) driver.Driver {
+	return &tracingDriver{Driver: d}
+}
+
+type tracingDriver struct {
+	driver.Driver
+}
+
+func (d *tracingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tracingConn{Conn: conn}, nil
+}
+
+// tracingConn wraps a driver connection. Prepared statements, which we only
+// use for COPY, are not traced.
+type tracingConn struct {
+	driver.Conn
+}
+
+func (c *tracingConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	return c.Conn.(driver.ConnBeginTx).BeginTx(ctx, opts)
+}
+
+func (c *tracingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	defer span.End()
+
+	rows, err := c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+	setError(span, err)
+
+	return rows, err
+}
+
+func (c *tracingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	ctx, span := startQuerySpan(ctx, query)
+	defer span.End()
+
+	result, err := c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+	setError(span, err)
+
+	return result, err
+}
+
+// startQuerySpan starts a span for a query, named after the SQL command. The
+// span covers executing the query and receiving the first rows. Queries are
+// only traced as part of an existing trace, so that background tasks polling
+// the database do not each start a trace of their own.
+func startQuerySpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+
+	name := "SQL"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		name = strings.ToUpper(fields[0])
+	}
+
+	return tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.statement", query),
+	))
+}
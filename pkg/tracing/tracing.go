@@ -0,0 +1,177 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/DECODEproject/iotstore/pkg/version"
+)
+
+const (
+	// Stdout is the exporter writing each span to stdout as a line of JSON.
+	Stdout = "stdout"
+
+	// OTLP is the exporter sending spans to an OpenTelemetry collector using
+	// OTLP over HTTP.
+	OTLP = "otlp"
+
+	// instrumentationName identifies the spans we record for requests and
+	// queries.
+	instrumentationName = "github.com/DECODEproject/iotstore/pkg/tracing"
+
+	// shutdownTimeout is the maximum time we wait for queued spans to be
+	// exported when stopping.
+	shutdownTimeout = 5 * time.Second
+)
+
+// stdout is where the stdout exporter writes spans.
+var stdout io.Writer = os.Stdout
+
+func init() {
+	// spans are propagated using W3C Trace Context headers whether or not we
+	// record them ourselves
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+}
+
+// Config is a struct used to pass configuration into our Tracer instance.
+type Config struct {
+	// Exporter is either Stdout or OTLP.
+	Exporter string
+
+	// Endpoint is the base URL of the OpenTelemetry collector to which spans
+	// are sent by the OTLP exporter, e.g. http://collector:4318.
+	Endpoint string
+
+	// ServiceName identifies us in exported spans.
+	ServiceName string
+
+	// SampleRatio is the fraction of traces started by us that are sampled.
+	// Traces started by our callers are sampled according to their decision.
+	SampleRatio float64
+}
+
+// Tracer owns the OpenTelemetry tracer provider recording our spans, which
+// exports them in batches in the background.
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+	logger   kitlog.Logger
+}
+
+// NewTracer returns a new Tracer instance for the given configuration,
+// returning an error if the exporter is not known.
+func NewTracer(config *Config, logger kitlog.Logger) (*Tracer, error) {
+	exporter, err := newExporter(config)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = version.BinaryName
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceName(serviceName),
+			semconv.ServiceVersion(version.Version),
+		)),
+	)
+
+	return &Tracer{
+		provider: provider,
+		logger:   kitlog.With(logger, "module", "tracing"),
+	}, nil
+}
+
+// newExporter returns the span exporter for the given configuration.
+func newExporter(config *Config) (sdktrace.SpanExporter, error) {
+	switch config.Exporter {
+	case Stdout:
+		return stdouttrace.New(stdouttrace.WithWriter(stdout))
+	case OTLP:
+		if config.Endpoint == "" {
+			return nil, errors.New("the otlp exporter requires an endpoint")
+		}
+
+		u, err := url.Parse(config.Endpoint)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse otlp endpoint")
+		}
+
+		opts := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(u.Host),
+			otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/") + "/v1/traces"),
+		}
+
+		if u.Scheme == "http" {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+
+		return otlptracehttp.New(context.Background(), opts...)
+	default:
+		return nil, errors.Errorf("unknown trace exporter: %s", config.Exporter)
+	}
+}
+
+// Start makes the tracer the global OpenTelemetry tracer provider, so that
+// spans started anywhere are recorded by it. Errors exporting spans are logged.
+func (t *Tracer) Start() {
+	t.logger.Log("msg", "starting tracer")
+
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		t.logger.Log("msg", "tracing error", "err", err)
+	}))
+
+	otel.SetTracerProvider(t.provider)
+}
+
+// Stop disables tracing, exporting any spans still queued.
+func (t *Tracer) Stop() {
+	t.logger.Log("msg", "stopping tracer")
+
+	otel.SetTracerProvider(noop.NewTracerProvider())
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := t.provider.Shutdown(ctx)
+	if err != nil {
+		t.logger.Log("msg", "failed to export spans", "err", err)
+	}
+}
+
+// tracer returns the tracer used to record our spans, from the current global
+// tracer provider.
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// setError marks the span as having failed with the given error, if any.
+func setError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
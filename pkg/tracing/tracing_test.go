@@ -0,0 +1,149 @@
+package tracing_test
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/DECODEproject/iotcommon/middleware"
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/DECODEproject/iotstore/pkg/tracing"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+// attribute returns the value of the span's attribute with the given key as a
+// string, or an empty string if it is not set.
+func attribute(span *tracepb.Span, key string) string {
+	for _, a := range span.Attributes {
+		if a.Key == key {
+			switch v := a.Value.Value.(type) {
+			case *commonpb.AnyValue_StringValue:
+				return v.StringValue
+			case *commonpb.AnyValue_IntValue:
+				return strconv.FormatInt(v.IntValue, 10)
+			}
+		}
+	}
+	return ""
+}
+
+func TestMiddlewareOTLP(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		spans = map[string]*tracepb.Span{}
+	)
+
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+
+		body, err := ioutil.ReadAll(r.Body)
+		assert.Nil(t, err)
+
+		var payload collectorpb.ExportTraceServiceRequest
+
+		err = proto.Unmarshal(body, &payload)
+		assert.Nil(t, err)
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, rs := range payload.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					spans[s.Name] = s
+				}
+			}
+		}
+	}))
+	defer collector.Close()
+
+	tracer, err := tracing.NewTracer(&tracing.Config{Exporter: tracing.OTLP, Endpoint: collector.URL, SampleRatio: 1}, kitlog.NewNopLogger())
+	assert.Nil(t, err)
+
+	tracer.Start()
+
+	handler := middleware.RequestIDMiddleware(tracing.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := otel.Tracer("test").Start(r.Context(), "child")
+		span.End()
+
+		otel.GetTextMapPropagator().Inject(r.Context(), propagation.HeaderCarrier(w.Header()))
+		w.WriteHeader(http.StatusInternalServerError)
+	})))
+
+	req := httptest.NewRequest(http.MethodGet, "/pulse", nil)
+	req.Header.Set("traceparent", traceparent)
+	req.Header.Set(middleware.RequestIDHeader, "request-1")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	tracer.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	server := spans["HTTP GET"]
+	if server == nil {
+		t.Fatalf("Server span not exported")
+	}
+
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", hex.EncodeToString(server.TraceId))
+	assert.Equal(t, "00f067aa0ba902b7", hex.EncodeToString(server.ParentSpanId))
+	assert.Equal(t, tracepb.Span_SPAN_KIND_SERVER, server.Kind)
+	assert.Equal(t, "request-1", attribute(server, "request.id"))
+	assert.Equal(t, "/pulse", attribute(server, "http.target"))
+	assert.Equal(t, "500", attribute(server, "http.status_code"))
+	assert.Equal(t, tracepb.Status_STATUS_CODE_ERROR, server.Status.Code)
+
+	child := spans["child"]
+	if child == nil {
+		t.Fatalf("Child span not exported")
+	}
+
+	assert.Equal(t, server.TraceId, child.TraceId)
+	assert.Equal(t, server.SpanId, child.ParentSpanId)
+
+	// the server span is propagated to anything we call
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-"+hex.EncodeToString(server.SpanId)+"-01", w.Header().Get("traceparent"))
+}
+
+func TestMiddlewareDisabled(t *testing.T) {
+	var sc trace.SpanContext
+
+	handler := tracing.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sc = trace.SpanContextFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/pulse", nil)
+	req.Header.Set("traceparent", traceparent)
+
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+
+	// without a tracer spans are not recorded, but the caller's trace is still
+	// propagated
+	assert.True(t, sc.IsValid())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID().String())
+	assert.True(t, sc.IsSampled())
+}
+
+func TestNewTracerInvalidExporter(t *testing.T) {
+	_, err := tracing.NewTracer(&tracing.Config{Exporter: "zipkin"}, kitlog.NewNopLogger())
+	assert.NotNil(t, err)
+
+	_, err = tracing.NewTracer(&tracing.Config{Exporter: tracing.OTLP}, kitlog.NewNopLogger())
+	assert.NotNil(t, err)
+}
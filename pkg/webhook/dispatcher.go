@@ -0,0 +1,403 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/lestrrat-go/backoff"
+	backoffv2 "github.com/lestrrat-go/backoff/v2"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	registry "github.com/thingful/retryable-registry-prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/DECODEproject/iotstore/pkg/postgres"
+	"github.com/DECODEproject/iotstore/pkg/version"
+)
+
+const (
+	// SignatureHeader is the header containing the signature of the request
+	// body, in the form sha256=<hex encoded HMAC-SHA256 keyed by the secret>.
+	SignatureHeader = "X-Iotstore-Signature"
+
+	// DeliveryHeader is the header containing the ID of the delivery, which is
+	// the same for every attempt so can be used to ignore duplicates.
+	DeliveryHeader = "X-Iotstore-Delivery"
+
+	// DefaultMaxAttempts is the number of attempts we make to deliver an event
+	// before giving up on it if not otherwise configured.
+	DefaultMaxAttempts = 10
+
+	// defaultPollInterval is how often we check the outbox for deliveries.
+	defaultPollInterval = time.Second
+
+	// defaultRetryInterval is the initial interval between the quick retries
+	// made before a failed delivery is put back in the outbox.
+	defaultRetryInterval = time.Second
+
+	// quickRetries is the number of times we immediately retry a delivery.
+	quickRetries = 2
+
+	// batchSize is the maximum number of deliveries we claim at once, all of
+	// which are delivered concurrently.
+	batchSize = 50
+
+	// lease is how long claimed deliveries are hidden from other instances,
+	// which must be longer than it takes to make all the quick retries.
+	lease = 5 * time.Minute
+
+	// instrumentationName identifies the spans we record for deliveries.
+	instrumentationName = "github.com/DECODEproject/iotstore/pkg/webhook"
+
+	// requestTimeout is the maximum time we wait for a webhook to respond.
+	requestTimeout = 10 * time.Second
+
+	// minRetryIn and maxRetryIn bound the exponential backoff we apply between
+	// each failed attempt in the outbox.
+	minRetryIn = time.Minute
+	maxRetryIn = 6 * time.Hour
+
+	// retryJitter is the jitter factor applied to the delay between attempts,
+	// so deliveries that failed together are spread out.
+	retryJitter = 0.05
+)
+
+var (
+	// deliveries is a counter recording the outcome of each attempt to deliver
+	// an event, i.e. whether it was delivered, failed and will be retried, or
+	// failed and was abandoned.
+	deliveries = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "decode",
+			Subsystem: "datastore",
+			Name:      "webhook_deliveries_total",
+			Help:      "Number of webhook delivery attempts by result",
+		}, []string{"result"},
+	)
+)
+
+func init() {
+	registry.MustRegister(deliveries)
+}
+
+// Store is the part of the database interface used to manage the outbox of
+// deliveries, normally satisfied by postgres.DB.
+type Store interface {
+	ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*postgres.Delivery, error)
+	CompleteDelivery(ctx context.Context, id int64) error
+	FailDelivery(ctx context.Context, id int64, retryIn time.Duration, reason string) error
+}
+
+// Config is a struct used to pass configuration into our Dispatcher instance.
+type Config struct {
+	// MaxAttempts is the number of attempts made to deliver an event before we
+	// give up on it.
+	MaxAttempts int
+
+	// PollInterval is how often we check the outbox for deliveries.
+	PollInterval time.Duration
+
+	// RetryInterval is the initial interval between quick retries.
+	RetryInterval time.Duration
+}
+
+// Payload is the JSON body POSTed to a webhook for each event.
+type Payload struct {
+	CommunityID string    `json:"communityId"`
+	DeviceToken string    `json:"deviceToken"`
+	RecordedAt  time.Time `json:"recordedAt"`
+	Data        []byte    `json:"data"`
+}
+
+// Sign returns the value of the signature header for the given body, allowing
+// the receiver to check the request came from us.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher delivers the events queued in the outbox to their webhooks. An
+// event counts as delivered once the webhook responds with a 2xx status. We
+// retry a failed delivery a couple of times straight away, and then put it
+// back in the outbox to be attempted again with exponential backoff. As
+// deliveries are only removed from the outbox once delivered each event is
+// delivered at least once, but may be delivered more than once or out of
+// order.
+type Dispatcher struct {
+	store         Store
+	client        *http.Client
+	maxAttempts   int
+	pollInterval  time.Duration
+	retryInterval time.Duration
+	logger        kitlog.Logger
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher returns a new Dispatcher instance delivering the deliveries
+// queued in the given store.
+func NewDispatcher(config *Config, store Store, logger kitlog.Logger) *Dispatcher {
+	maxAttempts := config.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+
+	pollInterval := config.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+
+	retryInterval := config.RetryInterval
+	if retryInterval <= 0 {
+		retryInterval = defaultRetryInterval
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Dispatcher{
+		store:         store,
+		client:        &http.Client{Timeout: requestTimeout},
+		maxAttempts:   maxAttempts,
+		pollInterval:  pollInterval,
+		retryInterval: retryInterval,
+		logger:        kitlog.With(logger, "module", "webhook"),
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+// Start starts delivering events in the background.
+func (d *Dispatcher) Start() {
+	d.logger.Log("msg", "starting webhook dispatcher", "maxAttempts", d.maxAttempts)
+
+	d.wg.Add(1)
+	go d.run()
+}
+
+// Stop stops delivering events, abandoning any deliveries in progress. These
+// are attempted again once their lease expires.
+func (d *Dispatcher) Stop() {
+	d.logger.Log("msg", "stopping webhook dispatcher")
+
+	d.cancel()
+	d.wg.Wait()
+}
+
+// run polls the outbox until the dispatcher is stopped, polling again straight
+// away while there are more deliveries waiting.
+func (d *Dispatcher) run() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(d.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		claimed, err := d.poll()
+		if err != nil {
+			d.logger.Log("msg", "failed to poll webhook deliveries", "err", err)
+		}
+
+		if claimed == batchSize {
+			continue
+		}
+
+		select {
+		case <-ticker.C:
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+// poll claims a batch of deliveries, delivering them concurrently and
+// returning the number claimed.
+func (d *Dispatcher) poll() (int, error) {
+	batch, err := d.store.ClaimDeliveries(d.ctx, batchSize, lease)
+	if err != nil {
+		return 0, err
+	}
+
+	var wg sync.WaitGroup
+
+	for _, delivery := range batch {
+		wg.Add(1)
+		go func(delivery *postgres.Delivery) {
+			defer wg.Done()
+			d.deliver(delivery)
+		}(delivery)
+	}
+
+	wg.Wait()
+
+	return len(batch), nil
+}
+
+// deliver makes a single attempt to deliver an event, including any quick
+// retries, and records the outcome in the outbox.
+func (d *Dispatcher) deliver(delivery *postgres.Delivery) {
+	body, err := json.Marshal(&Payload{
+		CommunityID: delivery.CommunityID,
+		DeviceToken: delivery.DeviceToken,
+		RecordedAt:  delivery.RecordedAt,
+		Data:        delivery.Data,
+	})
+	if err != nil {
+		d.logger.Log("msg", "failed to encode webhook payload", "err", err)
+		return
+	}
+
+	var lastErr error
+
+	policy := backoff.NewExponential(
+		backoff.WithInterval(d.retryInterval),
+		backoff.WithJitterFactor(retryJitter),
+		backoff.WithMaxRetries(quickRetries),
+	)
+
+	err = backoff.Retry(d.ctx, policy, backoff.ExecuteFunc(func(ctx context.Context) error {
+		lastErr = d.post(ctx, delivery, body)
+		return lastErr
+	}))
+
+	select {
+	case <-d.ctx.Done():
+		// we are stopping, so leave the delivery for when the lease expires
+		if err != nil {
+			return
+		}
+	default:
+	}
+
+	// record the outcome even if we are stopping, so a delivered event is not
+	// delivered again
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	if err == nil {
+		deliveries.WithLabelValues("delivered").Inc()
+
+		err = d.store.CompleteDelivery(ctx, delivery.ID)
+		if err != nil {
+			d.logger.Log("msg", "failed to complete webhook delivery", "id", delivery.ID, "err", err)
+		}
+		return
+	}
+
+	if lastErr != nil {
+		err = lastErr
+	}
+
+	if delivery.Attempts >= d.maxAttempts {
+		deliveries.WithLabelValues("abandoned").Inc()
+
+		d.logger.Log("msg", "abandoning webhook delivery", "id", delivery.ID, "webhookID", delivery.WebhookID, "attempts", delivery.Attempts, "err", err)
+
+		err = d.store.FailDelivery(ctx, delivery.ID, 0, err.Error())
+		if err != nil {
+			d.logger.Log("msg", "failed to abandon webhook delivery", "id", delivery.ID, "err", err)
+		}
+		return
+	}
+
+	deliveries.WithLabelValues("failed").Inc()
+
+	retryIn := retryDelay(delivery.Attempts)
+
+	d.logger.Log("msg", "webhook delivery failed", "id", delivery.ID, "webhookID", delivery.WebhookID, "attempts", delivery.Attempts, "retryIn", retryIn, "err", err)
+
+	err = d.store.FailDelivery(ctx, delivery.ID, retryIn, err.Error())
+	if err != nil {
+		d.logger.Log("msg", "failed to record webhook delivery failure", "id", delivery.ID, "err", err)
+	}
+}
+
+// post sends the body to the webhook, returning an error unless the webhook
+// responds with a 2xx status. Client errors are not retried straight away as
+// they are unlikely to be fixed within seconds.
+func (d *Dispatcher) post(ctx context.Context, delivery *postgres.Delivery, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(body))
+	if err != nil {
+		return backoff.MarkPermanent(errors.Wrap(err, "failed to create webhook request"))
+	}
+
+	ctx, span := otel.Tracer(instrumentationName).Start(ctx, "POST webhook",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.Int64("webhook.id", delivery.WebhookID),
+			attribute.Int64("webhook.delivery_id", delivery.ID),
+		),
+	)
+	defer span.End()
+
+	req = req.WithContext(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", version.BinaryName+"/"+version.Version)
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, body))
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return errors.Wrap(err, "failed to send webhook request")
+	}
+	defer resp.Body.Close()
+
+	// drain some of the body so that the connection can be reused
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4096))
+
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = errors.Errorf("webhook responded with status %d", resp.StatusCode)
+		span.SetStatus(codes.Error, err.Error())
+
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return backoff.MarkPermanent(err)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// retryDelay returns how long to wait before attempting a delivery again after
+// the given number of failed attempts. As the delay is recorded in the outbox
+// rather than waited for, we take the interval the exponential backoff would
+// wait after that many attempts.
+func retryDelay(attempts int) time.Duration {
+	interval := backoffv2.NewExponentialInterval(
+		backoffv2.WithMinInterval(minRetryIn),
+		backoffv2.WithMaxInterval(maxRetryIn),
+		backoffv2.WithMultiplier(2),
+		backoffv2.WithJitterFactor(retryJitter),
+	)
+
+	delay := interval.Next()
+
+	for i := 1; i < attempts; i++ {
+		delay = interval.Next()
+	}
+
+	return delay
+}
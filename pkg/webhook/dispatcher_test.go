@@ -0,0 +1,173 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/DECODEproject/iotstore/pkg/postgres"
+	"github.com/DECODEproject/iotstore/pkg/webhook"
+)
+
+// fakeStore is an in memory outbox, recording the outcome of each delivery.
+type fakeStore struct {
+	sync.Mutex
+	pending   []*postgres.Delivery
+	completed []int64
+	failed    map[int64]time.Duration
+}
+
+func (f *fakeStore) ClaimDeliveries(ctx context.Context, limit int, lease time.Duration) ([]*postgres.Delivery, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	claimed := f.pending
+	f.pending = nil
+
+	for _, d := range claimed {
+		d.Attempts++
+	}
+
+	return claimed, nil
+}
+
+func (f *fakeStore) CompleteDelivery(ctx context.Context, id int64) error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.completed = append(f.completed, id)
+	return nil
+}
+
+func (f *fakeStore) FailDelivery(ctx context.Context, id int64, retryIn time.Duration, reason string) error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.failed[id] = retryIn
+	return nil
+}
+
+// wait waits for count deliveries to have been completed or failed.
+func (f *fakeStore) wait(t *testing.T, count int) {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		f.Lock()
+		done := len(f.completed) + len(f.failed)
+		f.Unlock()
+
+		if done >= count {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("Timed out waiting for %d deliveries", count)
+}
+
+func TestDispatcher(t *testing.T) {
+	recordedAt := time.Date(2019, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var (
+		mu       sync.Mutex
+		requests int
+		payload  webhook.Payload
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		body, err := ioutil.ReadAll(r.Body)
+		assert.Nil(t, err)
+
+		switch r.URL.Path {
+		case "/flaky":
+			assert.Equal(t, webhook.Sign("secret", body), r.Header.Get(webhook.SignatureHeader))
+			assert.Equal(t, "1", r.Header.Get(webhook.DeliveryHeader))
+
+			// fail the first attempt, which should be retried straight away
+			requests++
+			if requests == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+
+			err = json.Unmarshal(body, &payload)
+			assert.Nil(t, err)
+			w.WriteHeader(http.StatusNoContent)
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		}
+	}))
+	defer srv.Close()
+
+	store := &fakeStore{
+		pending: []*postgres.Delivery{
+			{
+				ID:          1,
+				URL:         srv.URL + "/flaky",
+				Secret:      "secret",
+				CommunityID: "abc123",
+				DeviceToken: "device-1",
+				Data:        []byte("hello"),
+				RecordedAt:  recordedAt,
+			},
+			{
+				ID:     2,
+				URL:    srv.URL + "/gone",
+				Secret: "secret",
+			},
+			{
+				ID:       3,
+				URL:      srv.URL + "/gone",
+				Secret:   "secret",
+				Attempts: 2,
+			},
+		},
+		failed: map[int64]time.Duration{},
+	}
+
+	d := webhook.NewDispatcher(
+		&webhook.Config{
+			MaxAttempts:   3,
+			PollInterval:  10 * time.Millisecond,
+			RetryInterval: 10 * time.Millisecond,
+		},
+		store,
+		kitlog.NewNopLogger(),
+	)
+
+	d.Start()
+	store.wait(t, 3)
+	d.Stop()
+
+	assert.Equal(t, []int64{1}, store.completed)
+	assert.Equal(t, 2, requests)
+	assert.Equal(t, "abc123", payload.CommunityID)
+	assert.Equal(t, "device-1", payload.DeviceToken)
+	assert.Equal(t, "hello", string(payload.Data))
+	assert.True(t, recordedAt.Equal(payload.RecordedAt))
+
+	// a failed delivery is put back in the outbox with some jitter, unless it
+	// has run out of attempts
+	assert.InDelta(t, float64(time.Minute), float64(store.failed[2]), float64(3*time.Second+1))
+	assert.Equal(t, time.Duration(0), store.failed[3])
+}
+
+func TestSign(t *testing.T) {
+	assert.Equal(
+		t,
+		"sha256=f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8",
+		webhook.Sign("key", []byte("The quick brown fox jumps over the lazy dog")),
+	)
+}